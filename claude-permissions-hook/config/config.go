@@ -4,26 +4,238 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"slices"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
 
 // Config is the root configuration structure
 type Config struct {
-	Audit           AuditConfig `toml:"audit"`
-	Allow           []Rule      `toml:"allow"`
-	Deny            []Rule      `toml:"deny"`
-	SubcommandTools []string    `toml:"subcommand_tools"`
-	Bash            *BashConfig `toml:"bash"`
+	Audit           AuditConfig       `toml:"audit"`
+	Allow           []Rule            `toml:"allow"`
+	Deny            []Rule            `toml:"deny"`
+	SubcommandTools []string          `toml:"subcommand_tools"`
+	SignatureDepth  map[string]int    `toml:"signature_depth"` // Subcommand levels per tool, e.g. {az = 3}
+	Aliases         map[string]string `toml:"aliases"`         // Alias -> canonical command, e.g. python3 = "python"
+	Bash            *BashConfig       `toml:"bash"`
+	Paths           *PathConfig       `toml:"paths"`
+	FailMode        string            `toml:"fail_mode"`      // "open" (default) or "closed"
+	AskQueueFile    string            `toml:"ask_queue_file"` // Append ask decisions here for out-of-band review
+	MCP             []MCPTool         `toml:"mcp"`
+	Runners         []Runner          `toml:"runner"`
+	Settings        Settings          `toml:"settings"`
+	Defaults        map[string]string `toml:"defaults"` // Tool -> decision when no rule matches, "default" for other tools
+
+	// Warnings lists the limits the config exceeds, set by Compile. They
+	// don't stop the config from loading.
+	Warnings []string `toml:"-"`
+}
+
+// Settings holds global matching behavior
+type Settings struct {
+	// DenyListMode allows anything that no deny rule matched instead of
+	// passing it through to Claude's prompt. Less safe than the default.
+	DenyListMode bool `toml:"deny_list_mode"`
+
+	// GitHookEnv lists environment variables whose presence means the hook
+	// runs inside a git hook, for rules with in_git_hook (default ["GIT_DIR"])
+	GitHookEnv []string `toml:"git_hook_env"`
+
+	// ParseCacheSize keeps this many parsed commands so repeated commands
+	// aren't parsed again (0, the default, disables the cache)
+	ParseCacheSize int `toml:"parse_cache_size"`
+
+	// MaxReasonLength caps the characters of the reason shown to Claude
+	// (0, the default, means DefaultMaxReasonLength)
+	MaxReasonLength int `toml:"max_reason_length"`
+
+	// ParseFailure is the decision for commands the shell parser can't
+	// parse: "ask" (default) or "deny"
+	ParseFailure string `toml:"parse_failure"`
+
+	// CompoundMode decides which commands of a compound statement must be
+	// allowed: "all" (default) or "any_reached", which skips || fallbacks
+	CompoundMode string `toml:"compound_mode"`
+
+	// MaxPatternLength and MaxRules are the sizes above which a pattern or
+	// the rule count is warned about, to catch generated configs that
+	// balloon (0, the default, means DefaultMaxPatternLength and
+	// DefaultMaxRules)
+	MaxPatternLength int `toml:"max_pattern_length"`
+	MaxRules         int `toml:"max_rules"`
+
+	// RestrictOutsideCwd gives writes outside the session's working
+	// directory, by the Write and Edit tools or Bash output redirects, the
+	// OutsideCwdDecision: "ask" (default) or "deny"
+	RestrictOutsideCwd bool   `toml:"restrict_outside_cwd"`
+	OutsideCwdDecision string `toml:"outside_cwd_decision"`
+
+	// BlockRawIPHosts denies WebFetch URLs whose host is an IP address or
+	// localhost, so a domain allowlist can't be bypassed and fetches can't
+	// reach internal services
+	BlockRawIPHosts bool `toml:"block_raw_ip_hosts"`
+
+	// DenyExitCode makes run also exit with this code after writing a deny
+	// decision, for Claude Code versions that block on exit code 2 (0, the
+	// default, exits normally)
+	DenyExitCode int `toml:"deny_exit_code"`
+}
+
+// Compound modes for settings.compound_mode
+const (
+	CompoundAll        = "all"         // Every command must be allowed
+	CompoundAnyReached = "any_reached" // Only commands run when everything before them succeeds
+)
+
+// DefaultMaxReasonLength is the reason length cap when none is configured
+const DefaultMaxReasonLength = 500
+
+// Default warning thresholds for config size
+const (
+	DefaultMaxPatternLength = 1000
+	DefaultMaxRules         = 1000
+)
+
+// PatternLengthLimit returns the configured pattern length threshold or the
+// default
+func (s Settings) PatternLengthLimit() int {
+	if s.MaxPatternLength == 0 {
+		return DefaultMaxPatternLength
+	}
+	return s.MaxPatternLength
+}
+
+// RuleLimit returns the configured rule count threshold or the default
+func (s Settings) RuleLimit() int {
+	if s.MaxRules == 0 {
+		return DefaultMaxRules
+	}
+	return s.MaxRules
+}
+
+// ReasonLimit returns the configured reason length cap or the default
+func (s Settings) ReasonLimit() int {
+	if s.MaxReasonLength == 0 {
+		return DefaultMaxReasonLength
+	}
+	return s.MaxReasonLength
+}
+
+// ParseFailureDecision returns the configured parse_failure decision or the
+// default, "ask"
+func (s Settings) ParseFailureDecision() string {
+	return stringOrDefault(s.ParseFailure, "ask")
+}
+
+// CompoundModeResolved returns the configured compound_mode or the default,
+// "all"
+func (s Settings) CompoundModeResolved() string {
+	return stringOrDefault(s.CompoundMode, CompoundAll)
+}
+
+// OutsideCwdDecisionResolved returns the configured outside_cwd_decision or
+// the default, "ask"
+func (s Settings) OutsideCwdDecisionResolved() string {
+	return stringOrDefault(s.OutsideCwdDecision, "ask")
+}
+
+// DefaultsCatchAll is the [defaults] key for tools without an entry of
+// their own
+const DefaultsCatchAll = "default"
+
+// DefaultDecision returns the [defaults] decision for a tool use no rule
+// matched, falling back to the catch-all entry, and the key it came from.
+// The decision is "" if neither is set.
+func (c *Config) DefaultDecision(tool string) (decision, key string) {
+	if decision, ok := c.Defaults[tool]; ok {
+		return decision, tool
+	}
+	if decision, ok := c.Defaults[DefaultsCatchAll]; ok {
+		return decision, DefaultsCatchAll
+	}
+	return "", ""
 }
 
+// GitHookEnvVars returns the configured git hook environment variables or
+// the default
+func (s Settings) GitHookEnvVars() []string {
+	if len(s.GitHookEnv) == 0 {
+		return []string{"GIT_DIR"}
+	}
+	return s.GitHookEnv
+}
+
+// MCPTool maps an MCP tool to the input field holding the command its rules
+// match against
+type MCPTool struct {
+	Tool       string `toml:"tool"`        // e.g. "mcp__github__create_issue"
+	InputField string `toml:"input_field"` // JSONPath-like path, e.g. "params.cmd"
+}
+
+// Runner describes a task runner whose argument is itself a shell command,
+// e.g. `just run 'git push'` or `make run CMD='git push'`. Exactly one of Arg
+// or Flag locates the command.
+type Runner struct {
+	Command string `toml:"command"` // Runner command name, e.g. "just"
+	Arg     int    `toml:"arg"`     // 1-based argument position, e.g. 2 for `just run '<cmd>'`
+	Flag    string `toml:"flag"`    // Flag or variable holding the command: --cmd <cmd>, --cmd=<cmd>, CMD=<cmd>
+}
+
+// Fail modes control the decision emitted when the hook hits an internal error
+const (
+	FailOpen   = "open"   // Fall back to Claude's normal permission prompt
+	FailClosed = "closed" // Deny the tool use
+)
+
 // AuditConfig controls logging behavior
 type AuditConfig struct {
 	AuditFile  string `toml:"audit_file"`
 	AuditLevel string `toml:"audit_level"` // "off", "matched", "all"
+
+	// AuditWarnSizeMB warns once on stderr when the audit file exceeds this size (0 disables)
+	AuditWarnSizeMB int `toml:"audit_warn_size_mb"`
+
+	// Sinks lists where entries go: "file" (audit_file), "stderr" and
+	// "syslog" (Unix). Defaults to ["file"] when audit_file is set.
+	Sinks []string `toml:"sinks"`
+}
+
+// Audit sink names
+const (
+	SinkFile   = "file"
+	SinkStderr = "stderr"
+	SinkSyslog = "syslog"
+)
+
+// SinkNames returns the configured sinks, or the file sink when only
+// audit_file is set. No sinks means auditing is off.
+func (a AuditConfig) SinkNames() []string {
+	if len(a.Sinks) > 0 {
+		return a.Sinks
+	}
+	if a.AuditFile != "" {
+		return []string{SinkFile}
+	}
+	return nil
 }
 
+// Match modes for command_patterns
+const (
+	MatchSubstring = "substring" // Anywhere in the command, as Go's regexp does
+	MatchAnchored  = "anchored"  // The whole command, as if wrapped in ^...$
+	MatchWord      = "word"      // Not inside a longer word: rm matches "rm -rf" but not "npm run format"
+)
+
+// Pattern scopes for command_patterns and exclude_patterns
+const (
+	ScopeCommand   = "command"   // Each command of a compound statement on its own
+	ScopeStatement = "statement" // The full original command
+)
+
 // Rule defines an allow or deny rule
 type Rule struct {
 	// Tool is the Claude Code tool name (e.g., "Bash", "Read", "Write")
@@ -33,17 +245,112 @@ type Rule struct {
 	Commands        []string `toml:"commands"`         // List of allowed command signatures (e.g., ["git add", "git commit"])
 	CommandPatterns []string `toml:"command_patterns"` // Regex patterns for commands
 
+	// MatchMode sets how command_patterns match the command: "substring"
+	// (default), "anchored" (the whole command) or "word" (on word boundaries)
+	MatchMode string `toml:"match_mode"`
+
+	// ExcludePatterns are regexes for commands the rule doesn't apply to,
+	// even when its commands or command_patterns match
+	ExcludePatterns []string `toml:"exclude_patterns"`
+
+	// PatternScope sets what command_patterns and exclude_patterns match:
+	// each command's own text ("command", the allow rule default) or the
+	// full original command ("statement", the deny rule default)
+	PatternScope string `toml:"pattern_scope"`
+
+	// Environment variable names (regex) that make a Bash command match a
+	// deny rule, or keep an allow rule from matching, e.g. ["^LD_PRELOAD$"]
+	DeniedEnv []string `toml:"denied_env"`
+
+	// Flag sets (e.g. [["r", "f"]]) that make a Bash command match a deny
+	// rule, or keep an allow rule from matching, when the command has every
+	// flag of one set however it's spelled (rm -rf, rm -r -f, rm
+	// --recursive --force)
+	DeniedFlagSets [][]string `toml:"denied_flag_sets"`
+
+	// Regex patterns for the repository a git command targets with -C or
+	// --git-dir; the rule only matches commands with a matching directory
+	DirPatterns []string `toml:"dir_patterns"`
+
+	// For other tools (e.g. MCP) - match command_patterns against a tool input field
+	InputField  string `toml:"input_field"`  // Tool input field holding the command
+	JSONPointer string `toml:"json_pointer"` // Parse the field as JSON and match the value at this pointer (e.g. "/cmd")
+
 	// For file operations - path matching
-	PathPatterns        []string `toml:"path_patterns"`         // Regex patterns for file paths
+	PathPatterns        []string `toml:"path_patterns"`         // Regex patterns for file paths (Bash: the script an interpreter runs)
 	PathExcludePatterns []string `toml:"path_exclude_patterns"` // Patterns that should be denied
+	PathGlobs           []string `toml:"path_globs"`            // Globs for file paths ({go,rs} braces, ! to exclude), checked in order
+	ContentPatterns     []string `toml:"content_patterns"`      // Regex patterns for Write content and Edit old_string/new_string
+	CaseInsensitive     bool     `toml:"case_insensitive"`      // Match paths ignoring case, with \ treated as / (Windows)
+
+	// For Read operations - only match reads that specify a line limit
+	RequireLimit bool `toml:"require_limit"`
 
 	// Description for logging
 	Description string `toml:"description"`
 
+	// ID identifies the rule, e.g. a policy number, for {rule_id} in
+	// reason_template
+	ID string `toml:"id"`
+
+	// ReasonTemplate replaces the reason shown to Claude when the rule
+	// matches. {signature}, {command}, {rule_id} and {description} are
+	// filled in, e.g. "Allowed by policy {rule_id}: {description}".
+	ReasonTemplate string `toml:"reason_template"`
+
+	// DocsURL links to the policy behind a deny rule; it's appended to the deny reason
+	DocsURL string `toml:"docs_url"`
+
+	// Suggestion points Claude at an allowed alternative to a denied command;
+	// it's appended to the deny reason (e.g. "use git push --force-with-lease")
+	Suggestion string `toml:"suggestion"`
+
+	// Halt makes a deny rule also stop Claude's turn instead of letting it
+	// try something else
+	Halt bool `toml:"halt"`
+
+	// Action "ask" makes an allow rule prompt for confirmation instead of
+	// allowing, e.g. to always confirm git push (default "allow")
+	Action string `toml:"action"`
+
+	// Tags group rules so they can be enabled or disabled at runtime (e.g. ["infra"])
+	Tags []string `toml:"tags"`
+
+	// Priority orders conflicting matches; the highest wins and deny wins ties
+	Priority int `toml:"priority"`
+
+	// InGitHook scopes the rule to git hook executions (true) or to
+	// interactive sessions (false); unset applies in both
+	InGitHook *bool `toml:"in_git_hook"`
+
+	// Audit overrides the global audit level when this rule matches (true forces, false suppresses)
+	Audit *bool `toml:"audit"`
+
+	// Enabled set to false keeps the rule in the file but out of matching;
+	// its patterns aren't compiled (default true)
+	Enabled *bool `toml:"enabled"`
+
 	// Compiled patterns (internal use)
 	compiledCommandPatterns []*regexp.Regexp
+	compiledExcludePatterns []*regexp.Regexp
 	compiledPathPatterns    []*regexp.Regexp
 	compiledPathExclude     []*regexp.Regexp
+	compiledPathGlobs       []pathGlob
+	compiledContentPatterns []*regexp.Regexp
+	compiledDeniedEnv       []*regexp.Regexp
+	compiledDirPatterns     []*regexp.Regexp
+}
+
+// Actions for an allow rule's action field
+const (
+	ActionAllow = "allow" // Allow the command (default)
+	ActionAsk   = "ask"   // Prompt for confirmation with the rule's reason
+)
+
+// Asks reports whether a matching allow rule prompts for confirmation
+// instead of allowing
+func (r Rule) Asks() bool {
+	return r.Action == ActionAsk
 }
 
 // BashConfig controls shell construct handling.
@@ -53,6 +360,42 @@ type BashConfig struct {
 	AllowBackground          *bool `toml:"allow_background"`
 	AllowRedirects           *bool `toml:"allow_redirects"`
 	AllowProcessSubstitution *bool `toml:"allow_process_substitution"`
+	AllowFindDelete          *bool `toml:"allow_find_delete"`
+
+	// Decision for blank or comment-only commands: "ask" (default), "allow" or "deny"
+	EmptyCommandDecision string `toml:"empty_command_decision"`
+
+	// Decision for commands whose name is a variable or command substitution
+	// (e.g. $CMD or $(echo rm)): "ask" (default) or "deny"
+	DynamicCommandDecision string `toml:"dynamic_command_decision"`
+
+	// Decision for commands run in the background (sleep 10 &) when
+	// allow_background is false and no deny rule matched: "ask" (default) or "deny"
+	BackgroundDecision string `toml:"background_decision"`
+
+	// MatchRemoteCommands parses the command passed to ssh (ssh host "ls")
+	// or kubectl exec (kubectl exec web -- ls) so rules apply to it
+	// (default true)
+	MatchRemoteCommands *bool `toml:"match_remote_commands"`
+
+	// MatchRedirectPaths checks redirect targets against file deny rules:
+	// Read rules for input (cat < .env), Write rules for output (echo x >
+	// .env) (default true)
+	MatchRedirectPaths *bool `toml:"match_redirect_paths"`
+
+	// Builtin checks (opt-in)
+	DenyBroadRecursivePermissions *bool `toml:"deny_broad_recursive_permissions"`
+
+	// BlockPipeToShell denies pipelines that feed a shell the output of one
+	// of PipeToShellSources (default ["curl", "wget"]; "*" for any command)
+	BlockPipeToShell   *bool    `toml:"block_pipe_to_shell"`
+	PipeToShellSources []string `toml:"pipe_to_shell_sources"`
+
+	// BlockGitConfigKeys denies git commands that set one of GitConfigKeys
+	// with -c or --config-env, since keys like core.sshCommand run commands
+	// (default DefaultGitConfigKeys; * matches any part of a key)
+	BlockGitConfigKeys *bool    `toml:"block_git_config_keys"`
+	GitConfigKeys      []string `toml:"git_config_keys"`
 }
 
 // BashConfigResolved is the resolved config with defaults applied.
@@ -62,6 +405,20 @@ type BashConfigResolved struct {
 	AllowBackground          bool
 	AllowRedirects           bool
 	AllowProcessSubstitution bool
+	AllowFindDelete          bool
+
+	EmptyCommandDecision   string
+	DynamicCommandDecision string
+	BackgroundDecision     string
+
+	MatchRemoteCommands bool
+	MatchRedirectPaths  bool
+
+	DenyBroadRecursivePermissions bool
+	BlockPipeToShell              bool
+	PipeToShellSources            []string
+	BlockGitConfigKeys            bool
+	GitConfigKeys                 []string
 }
 
 // GetBashConfig resolves bash config with defaults.
@@ -73,14 +430,106 @@ func (c *Config) GetBashConfig() BashConfigResolved {
 			AllowBackground:          true,
 			AllowRedirects:           true,
 			AllowProcessSubstitution: true,
+			AllowFindDelete:          true,
+			EmptyCommandDecision:     "ask",
+			DynamicCommandDecision:   "ask",
+			BackgroundDecision:       "ask",
+			MatchRemoteCommands:      true,
+			MatchRedirectPaths:       true,
+			PipeToShellSources:       defaultPipeToShellSources,
+			GitConfigKeys:            DefaultGitConfigKeys,
 		}
 	}
+	sources := c.Bash.PipeToShellSources
+	if len(sources) == 0 {
+		sources = defaultPipeToShellSources
+	}
+	gitKeys := c.Bash.GitConfigKeys
+	if len(gitKeys) == 0 {
+		gitKeys = DefaultGitConfigKeys
+	}
 	return BashConfigResolved{
 		AllowPipes:               boolOrDefault(c.Bash.AllowPipes, true),
 		AllowSubshells:           boolOrDefault(c.Bash.AllowSubshells, true),
 		AllowBackground:          boolOrDefault(c.Bash.AllowBackground, true),
 		AllowRedirects:           boolOrDefault(c.Bash.AllowRedirects, true),
 		AllowProcessSubstitution: boolOrDefault(c.Bash.AllowProcessSubstitution, true),
+		AllowFindDelete:          boolOrDefault(c.Bash.AllowFindDelete, true),
+
+		EmptyCommandDecision:   stringOrDefault(c.Bash.EmptyCommandDecision, "ask"),
+		DynamicCommandDecision: stringOrDefault(c.Bash.DynamicCommandDecision, "ask"),
+		BackgroundDecision:     stringOrDefault(c.Bash.BackgroundDecision, "ask"),
+
+		MatchRemoteCommands: boolOrDefault(c.Bash.MatchRemoteCommands, true),
+		MatchRedirectPaths:  boolOrDefault(c.Bash.MatchRedirectPaths, true),
+
+		DenyBroadRecursivePermissions: boolOrDefault(c.Bash.DenyBroadRecursivePermissions, false),
+		BlockPipeToShell:              boolOrDefault(c.Bash.BlockPipeToShell, false),
+		PipeToShellSources:            sources,
+		BlockGitConfigKeys:            boolOrDefault(c.Bash.BlockGitConfigKeys, false),
+		GitConfigKeys:                 gitKeys,
+	}
+}
+
+// defaultPipeToShellSources are the network-fetching commands whose output
+// block_pipe_to_shell keeps from being piped into a shell
+var defaultPipeToShellSources = []string{"curl", "wget"}
+
+// DefaultGitConfigKeys are the git config keys block_git_config_keys denies
+// by default: each names a command git runs, or lets one run through
+// aliases, hooks, filters, transports or included config files
+var DefaultGitConfigKeys = []string{
+	"core.sshCommand",
+	"core.pager",
+	"core.editor",
+	"core.fsmonitor",
+	"core.hooksPath",
+	"core.gitProxy",
+	"core.askPass",
+	"protocol.*.allow",
+	"uploadpack.*",
+	"alias.*",
+	"pager.*",
+	"filter.*",
+	"diff.external",
+	"diff.*.command",
+	"diff.*.textconv",
+	"merge.*.driver",
+	"credential.helper",
+	"credential.*.helper",
+	"remote.*.uploadpack",
+	"remote.*.receivepack",
+	"sequence.editor",
+	"gpg.program",
+	"gpg.*.program",
+	"gpg.ssh.defaultKeyCommand",
+	"include.path",
+	"includeIf.*.path",
+}
+
+// PathConfig controls file path handling.
+type PathConfig struct {
+	NormalizeSeparators *bool `toml:"normalize_separators"`
+	ExpandPaths         *bool `toml:"expand_paths"`
+}
+
+// PathConfigResolved is the resolved config with defaults applied.
+type PathConfigResolved struct {
+	NormalizeSeparators bool
+	ExpandPaths         bool
+}
+
+// GetPathConfig resolves path config with defaults.
+func (c *Config) GetPathConfig() PathConfigResolved {
+	if c.Paths == nil {
+		return PathConfigResolved{
+			NormalizeSeparators: true,
+			ExpandPaths:         true,
+		}
+	}
+	return PathConfigResolved{
+		NormalizeSeparators: boolOrDefault(c.Paths.NormalizeSeparators, true),
+		ExpandPaths:         boolOrDefault(c.Paths.ExpandPaths, true),
 	}
 }
 
@@ -91,52 +540,509 @@ func boolOrDefault(value *bool, def bool) bool {
 	return *value
 }
 
+func stringOrDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
 // Load reads and parses a TOML configuration file
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
+	return Parse(data, "toml")
+}
+
+// Parse decodes and compiles a configuration held in memory. format names
+// the encoding; only "toml" is supported.
+func Parse(data []byte, format string) (*Config, error) {
+	cfg, _, err := decode(data, format)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Compile(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// LoadDir reads every .toml file in dir in lexical order and merges them
+// into one configuration. Rule lists ([[allow]], [[deny]], [[mcp]],
+// [[runner]]) concatenate, so a later file can't drop an earlier rule.
+// [settings] and [bash] merge key by key; any other setting or section a
+// later file defines replaces the earlier one.
+func LoadDir(dir string) (*Config, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config directory: %w", err)
+	}
+
+	var merged *Config
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".toml" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		cfg, md, err := decodeFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		if merged == nil {
+			merged = cfg
+			continue
+		}
+		merge(merged, cfg, md)
+	}
+	if merged == nil {
+		return nil, fmt.Errorf("no .toml files in config directory %s", dir)
+	}
+
+	if err := Compile(merged); err != nil {
+		return nil, err
+	}
+
+	return merged, nil
+}
+
+// ParseString decodes and compiles a TOML configuration held in a string
+func ParseString(s string) (*Config, error) {
+	return Parse([]byte(s), "toml")
+}
+
+// decodeFile parses a TOML configuration file without validating it
+func decodeFile(path string) (*Config, toml.MetaData, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, toml.MetaData{}, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return decode(data, "toml")
+}
+
+// decode decodes a configuration without compiling it
+func decode(data []byte, format string) (*Config, toml.MetaData, error) {
+	if format != "toml" {
+		return nil, toml.MetaData{}, fmt.Errorf("unsupported config format %q", format)
+	}
 
 	var cfg Config
-	if _, err := toml.Decode(string(data), &cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config: %w", err)
+	md, err := toml.Decode(string(data), &cfg)
+	if err != nil {
+		return nil, toml.MetaData{}, fmt.Errorf("failed to parse config: %w", err)
+	}
+	// A misspelt key would otherwise be ignored, leaving its rule or
+	// setting silently different from what was written
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, toml.MetaData{}, fmt.Errorf("unknown config keys (check for typos): %s",
+			strings.Join(unknownKeys(data, undecoded), ", "))
+	}
+
+	return &cfg, md, nil
+}
+
+// unknownKeys names the keys the decoder didn't use, with the index of the
+// entry for array tables, e.g. "allow[1].command"
+func unknownKeys(data []byte, keys []toml.Key) []string {
+	var raw map[string]any
+	toml.Decode(string(data), &raw)
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		// The keys inside an unknown table are reported with the table
+		if len(key) > 1 && seen[key[:len(key)-1].String()] {
+			seen[key.String()] = true
+			continue
+		}
+		seen[key.String()] = true
+
+		located := locateKey(raw, key, "")
+		if len(located) == 0 {
+			located = []string{key.String()}
+		}
+		names = append(names, located...)
+	}
+	return names
+}
+
+// locateKey finds key in a decoded TOML value, expanding each array of
+// tables it passes through into one name per entry holding the key
+func locateKey(value any, key toml.Key, prefix string) []string {
+	if len(key) == 0 {
+		return []string{prefix}
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		child, ok := v[key[0]]
+		if !ok {
+			return nil
+		}
+		name := key[0]
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		return locateKey(child, key[1:], name)
+	case []map[string]any:
+		var names []string
+		for i, elem := range v {
+			names = append(names, locateKey(elem, key, fmt.Sprintf("%s[%d]", prefix, i))...)
+		}
+		return names
+	case []any:
+		var names []string
+		for i, elem := range v {
+			names = append(names, locateKey(elem, key, fmt.Sprintf("%s[%d]", prefix, i))...)
+		}
+		return names
+	}
+	return nil
+}
+
+// merge folds a later config file into dst. md tells which keys the later
+// file set, so sections it leaves out keep their earlier values.
+func merge(dst, src *Config, md toml.MetaData) {
+	dst.Allow = append(dst.Allow, src.Allow...)
+	dst.Deny = append(dst.Deny, src.Deny...)
+	dst.MCP = append(dst.MCP, src.MCP...)
+	dst.Runners = append(dst.Runners, src.Runners...)
+
+	for alias, canonical := range src.Aliases {
+		if dst.Aliases == nil {
+			dst.Aliases = make(map[string]string)
+		}
+		dst.Aliases[alias] = canonical
+	}
+	for tool, decision := range src.Defaults {
+		if dst.Defaults == nil {
+			dst.Defaults = make(map[string]string)
+		}
+		dst.Defaults[tool] = decision
+	}
+	for tool, depth := range src.SignatureDepth {
+		if dst.SignatureDepth == nil {
+			dst.SignatureDepth = make(map[string]int)
+		}
+		dst.SignatureDepth[tool] = depth
+	}
+
+	if md.IsDefined("audit") {
+		dst.Audit = src.Audit
+	}
+	mergeDefinedKeys(&dst.Settings, &src.Settings, md, "settings")
+	if src.Bash != nil {
+		if dst.Bash == nil {
+			dst.Bash = &BashConfig{}
+		}
+		mergeDefinedKeys(dst.Bash, src.Bash, md, "bash")
+	}
+	if md.IsDefined("paths") {
+		dst.Paths = src.Paths
+	}
+	if md.IsDefined("subcommand_tools") {
+		dst.SubcommandTools = src.SubcommandTools
+	}
+	if md.IsDefined("fail_mode") {
+		dst.FailMode = src.FailMode
+	}
+	if md.IsDefined("ask_queue_file") {
+		dst.AskQueueFile = src.AskQueueFile
 	}
+}
+
+// mergeDefinedKeys copies the fields of the struct src points to into dst
+// for the keys the later file set in section, so [settings] and [bash]
+// merge key by key
+func mergeDefinedKeys(dst, src any, md toml.MetaData, section string) {
+	d, s := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for i := 0; i < s.NumField(); i++ {
+		key, _, _ := strings.Cut(s.Type().Field(i).Tag.Get("toml"), ",")
+		if key != "" && key != "-" && md.IsDefined(section, key) {
+			d.Field(i).Set(s.Field(i))
+		}
+	}
+}
 
+// Compile applies defaults and compiles every rule in cfg, as Load does. Use it
+// for configs built in memory before passing them to matcher.New.
+func Compile(cfg *Config) error {
 	// Set defaults
 	if cfg.Audit.AuditLevel == "" {
 		cfg.Audit.AuditLevel = "matched"
 	}
 
+	for _, sink := range cfg.Audit.Sinks {
+		switch sink {
+		case SinkFile:
+			if cfg.Audit.AuditFile == "" {
+				return fmt.Errorf("audit sink %q requires audit_file", sink)
+			}
+		case SinkStderr, SinkSyslog:
+		default:
+			return fmt.Errorf("invalid audit sink %q: must be %s, %s or %s", sink, SinkFile, SinkStderr, SinkSyslog)
+		}
+	}
+
+	switch cfg.FailMode {
+	case "":
+		cfg.FailMode = FailOpen
+	case FailOpen, FailClosed:
+	default:
+		return fmt.Errorf("invalid fail_mode %q: must be %q or %q", cfg.FailMode, FailOpen, FailClosed)
+	}
+
+	if cfg.Bash != nil {
+		switch cfg.Bash.EmptyCommandDecision {
+		case "", "ask", "allow", "deny":
+		default:
+			return fmt.Errorf("invalid bash.empty_command_decision %q: must be ask, allow or deny", cfg.Bash.EmptyCommandDecision)
+		}
+		switch cfg.Bash.DynamicCommandDecision {
+		case "", "ask", "deny":
+		default:
+			return fmt.Errorf("invalid bash.dynamic_command_decision %q: must be ask or deny", cfg.Bash.DynamicCommandDecision)
+		}
+		switch cfg.Bash.BackgroundDecision {
+		case "", "ask", "deny":
+		default:
+			return fmt.Errorf("invalid bash.background_decision %q: must be ask or deny", cfg.Bash.BackgroundDecision)
+		}
+	}
+
+	for i, entry := range cfg.MCP {
+		if entry.Tool == "" || entry.InputField == "" {
+			return fmt.Errorf("mcp entry %d: tool and input_field are required", i)
+		}
+	}
+
+	for alias, canonical := range cfg.Aliases {
+		if strings.TrimSpace(canonical) == "" || strings.Fields(canonical)[0] == alias {
+			return fmt.Errorf("invalid alias %q: must map to a different command", alias)
+		}
+	}
+
+	for tool, decision := range cfg.Defaults {
+		switch decision {
+		case "ask", "allow", "deny":
+		default:
+			return fmt.Errorf("invalid defaults.%s %q: must be ask, allow or deny", tool, decision)
+		}
+	}
+
+	for tool, depth := range cfg.SignatureDepth {
+		if depth < 1 {
+			return fmt.Errorf("invalid signature_depth for %q: must be at least 1", tool)
+		}
+	}
+
+	if cfg.Settings.ParseCacheSize < 0 {
+		return fmt.Errorf("invalid settings.parse_cache_size %d: must not be negative", cfg.Settings.ParseCacheSize)
+	}
+	if cfg.Settings.DenyExitCode < 0 || cfg.Settings.DenyExitCode > 255 {
+		return fmt.Errorf("invalid settings.deny_exit_code %d: must be between 0 and 255", cfg.Settings.DenyExitCode)
+	}
+	if cfg.Settings.MaxReasonLength < 0 {
+		return fmt.Errorf("invalid settings.max_reason_length %d: must not be negative", cfg.Settings.MaxReasonLength)
+	}
+	switch cfg.Settings.OutsideCwdDecision {
+	case "", "ask", "deny":
+	default:
+		return fmt.Errorf("invalid settings.outside_cwd_decision %q: must be ask or deny", cfg.Settings.OutsideCwdDecision)
+	}
+	if cfg.Settings.MaxPatternLength < 0 {
+		return fmt.Errorf("invalid settings.max_pattern_length %d: must not be negative", cfg.Settings.MaxPatternLength)
+	}
+	if cfg.Settings.MaxRules < 0 {
+		return fmt.Errorf("invalid settings.max_rules %d: must not be negative", cfg.Settings.MaxRules)
+	}
+	switch cfg.Settings.ParseFailure {
+	case "", "ask", "deny":
+	default:
+		return fmt.Errorf("invalid settings.parse_failure %q: must be ask or deny", cfg.Settings.ParseFailure)
+	}
+	switch cfg.Settings.CompoundMode {
+	case "", CompoundAll, CompoundAnyReached:
+	default:
+		return fmt.Errorf("invalid settings.compound_mode %q: must be %s or %s", cfg.Settings.CompoundMode, CompoundAll, CompoundAnyReached)
+	}
+
+	for i, r := range cfg.Runners {
+		if r.Command == "" {
+			return fmt.Errorf("runner entry %d: command is required", i)
+		}
+		if (r.Arg > 0) == (r.Flag != "") {
+			return fmt.Errorf("runner entry %d (%s): exactly one of arg or flag is required", i, r.Command)
+		}
+	}
+
 	// Compile patterns
 	for i := range cfg.Allow {
+		if !cfg.Allow[i].IsEnabled() {
+			continue
+		}
+		if cfg.Allow[i].Halt {
+			return fmt.Errorf("allow rule %d: halt only applies to deny rules", i)
+		}
+		switch cfg.Allow[i].Action {
+		case "", ActionAllow, ActionAsk:
+		default:
+			return fmt.Errorf("allow rule %d: invalid action %q: must be allow or ask", i, cfg.Allow[i].Action)
+		}
 		if err := cfg.Allow[i].Compile(); err != nil {
-			return nil, fmt.Errorf("error compiling allow rule %d: %w", i, err)
+			return fmt.Errorf("error compiling allow rule %d: %w", i, err)
 		}
 	}
 	for i := range cfg.Deny {
+		if !cfg.Deny[i].IsEnabled() {
+			continue
+		}
+		if cfg.Deny[i].Action != "" {
+			return fmt.Errorf("deny rule %d: action only applies to allow rules", i)
+		}
 		if err := cfg.Deny[i].Compile(); err != nil {
-			return nil, fmt.Errorf("error compiling deny rule %d: %w", i, err)
+			return fmt.Errorf("error compiling deny rule %d: %w", i, err)
 		}
 	}
 
-	return &cfg, nil
+	cfg.Warnings = sizeWarnings(cfg)
+	return nil
+}
+
+// sizeWarnings reports patterns longer than max_pattern_length and a rule
+// count over max_rules. RE2 matches in linear time, so large configs are
+// slow to load rather than dangerous, but they usually mean a generated
+// config has grown unchecked.
+func sizeWarnings(cfg *Config) []string {
+	var warnings []string
+	limit := cfg.Settings.PatternLengthLimit()
+	for _, list := range []struct {
+		kind  string
+		rules []Rule
+	}{{"allow", cfg.Allow}, {"deny", cfg.Deny}} {
+		for i, r := range list.rules {
+			for _, field := range []struct {
+				name     string
+				patterns []string
+			}{
+				{"command_patterns", r.CommandPatterns},
+				{"exclude_patterns", r.ExcludePatterns},
+				{"path_patterns", r.PathPatterns},
+				{"path_exclude_patterns", r.PathExcludePatterns},
+				{"path_globs", r.PathGlobs},
+				{"content_patterns", r.ContentPatterns},
+				{"denied_env", r.DeniedEnv},
+				{"dir_patterns", r.DirPatterns},
+			} {
+				for j, pattern := range field.patterns {
+					if len(pattern) > limit {
+						warnings = append(warnings, fmt.Sprintf("%s[%d].%s[%d] is %d characters, over max_pattern_length %d",
+							list.kind, i, field.name, j, len(pattern), limit))
+					}
+				}
+			}
+		}
+	}
+	if n := len(cfg.Allow) + len(cfg.Deny); n > cfg.Settings.RuleLimit() {
+		warnings = append(warnings, fmt.Sprintf("%d rules, over max_rules %d", n, cfg.Settings.RuleLimit()))
+	}
+	return warnings
+}
+
+// IsEnabled reports whether the rule takes part in matching
+func (r Rule) IsEnabled() bool {
+	return boolOrDefault(r.Enabled, true)
+}
+
+// DisabledRules returns the number of allow and deny rules with enabled = false
+func (c *Config) DisabledRules() int {
+	n := 0
+	for _, rules := range [][]Rule{c.Allow, c.Deny} {
+		for _, rule := range rules {
+			if !rule.IsEnabled() {
+				n++
+			}
+		}
+	}
+	return n
 }
 
 // Compile compiles all regex patterns in the rule
 func (r *Rule) Compile() error {
-	// Compile command patterns
+	// Reset so compiling twice doesn't duplicate patterns
+	r.compiledCommandPatterns = nil
+	r.compiledExcludePatterns = nil
+	r.compiledPathPatterns = nil
+	r.compiledPathExclude = nil
+	r.compiledPathGlobs = nil
+	r.compiledContentPatterns = nil
+	r.compiledDeniedEnv = nil
+	r.compiledDirPatterns = nil
+
+	if r.JSONPointer != "" {
+		if r.InputField == "" {
+			return fmt.Errorf("json_pointer %q requires input_field", r.JSONPointer)
+		}
+		if !strings.HasPrefix(r.JSONPointer, "/") {
+			return fmt.Errorf("invalid json_pointer %q: must start with /", r.JSONPointer)
+		}
+	}
+
+	// Compile command patterns, wrapped for the match mode
+	var wrap string
+	switch r.MatchMode {
+	case "", MatchSubstring:
+		wrap = "%s"
+	case MatchAnchored:
+		wrap = "^(?:%s)$"
+	case MatchWord:
+		// Not \b, which needs a word character on one side and so fails
+		// around patterns like --force. Dashes count as part of a word, so
+		// --force doesn't match --force-with-lease.
+		wrap = `(?:^|[^\w-])(?:%s)(?:[^\w-]|$)`
+	default:
+		return fmt.Errorf("invalid match_mode %q: must be %q, %q or %q", r.MatchMode, MatchSubstring, MatchAnchored, MatchWord)
+	}
 	for _, pattern := range r.CommandPatterns {
-		re, err := regexp.Compile(pattern)
+		// Check the pattern on its own, since wrapping can make an
+		// unbalanced one like a)|(b valid
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid command pattern %q: %w", pattern, err)
+		}
+		re, err := regexp.Compile(fmt.Sprintf(wrap, pattern))
 		if err != nil {
 			return fmt.Errorf("invalid command pattern %q: %w", pattern, err)
 		}
 		r.compiledCommandPatterns = append(r.compiledCommandPatterns, re)
 	}
 
+	switch r.PatternScope {
+	case "", ScopeCommand, ScopeStatement:
+	default:
+		return fmt.Errorf("invalid pattern_scope %q: must be %q or %q", r.PatternScope, ScopeCommand, ScopeStatement)
+	}
+	if len(r.ExcludePatterns) > 0 && len(r.Commands) == 0 && len(r.CommandPatterns) == 0 {
+		return fmt.Errorf("exclude_patterns requires commands or command_patterns")
+	}
+	for _, pattern := range r.ExcludePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+		r.compiledExcludePatterns = append(r.compiledExcludePatterns, re)
+	}
+
+	// Case-insensitive rules compile path patterns with the (?i) flag
+	pathPrefix := ""
+	if r.CaseInsensitive {
+		pathPrefix = "(?i)"
+	}
+
 	// Compile path patterns
 	for _, pattern := range r.PathPatterns {
-		re, err := regexp.Compile(pattern)
+		re, err := regexp.Compile(pathPrefix + pattern)
 		if err != nil {
 			return fmt.Errorf("invalid path pattern %q: %w", pattern, err)
 		}
@@ -145,13 +1051,61 @@ func (r *Rule) Compile() error {
 
 	// Compile path exclude patterns
 	for _, pattern := range r.PathExcludePatterns {
-		re, err := regexp.Compile(pattern)
+		re, err := regexp.Compile(pathPrefix + pattern)
 		if err != nil {
 			return fmt.Errorf("invalid path exclude pattern %q: %w", pattern, err)
 		}
 		r.compiledPathExclude = append(r.compiledPathExclude, re)
 	}
 
+	// Compile path globs; a list of only exclusions could never match
+	included := false
+	for _, glob := range r.PathGlobs {
+		g, err := compilePathGlob(glob, pathPrefix)
+		if err != nil {
+			return err
+		}
+		included = included || !g.negate
+		r.compiledPathGlobs = append(r.compiledPathGlobs, g)
+	}
+	if len(r.PathGlobs) > 0 && !included {
+		return fmt.Errorf("path_globs %q only excludes paths: add a glob to include", r.PathGlobs)
+	}
+
+	// Compile content patterns
+	for _, pattern := range r.ContentPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid content pattern %q: %w", pattern, err)
+		}
+		r.compiledContentPatterns = append(r.compiledContentPatterns, re)
+	}
+
+	// Validate denied flag sets
+	for _, set := range r.DeniedFlagSets {
+		if len(set) == 0 || slices.Contains(set, "") {
+			return fmt.Errorf("invalid denied_flag_sets entry %q: flag sets must list non-empty flags", set)
+		}
+	}
+
+	// Compile denied environment variable patterns
+	for _, pattern := range r.DeniedEnv {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid denied_env pattern %q: %w", pattern, err)
+		}
+		r.compiledDeniedEnv = append(r.compiledDeniedEnv, re)
+	}
+
+	// Compile directory patterns
+	for _, pattern := range r.DirPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid dir pattern %q: %w", pattern, err)
+		}
+		r.compiledDirPatterns = append(r.compiledDirPatterns, re)
+	}
+
 	return nil
 }
 
@@ -160,6 +1114,11 @@ func (r *Rule) GetCompiledCommandPatterns() []*regexp.Regexp {
 	return r.compiledCommandPatterns
 }
 
+// GetCompiledExcludePatterns returns compiled exclude patterns
+func (r *Rule) GetCompiledExcludePatterns() []*regexp.Regexp {
+	return r.compiledExcludePatterns
+}
+
 // GetCompiledPathPatterns returns compiled path patterns
 func (r *Rule) GetCompiledPathPatterns() []*regexp.Regexp {
 	return r.compiledPathPatterns
@@ -169,3 +1128,18 @@ func (r *Rule) GetCompiledPathPatterns() []*regexp.Regexp {
 func (r *Rule) GetCompiledPathExclude() []*regexp.Regexp {
 	return r.compiledPathExclude
 }
+
+// GetCompiledContentPatterns returns compiled content patterns
+func (r *Rule) GetCompiledContentPatterns() []*regexp.Regexp {
+	return r.compiledContentPatterns
+}
+
+// GetCompiledDeniedEnv returns compiled denied_env patterns
+func (r *Rule) GetCompiledDeniedEnv() []*regexp.Regexp {
+	return r.compiledDeniedEnv
+}
+
+// GetCompiledDirPatterns returns compiled dir patterns
+func (r *Rule) GetCompiledDirPatterns() []*regexp.Regexp {
+	return r.compiledDirPatterns
+}
@@ -0,0 +1,380 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"10-base.toml": `
+[audit]
+audit_file = "/tmp/base.jsonl"
+audit_level = "all"
+
+[settings]
+parse_failure = "deny"
+
+[bash]
+allow_subshells = false
+allow_pipes = true
+
+[[deny]]
+tool = "Bash"
+commands = ["git push"]
+description = "Base: no push"
+
+[[allow]]
+tool = "Bash"
+commands = ["git"]
+description = "Base: git"
+`,
+		"20-project.toml": `
+[audit]
+audit_level = "matched"
+
+[settings]
+deny_list_mode = true
+
+[bash]
+allow_pipes = false
+
+[[allow]]
+tool = "Bash"
+commands = ["npm test"]
+description = "Project: tests"
+`,
+		"30-lockdown.toml": `
+[[deny]]
+tool = "Bash"
+commands = ["npm publish"]
+description = "Lockdown: no publish"
+`,
+		"README.md": "not a config",
+	})
+
+	cfg, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir() error = %v", err)
+	}
+
+	var allow, deny []string
+	for _, r := range cfg.Allow {
+		allow = append(allow, r.Description)
+	}
+	for _, r := range cfg.Deny {
+		deny = append(deny, r.Description)
+	}
+	if want := []string{"Base: git", "Project: tests"}; !slices.Equal(allow, want) {
+		t.Errorf("allow rules = %q, want %q", allow, want)
+	}
+	if want := []string{"Base: no push", "Lockdown: no publish"}; !slices.Equal(deny, want) {
+		t.Errorf("deny rules = %q, want %q", deny, want)
+	}
+
+	// The later [audit] section replaces the earlier one
+	if cfg.Audit.AuditLevel != "matched" || cfg.Audit.AuditFile != "" {
+		t.Errorf("audit = %+v, want level matched and no file", cfg.Audit)
+	}
+
+	// [settings] and [bash] merge key by key
+	if !cfg.Settings.DenyListMode || cfg.Settings.ParseFailure != "deny" {
+		t.Errorf("settings = %+v, want deny_list_mode from 20 and parse_failure from 10", cfg.Settings)
+	}
+	bash := cfg.GetBashConfig()
+	if bash.AllowPipes || bash.AllowSubshells {
+		t.Errorf("bash allow_pipes = %v, allow_subshells = %v, want both false", bash.AllowPipes, bash.AllowSubshells)
+	}
+}
+
+func TestLoadDirErrors(t *testing.T) {
+	empty := writeConfigFiles(t, map[string]string{"notes.txt": "x"})
+	if _, err := LoadDir(empty); err == nil {
+		t.Error("LoadDir() with no .toml files succeeded, want error")
+	}
+
+	// An invalid later file fails the whole load rather than being skipped
+	invalid := writeConfigFiles(t, map[string]string{
+		"10-base.toml": "[[allow]]\ntool = \"Bash\"\ncommands = [\"git\"]\n",
+		"20-bad.toml":  "[[deny]]\ntool = \"Bash\"\ncommand_patterns = [\"(\"]\n",
+	})
+	if _, err := LoadDir(invalid); err == nil {
+		t.Error("LoadDir() with an invalid deny pattern succeeded, want error")
+	}
+}
+
+func TestHaltOnlyOnDenyRules(t *testing.T) {
+	cfg := &Config{Allow: []Rule{{Tool: "Bash", Commands: []string{"git"}, Halt: true}}}
+	if err := Compile(cfg); err == nil {
+		t.Error("Compile() with halt on an allow rule succeeded, want error")
+	}
+
+	cfg = &Config{Deny: []Rule{{Tool: "Bash", Commands: []string{"rm"}, Halt: true}}}
+	if err := Compile(cfg); err != nil {
+		t.Errorf("Compile() with halt on a deny rule error = %v", err)
+	}
+}
+
+func TestActionOnlyOnAllowRules(t *testing.T) {
+	cfg := &Config{Allow: []Rule{{Tool: "Bash", Commands: []string{"git push"}, Action: "ask"}}}
+	if err := Compile(cfg); err != nil {
+		t.Errorf("Compile() with action ask on an allow rule error = %v", err)
+	}
+	if !cfg.Allow[0].Asks() {
+		t.Error("Asks() = false for action ask")
+	}
+
+	cfg = &Config{Allow: []Rule{{Tool: "Bash", Commands: []string{"git push"}, Action: "confirm"}}}
+	if err := Compile(cfg); err == nil {
+		t.Error("Compile() with action confirm succeeded, want error")
+	}
+
+	cfg = &Config{Deny: []Rule{{Tool: "Bash", Commands: []string{"rm"}, Action: "ask"}}}
+	if err := Compile(cfg); err == nil {
+		t.Error("Compile() with action on a deny rule succeeded, want error")
+	}
+}
+
+func TestParseString(t *testing.T) {
+	cfg, err := ParseString(`
+[[deny]]
+tool = "Bash"
+command_patterns = ["^git push.*--force"]
+description = "No force push"
+`)
+	if err != nil {
+		t.Fatalf("ParseString() error = %v", err)
+	}
+	if len(cfg.Deny) != 1 || len(cfg.Deny[0].GetCompiledCommandPatterns()) != 1 {
+		t.Errorf("deny rules = %+v, want one compiled command pattern", cfg.Deny)
+	}
+	if cfg.Audit.AuditLevel != "matched" {
+		t.Errorf("audit level = %q, want the default %q", cfg.Audit.AuditLevel, "matched")
+	}
+
+	if _, err := Parse([]byte(`[[allow]]`), "json"); err == nil {
+		t.Error("Parse() with an unsupported format succeeded, want error")
+	}
+	if _, err := Parse([]byte("[[deny]]\ntool = \"Bash\"\ncommand_patterns = [\"(\"]\n"), "toml"); err == nil {
+		t.Error("Parse() with an invalid pattern succeeded, want error")
+	}
+}
+
+func TestAuditSinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		audit   AuditConfig
+		want    []string
+		wantErr bool
+	}{
+		{name: "off", audit: AuditConfig{}},
+		{name: "file only", audit: AuditConfig{AuditFile: "/tmp/a.jsonl"}, want: []string{SinkFile}},
+		{name: "explicit", audit: AuditConfig{AuditFile: "/tmp/a.jsonl", Sinks: []string{"file", "syslog"}}, want: []string{SinkFile, SinkSyslog}},
+		{name: "stderr without file", audit: AuditConfig{Sinks: []string{"stderr"}}, want: []string{SinkStderr}},
+		{name: "file sink without file", audit: AuditConfig{Sinks: []string{"file"}}, wantErr: true},
+		{name: "unknown sink", audit: AuditConfig{Sinks: []string{"kafka"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Audit: tt.audit}
+			err := Compile(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Compile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := cfg.Audit.SinkNames(); !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("SinkNames() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string // Empty when the config should load
+		notWant string // Must not appear in the error
+	}{
+		{
+			name:  "known keys",
+			input: "[[allow]]\ntool = \"Bash\"\ncommands = [\"ls\"]\n[bash]\nallow_pipes = true\n[aliases]\npython3 = \"python\"\n",
+		},
+		{
+			name:    "rule key typo names the rule",
+			input:   "[[allow]]\ntool = \"Bash\"\ncommands = [\"ls\"]\n[[allow]]\ntool = \"Bash\"\ncommand = [\"rm\"]\n",
+			wantErr: "allow[1].command",
+		},
+		{
+			name:    "inline rule",
+			input:   "deny = [{tool = \"Bash\", excludes_patterns = [\"x\"]}]\n",
+			wantErr: "deny[0].excludes_patterns",
+		},
+		{
+			name:    "section key typo",
+			input:   "[bash]\nallow_pipe = true\n",
+			wantErr: "bash.allow_pipe",
+		},
+		{
+			name:    "unknown table is reported once",
+			input:   "[bsh]\nallow_pipes = true\n",
+			wantErr: "bsh",
+			notWant: "bsh.allow_pipes",
+		},
+		{
+			name:    "top-level key",
+			input:   "failmode = \"closed\"\n",
+			wantErr: "failmode",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseString(tt.input)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ParseString() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseString() error = %v, want it to name %q", err, tt.wantErr)
+			}
+			if tt.notWant != "" && strings.Contains(err.Error(), tt.notWant) {
+				t.Errorf("ParseString() error = %v, want no %q", err, tt.notWant)
+			}
+		})
+	}
+}
+
+func TestMatchMode(t *testing.T) {
+	rule := Rule{Tool: "Bash", CommandPatterns: []string{"rm"}, MatchMode: "exact"}
+	if err := rule.Compile(); err == nil {
+		t.Error("Compile() with an unknown match_mode succeeded, want error")
+	}
+
+	// Wrapping would balance this pattern, so it's checked on its own
+	rule = Rule{Tool: "Bash", CommandPatterns: []string{"a)|(b"}, MatchMode: MatchAnchored}
+	if err := rule.Compile(); err == nil {
+		t.Error("Compile() with an unbalanced pattern succeeded, want error")
+	}
+
+	rule = Rule{Tool: "Bash", CommandPatterns: []string{"git status"}, MatchMode: MatchAnchored}
+	if err := rule.Compile(); err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	re := rule.GetCompiledCommandPatterns()[0]
+	if !re.MatchString("git status") || re.MatchString("git status; rm -rf /") {
+		t.Errorf("anchored pattern %s should match only the whole command", re)
+	}
+}
+
+func TestSizeWarnings(t *testing.T) {
+	long := strings.Repeat("a", DefaultMaxPatternLength+1)
+
+	tests := []struct {
+		name string
+		toml string
+		want []string
+	}{
+		{
+			name: "within defaults",
+			toml: "[[allow]]\ntool = \"Bash\"\ncommand_patterns = [\"^git status$\"]\n",
+		},
+		{
+			name: "pattern over the default length",
+			toml: "[[deny]]\ntool = \"Read\"\npath_patterns = [\"x\", \"" + long + "\"]\n",
+			want: []string{fmt.Sprintf("deny[0].path_patterns[1] is %d characters, over max_pattern_length %d", len(long), DefaultMaxPatternLength)},
+		},
+		{
+			name: "pattern over a configured length",
+			toml: "[settings]\nmax_pattern_length = 5\n[[allow]]\ntool = \"Bash\"\ncommand_patterns = [\"abcde\", \"abcdef\"]\n",
+			want: []string{"allow[0].command_patterns[1] is 6 characters, over max_pattern_length 5"},
+		},
+		{
+			name: "rules over a configured count",
+			toml: "[settings]\nmax_rules = 2\n" + strings.Repeat("[[allow]]\ntool = \"Bash\"\ncommands = [\"ls\"]\n", 3),
+			want: []string{"3 rules, over max_rules 2"},
+		},
+		{
+			name: "rules at the configured count",
+			toml: "[settings]\nmax_rules = 2\n" + strings.Repeat("[[allow]]\ntool = \"Bash\"\ncommands = [\"ls\"]\n", 2),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ParseString(tt.toml)
+			if err != nil {
+				t.Fatalf("ParseString() error = %v", err)
+			}
+			if !slices.Equal(cfg.Warnings, tt.want) {
+				t.Errorf("Warnings = %q, want %q", cfg.Warnings, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseString("[settings]\nmax_rules = -1\n"); err == nil {
+		t.Error("max_rules = -1 loaded, want error")
+	}
+}
+
+func TestPathGlobs(t *testing.T) {
+	tests := []struct {
+		name  string
+		globs []string
+		path  string
+		want  bool
+	}{
+		{"brace alternative", []string{"src/**/*.{go,rs}"}, "/repo/src/pkg/main.go", true},
+		{"other brace alternative", []string{"src/**/*.{go,rs}"}, "/repo/src/lib.rs", true},
+		{"outside braces", []string{"src/**/*.{go,rs}"}, "/repo/src/main.py", false},
+		{"nested braces", []string{"*.{md,{yaml,yml}}"}, "/repo/config.yml", true},
+		{"basename at any depth", []string{"*.env"}, "/repo/deploy/prod.env", true},
+		{"star stays in one directory", []string{"src/*.go"}, "/repo/src/pkg/main.go", false},
+		{"rooted glob", []string{"/etc/**"}, "/etc/ssh/sshd_config", true},
+		{"rooted glob elsewhere", []string{"/etc/**"}, "/repo/etc/x", false},
+		{"character class", []string{"log[0-9].txt"}, "/tmp/log7.txt", true},
+		{"negated class", []string{"log[!0-9].txt"}, "/tmp/log7.txt", false},
+		{"negation excludes", []string{"src/**", "!src/vendor/**"}, "/repo/src/vendor/x/y.go", false},
+		{"negation leaves the rest", []string{"src/**", "!src/vendor/**"}, "/repo/src/app/y.go", true},
+		{"later glob re-includes", []string{"src/**", "!src/vendor/**", "src/vendor/ours/**"}, "/repo/src/vendor/ours/a.go", true},
+		{"negation before include has no effect", []string{"!src/vendor/**", "src/**"}, "/repo/src/vendor/x.go", true},
+		{"negation with braces", []string{"**/*.{go,rs}", "!**/*_test.{go,rs}"}, "/repo/a/b_test.rs", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := Rule{Tool: "Read", PathGlobs: tt.globs}
+			if err := rule.Compile(); err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			if _, got := rule.MatchPathGlobs(tt.path); got != tt.want {
+				t.Errorf("MatchPathGlobs(%q) with %q = %v, want %v", tt.path, tt.globs, got, tt.want)
+			}
+		})
+	}
+
+	tooMany := strings.Repeat("{a,b}", 11)
+	for _, globs := range [][]string{{"src/{a,b"}, {"src/a}"}, {"!"}, {"!src/vendor/**"}, {tooMany}} {
+		rule := Rule{Tool: "Read", PathGlobs: globs}
+		if err := rule.Compile(); err == nil {
+			t.Errorf("Compile() of path_globs %q succeeded, want error", globs)
+		}
+	}
+}
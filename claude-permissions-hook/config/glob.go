@@ -0,0 +1,163 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// pathGlob is a compiled path_globs entry. Negated entries (!src/vendor/**)
+// exclude paths an earlier entry included.
+type pathGlob struct {
+	glob   string
+	negate bool
+	re     *regexp.Regexp
+}
+
+// compilePathGlob compiles a glob, expanding braces into alternatives. A
+// glob without a leading / matches at any directory boundary, so "*.go"
+// matches Go files anywhere and "src/**" any path with a src directory.
+func compilePathGlob(glob, prefix string) (pathGlob, error) {
+	g := pathGlob{glob: glob}
+	pattern := glob
+	if strings.HasPrefix(pattern, "!") {
+		g.negate = true
+		pattern = pattern[1:]
+	}
+	if pattern == "" {
+		return g, fmt.Errorf("invalid path glob %q: empty pattern", glob)
+	}
+
+	alternatives, err := expandBraces(pattern)
+	if err != nil {
+		return g, fmt.Errorf("invalid path glob %q: %w", glob, err)
+	}
+	regexes := make([]string, len(alternatives))
+	for i, alt := range alternatives {
+		regexes[i] = globToRegexp(alt)
+	}
+	re, err := regexp.Compile(prefix + "(?:" + strings.Join(regexes, "|") + ")")
+	if err != nil {
+		return g, fmt.Errorf("invalid path glob %q: %w", glob, err)
+	}
+	g.re = re
+	return g, nil
+}
+
+// maxBraceExpansions caps how many alternatives a glob's braces expand to,
+// since each group multiplies the count
+const maxBraceExpansions = 1024
+
+// expandBraces expands {a,b} groups, including nested ones, into every
+// combination: "*.{go,rs}" becomes "*.go" and "*.rs"
+func expandBraces(pattern string) ([]string, error) {
+	start := strings.IndexByte(pattern, '{')
+	if start < 0 {
+		if strings.IndexByte(pattern, '}') >= 0 {
+			return nil, fmt.Errorf("unmatched }")
+		}
+		return []string{pattern}, nil
+	}
+
+	// Find the matching close brace and the top-level commas inside it
+	depth := 0
+	commas := []int{}
+	end := -1
+	for i := start; i < len(pattern) && end < 0; i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				end = i
+			}
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		}
+	}
+	if end < 0 {
+		return nil, fmt.Errorf("unmatched {")
+	}
+
+	var options []string
+	prev := start + 1
+	for _, comma := range append(commas, end) {
+		options = append(options, pattern[prev:comma])
+		prev = comma + 1
+	}
+
+	var expanded []string
+	for _, option := range options {
+		rest, err := expandBraces(pattern[:start] + option + pattern[end+1:])
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, rest...)
+		if len(expanded) > maxBraceExpansions {
+			return nil, fmt.Errorf("braces expand to more than %d alternatives", maxBraceExpansions)
+		}
+	}
+	return expanded, nil
+}
+
+// globToRegexp converts a brace-free glob to a regular expression: ** spans
+// directories, * and ? stay within one, and [...] is a character class
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	if strings.HasPrefix(glob, "/") {
+		b.WriteString("^")
+	} else {
+		b.WriteString("(?:^|/)")
+	}
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '*' && strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?") // Zero or more directories
+			i += 2
+		case c == '*' && strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			if end := strings.IndexByte(glob[i+1:], ']'); end >= 0 {
+				class := glob[i+1 : i+1+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				b.WriteString("[" + class + "]")
+				i += end + 1
+				continue
+			}
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// MatchPathGlobs checks a path against the rule's path_globs in order, like
+// .gitignore: the last entry matching the path decides, so a negated entry
+// excludes what earlier ones included and a later entry can include it
+// again. It returns the deciding glob when the path is included.
+func (r *Rule) MatchPathGlobs(path string) (string, bool) {
+	decided := ""
+	included := false
+	for _, g := range r.compiledPathGlobs {
+		if g.re.MatchString(path) {
+			decided, included = g.glob, !g.negate
+		}
+	}
+	if !included {
+		return "", false
+	}
+	return decided, true
+}
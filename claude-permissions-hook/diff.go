@@ -0,0 +1,660 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/config"
+)
+
+// RuleChange describes how a single rule or setting differs between two
+// configs
+type RuleChange struct {
+	Kind   string // "allow", "deny" or "setting"
+	Op     string // "added", "removed" or "modified"
+	Tool   string
+	Name   string
+	Fields []FieldChange
+	Notes  []string // Security-relevant observations
+	// Broadening is set when the change permits more than before: a new or
+	// broader allow rule, or a removed or narrower deny rule
+	Broadening bool
+}
+
+// FieldChange lists the values added to and removed from one rule field
+type FieldChange struct {
+	Field   string
+	Added   []string
+	Removed []string
+}
+
+// diffCmd compares two configuration files and reports rule and setting changes
+func diffCmd(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	oldPath := fs.String("old", "", "Path to the old TOML configuration file")
+	newPath := fs.String("new", "", "Path to the new TOML configuration file")
+	failOnBroadening := fs.Bool("fail-on-broadening", false, "Exit non-zero if any change broadens what is allowed")
+	fs.Parse(args)
+
+	if *oldPath == "" || *newPath == "" {
+		fmt.Fprintln(os.Stderr, "Error: --old and --new are required")
+		os.Exit(1)
+	}
+
+	oldCfg, err := config.Load(*oldPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading old config: %v\n", err)
+		os.Exit(1)
+	}
+	newCfg, err := config.Load(*newPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading new config: %v\n", err)
+		os.Exit(1)
+	}
+
+	changes := diffConfigs(oldCfg, newCfg)
+	printRuleChanges(changes)
+
+	if *failOnBroadening {
+		for _, c := range changes {
+			if c.Broadening {
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// diffConfigs compares the allow and deny rules of two configs, and the
+// [settings], [bash] and [defaults] values that decide what rules don't.
+// Rules are matched up by tool and description, and list fields are
+// compared as sets so reordering isn't reported.
+func diffConfigs(oldCfg, newCfg *config.Config) []RuleChange {
+	var changes []RuleChange
+	changes = append(changes, diffRules("deny", oldCfg.Deny, newCfg.Deny)...)
+	changes = append(changes, diffRules("allow", oldCfg.Allow, newCfg.Allow)...)
+	changes = append(changes, diffSettings(oldCfg, newCfg)...)
+	return changes
+}
+
+// diffSettings compares the resolved [settings], [bash] and [defaults]
+// values of two configs, so leaving a key out and setting its default are
+// the same. A change is broadening when it lets more through: a check
+// turned off, a looser decision, or deny_list_mode turned on.
+func diffSettings(oldCfg, newCfg *config.Config) []RuleChange {
+	var changes []RuleChange
+	add := func(section, key string, oldValues, newValues []string, broadening bool) {
+		added, removed := diffSets(oldValues, newValues)
+		if len(added) == 0 && len(removed) == 0 {
+			return
+		}
+		c := RuleChange{
+			Kind:   "setting",
+			Op:     "modified",
+			Tool:   section,
+			Name:   key,
+			Fields: []FieldChange{{Field: key, Added: added, Removed: removed}},
+		}
+		if broadening {
+			c.Broadening = true
+			c.Notes = append(c.Notes, fmt.Sprintf("%s.%s permits more: %s", section, key, describeFieldChange(c.Fields[0])))
+		}
+		changes = append(changes, c)
+	}
+	flag := func(section, key string, oldOn, newOn, onIsStricter bool) {
+		add(section, key, []string{strconv.FormatBool(oldOn)}, []string{strconv.FormatBool(newOn)},
+			oldOn != newOn && oldOn == onIsStricter)
+	}
+	decision := func(section, key, oldDecision, newDecision string) {
+		add(section, key, scalar(oldDecision), scalar(newDecision), decisionRank(newDecision) > decisionRank(oldDecision))
+	}
+
+	oldSettings, newSettings := oldCfg.Settings, newCfg.Settings
+	flag("settings", "deny_list_mode", oldSettings.DenyListMode, newSettings.DenyListMode, false)
+	decision("settings", "parse_failure", oldSettings.ParseFailureDecision(), newSettings.ParseFailureDecision())
+	add("settings", "compound_mode", []string{oldSettings.CompoundModeResolved()}, []string{newSettings.CompoundModeResolved()},
+		oldSettings.CompoundModeResolved() == config.CompoundAll && newSettings.CompoundModeResolved() != config.CompoundAll)
+	flag("settings", "restrict_outside_cwd", oldSettings.RestrictOutsideCwd, newSettings.RestrictOutsideCwd, true)
+	decision("settings", "outside_cwd_decision", oldSettings.OutsideCwdDecisionResolved(), newSettings.OutsideCwdDecisionResolved())
+	flag("settings", "block_raw_ip_hosts", oldSettings.BlockRawIPHosts, newSettings.BlockRawIPHosts, true)
+	add("settings", "git_hook_env", oldSettings.GitHookEnvVars(), newSettings.GitHookEnvVars(), false)
+
+	oldBash, newBash := oldCfg.GetBashConfig(), newCfg.GetBashConfig()
+	flag("bash", "allow_pipes", oldBash.AllowPipes, newBash.AllowPipes, false)
+	flag("bash", "allow_subshells", oldBash.AllowSubshells, newBash.AllowSubshells, false)
+	flag("bash", "allow_background", oldBash.AllowBackground, newBash.AllowBackground, false)
+	flag("bash", "allow_redirects", oldBash.AllowRedirects, newBash.AllowRedirects, false)
+	flag("bash", "allow_process_substitution", oldBash.AllowProcessSubstitution, newBash.AllowProcessSubstitution, false)
+	flag("bash", "allow_find_delete", oldBash.AllowFindDelete, newBash.AllowFindDelete, false)
+	decision("bash", "empty_command_decision", oldBash.EmptyCommandDecision, newBash.EmptyCommandDecision)
+	decision("bash", "dynamic_command_decision", oldBash.DynamicCommandDecision, newBash.DynamicCommandDecision)
+	decision("bash", "background_decision", oldBash.BackgroundDecision, newBash.BackgroundDecision)
+	flag("bash", "match_remote_commands", oldBash.MatchRemoteCommands, newBash.MatchRemoteCommands, true)
+	flag("bash", "match_redirect_paths", oldBash.MatchRedirectPaths, newBash.MatchRedirectPaths, true)
+	flag("bash", "deny_broad_recursive_permissions", oldBash.DenyBroadRecursivePermissions, newBash.DenyBroadRecursivePermissions, true)
+	flag("bash", "block_pipe_to_shell", oldBash.BlockPipeToShell, newBash.BlockPipeToShell, true)
+	_, removed := diffSets(oldBash.PipeToShellSources, newBash.PipeToShellSources)
+	add("bash", "pipe_to_shell_sources", oldBash.PipeToShellSources, newBash.PipeToShellSources,
+		newBash.BlockPipeToShell && len(removed) > 0)
+	flag("bash", "block_git_config_keys", oldBash.BlockGitConfigKeys, newBash.BlockGitConfigKeys, true)
+	_, removed = diffSets(oldBash.GitConfigKeys, newBash.GitConfigKeys)
+	add("bash", "git_config_keys", oldBash.GitConfigKeys, newBash.GitConfigKeys,
+		newBash.BlockGitConfigKeys && len(removed) > 0)
+
+	// A tool's entry is compared by the decision it ends up with, so dropping
+	// Bash = "deny" under default = "allow" is broadening
+	var tools []string
+	for tool := range oldCfg.Defaults {
+		tools = append(tools, tool)
+	}
+	for tool := range newCfg.Defaults {
+		if _, ok := oldCfg.Defaults[tool]; !ok {
+			tools = append(tools, tool)
+		}
+	}
+	sort.Strings(tools)
+	for _, tool := range tools {
+		oldDecision, _ := oldCfg.DefaultDecision(tool)
+		newDecision, _ := newCfg.DefaultDecision(tool)
+		add("defaults", tool, scalar(oldCfg.Defaults[tool]), scalar(newCfg.Defaults[tool]),
+			decisionRank(newDecision) > decisionRank(oldDecision))
+	}
+
+	return changes
+}
+
+// decisionRank orders decisions from strictest to loosest. No decision
+// leaves it to the prompt, like ask.
+func decisionRank(decision string) int {
+	switch decision {
+	case "deny":
+		return 0
+	case "allow":
+		return 2
+	}
+	return 1
+}
+
+// diffRules compares two lists of rules of the same kind
+func diffRules(kind string, oldRules, newRules []config.Rule) []RuleChange {
+	oldByKey := make(map[string]config.Rule)
+	var oldKeys []string
+	for i, r := range oldRules {
+		key := ruleKey(r, i)
+		oldByKey[key] = r
+		oldKeys = append(oldKeys, key)
+	}
+	newByKey := make(map[string]config.Rule)
+	var newKeys []string
+	for i, r := range newRules {
+		key := ruleKey(r, i)
+		newByKey[key] = r
+		newKeys = append(newKeys, key)
+	}
+
+	var changes []RuleChange
+	for _, key := range oldKeys {
+		oldRule := oldByKey[key]
+		newRule, ok := newByKey[key]
+		if !ok {
+			c := RuleChange{Kind: kind, Op: "removed", Tool: oldRule.Tool, Name: ruleName(oldRule)}
+			if kind == "deny" && oldRule.IsEnabled() {
+				c.Broadening = true
+				c.Notes = append(c.Notes, "deny rule removed")
+			}
+			changes = append(changes, c)
+			continue
+		}
+
+		fields := diffRuleFields(oldRule, newRule)
+		if len(fields) == 0 {
+			continue
+		}
+		c := RuleChange{Kind: kind, Op: "modified", Tool: newRule.Tool, Name: ruleName(newRule), Fields: fields}
+		c.Notes, c.Broadening = assessModification(kind, oldRule, fields)
+		changes = append(changes, c)
+	}
+
+	for _, key := range newKeys {
+		if _, ok := oldByKey[key]; ok {
+			continue
+		}
+		newRule := newByKey[key]
+		c := RuleChange{
+			Kind:   kind,
+			Op:     "added",
+			Tool:   newRule.Tool,
+			Name:   ruleName(newRule),
+			Fields: diffRuleFields(config.Rule{}, newRule),
+		}
+		if kind == "allow" && newRule.IsEnabled() {
+			c.Broadening = true
+			c.Notes = append(c.Notes, "new allow rule")
+		}
+		changes = append(changes, c)
+	}
+
+	return changes
+}
+
+// ruleKey identifies a rule across configs. Rules without a description fall
+// back to their position.
+func ruleKey(r config.Rule, index int) string {
+	if r.Description != "" {
+		return r.Tool + "\x00" + r.Description
+	}
+	return r.Tool + "\x00#" + strconv.Itoa(index)
+}
+
+// ruleName returns a human-readable name for a rule
+func ruleName(r config.Rule) string {
+	if r.Description != "" {
+		return r.Description
+	}
+	return "(no description)"
+}
+
+// diffRuleFields compares each field of two rules
+func diffRuleFields(oldRule, newRule config.Rule) []FieldChange {
+	var fields []FieldChange
+	add := func(field string, oldValues, newValues []string) {
+		added, removed := diffSets(oldValues, newValues)
+		if len(added) > 0 || len(removed) > 0 {
+			fields = append(fields, FieldChange{Field: field, Added: added, Removed: removed})
+		}
+	}
+
+	add("commands", oldRule.Commands, newRule.Commands)
+	add("command_patterns", oldRule.CommandPatterns, newRule.CommandPatterns)
+	add("exclude_patterns", oldRule.ExcludePatterns, newRule.ExcludePatterns)
+	add("path_patterns", oldRule.PathPatterns, newRule.PathPatterns)
+	add("path_exclude_patterns", oldRule.PathExcludePatterns, newRule.PathExcludePatterns)
+	add("path_globs", oldRule.PathGlobs, newRule.PathGlobs)
+	add("content_patterns", oldRule.ContentPatterns, newRule.ContentPatterns)
+	add("denied_env", oldRule.DeniedEnv, newRule.DeniedEnv)
+	add("denied_flag_sets", flagSets(oldRule.DeniedFlagSets), flagSets(newRule.DeniedFlagSets))
+	add("dir_patterns", oldRule.DirPatterns, newRule.DirPatterns)
+	add("tags", oldRule.Tags, newRule.Tags)
+	add("input_field", scalar(oldRule.InputField), scalar(newRule.InputField))
+	add("json_pointer", scalar(oldRule.JSONPointer), scalar(newRule.JSONPointer))
+	add("priority", scalar(strconv.Itoa(oldRule.Priority)), scalar(strconv.Itoa(newRule.Priority)))
+	add("require_limit", scalar(strconv.FormatBool(oldRule.RequireLimit)), scalar(strconv.FormatBool(newRule.RequireLimit)))
+	add("halt", scalar(strconv.FormatBool(oldRule.Halt)), scalar(strconv.FormatBool(newRule.Halt)))
+	add("action", ruleAction(oldRule), ruleAction(newRule))
+	add("match_mode", scalar(oldRule.MatchMode), scalar(newRule.MatchMode))
+	add("pattern_scope", scalar(oldRule.PatternScope), scalar(newRule.PatternScope))
+	add("case_insensitive", scalar(strconv.FormatBool(oldRule.CaseInsensitive)), scalar(strconv.FormatBool(newRule.CaseInsensitive)))
+	add("in_git_hook", optionalBool(oldRule.InGitHook), optionalBool(newRule.InGitHook))
+	add("disabled", scalar(strconv.FormatBool(!oldRule.IsEnabled())), scalar(strconv.FormatBool(!newRule.IsEnabled())))
+
+	return fields
+}
+
+// ruleAction returns an allow rule's action as a comparable value; the
+// default, allow, has none
+func ruleAction(rule config.Rule) []string {
+	if rule.Asks() {
+		return []string{config.ActionAsk}
+	}
+	return nil
+}
+
+// optionalBool renders an optional bool as a set, where unset is empty and
+// false is a value of its own
+func optionalBool(value *bool) []string {
+	if value == nil {
+		return nil
+	}
+	return []string{strconv.FormatBool(*value)}
+}
+
+// flagSets renders denied_flag_sets entries as comparable values, e.g. "r+f"
+func flagSets(sets [][]string) []string {
+	var values []string
+	for _, set := range sets {
+		values = append(values, strings.Join(set, "+"))
+	}
+	return values
+}
+
+// scalar wraps a single value as a set, treating zero values as unset
+func scalar(value string) []string {
+	if value == "" || value == "0" || value == "false" {
+		return nil
+	}
+	return []string{value}
+}
+
+// diffSets returns the sorted values only in newValues and only in oldValues
+func diffSets(oldValues, newValues []string) (added, removed []string) {
+	oldSet := make(map[string]bool)
+	for _, v := range oldValues {
+		oldSet[v] = true
+	}
+	newSet := make(map[string]bool)
+	for _, v := range newValues {
+		newSet[v] = true
+	}
+	for v := range newSet {
+		if !oldSet[v] {
+			added = append(added, v)
+		}
+	}
+	for v := range oldSet {
+		if !newSet[v] {
+			removed = append(removed, v)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// assessModification flags field changes that make a rule permit more
+func assessModification(kind string, oldRule config.Rule, fields []FieldChange) ([]string, bool) {
+	var notes []string
+	broadening := false
+
+	for _, f := range fields {
+		switch f.Field {
+		case "commands", "command_patterns", "path_patterns":
+			if f.Field == "path_patterns" && oldRule.Tool == "Bash" {
+				// On Bash rules, path patterns narrow the rule to the
+				// script files they match
+				if note, ok := restrictionBroadening(kind, f, len(oldRule.PathPatterns)); ok {
+					broadening = true
+					notes = append(notes, note)
+				}
+				continue
+			}
+			if kind == "allow" {
+				if added := broadenedNotes(f, oldRule.Commands); len(added) > 0 {
+					broadening = true
+					notes = append(notes, added...)
+				}
+			}
+			if kind == "deny" && len(f.Removed) > 0 {
+				broadening = true
+				notes = append(notes, fmt.Sprintf("deny %s removed: %s", f.Field, strings.Join(f.Removed, ", ")))
+			}
+		case "in_git_hook":
+			// Unset applies the rule both inside and outside git hooks; a
+			// value limits it to one of them
+			widened := len(f.Removed) > 0
+			narrowed := len(f.Added) > 0
+			if kind == "allow" && widened {
+				broadening = true
+				notes = append(notes, "allow in_git_hook scope widened: "+describeFieldChange(f))
+			}
+			if kind == "deny" && narrowed {
+				broadening = true
+				notes = append(notes, "deny in_git_hook scope narrowed: "+describeFieldChange(f))
+			}
+		case "dir_patterns":
+			// Directory patterns narrow a rule to git commands aimed at a
+			// matching repository
+			if note, ok := restrictionBroadening(kind, f, len(oldRule.DirPatterns)); ok {
+				broadening = true
+				notes = append(notes, note)
+			}
+		case "content_patterns":
+			// Content patterns narrow a rule to matching content, so the
+			// change broadens an allow rule that widens and a deny rule
+			// that narrows
+			widened, narrowed := restrictionChange(f, len(oldRule.ContentPatterns))
+			if kind == "allow" && widened {
+				broadening = true
+				notes = append(notes, "allow content_patterns widened: "+describeFieldChange(f))
+			}
+			if kind == "deny" && narrowed {
+				broadening = true
+				notes = append(notes, "deny content_patterns narrowed: "+describeFieldChange(f))
+			}
+		case "denied_env":
+			// Denied variables keep an allow rule from matching but narrow a
+			// deny rule to commands that set them
+			if kind == "allow" && len(f.Removed) > 0 {
+				broadening = true
+				notes = append(notes, "allow denied_env removed: "+strings.Join(f.Removed, ", "))
+			}
+			if _, narrowed := restrictionChange(f, len(oldRule.DeniedEnv)); kind == "deny" && narrowed {
+				broadening = true
+				notes = append(notes, "deny denied_env narrowed: "+describeFieldChange(f))
+			}
+		case "path_exclude_patterns":
+			if kind == "allow" && len(f.Removed) > 0 {
+				broadening = true
+				notes = append(notes, "allow exclusions removed: "+strings.Join(f.Removed, ", "))
+			}
+		case "exclude_patterns":
+			if kind == "allow" && len(f.Removed) > 0 {
+				broadening = true
+				notes = append(notes, "allow exclusions removed: "+strings.Join(f.Removed, ", "))
+			}
+			if kind == "deny" && len(f.Added) > 0 {
+				broadening = true
+				notes = append(notes, "deny exclusions added: "+strings.Join(f.Added, ", "))
+			}
+		case "path_globs":
+			// An included glob widens the rule, an excluding one narrows it
+			widened, narrowed := globChanges(f)
+			if kind == "allow" && len(widened) > 0 {
+				broadening = true
+				notes = append(notes, "allow path_globs broadened: "+strings.Join(widened, ", "))
+			}
+			if kind == "deny" && len(narrowed) > 0 {
+				broadening = true
+				notes = append(notes, "deny path_globs narrowed: "+strings.Join(narrowed, ", "))
+			}
+		case "denied_flag_sets":
+			// Flag sets narrow a deny rule but restrict an allow rule
+			if kind == "allow" && len(f.Removed) > 0 {
+				broadening = true
+				notes = append(notes, "allow denied_flag_sets removed: "+strings.Join(f.Removed, ", "))
+			}
+			if kind == "deny" && len(f.Added) > 0 {
+				broadening = true
+				notes = append(notes, "deny narrowed to flag sets: "+strings.Join(f.Added, ", "))
+			}
+		case "pattern_scope":
+			notes = append(notes, "pattern scope changed")
+		case "require_limit":
+			if kind == "allow" && len(f.Removed) > 0 {
+				broadening = true
+				notes = append(notes, "allow no longer requires a read limit")
+			}
+		case "disabled":
+			if kind == "deny" && len(f.Added) > 0 {
+				broadening = true
+				notes = append(notes, "deny rule disabled")
+			}
+			if kind == "allow" && len(f.Removed) > 0 {
+				broadening = true
+				notes = append(notes, "allow rule enabled")
+			}
+		case "action":
+			if kind == "allow" && len(f.Removed) > 0 {
+				broadening = true
+				notes = append(notes, "allow rule no longer asks for confirmation")
+			}
+		case "priority":
+			notes = append(notes, "priority changed")
+		case "match_mode":
+			// A looser mode lets an allow pattern match more commands, and a
+			// stricter one lets a deny pattern match fewer
+			oldRank, newRank := matchModeStrictness(f.Removed), matchModeStrictness(f.Added)
+			if kind == "allow" && newRank < oldRank {
+				broadening = true
+				notes = append(notes, "allow match_mode loosened: patterns match more commands")
+			}
+			if kind == "deny" && newRank > oldRank {
+				broadening = true
+				notes = append(notes, "deny match_mode tightened: patterns match fewer commands")
+			}
+		}
+	}
+
+	return notes, broadening
+}
+
+// restrictionChange classifies a change to a field that narrows a rule to
+// what matches one of its entries, given how many entries the rule had.
+// The first entry added restricts the rule and removing the last lifts the
+// restriction; otherwise added entries widen it and removed ones narrow it.
+func restrictionChange(f FieldChange, oldCount int) (widened, narrowed bool) {
+	newCount := oldCount - len(f.Removed) + len(f.Added)
+	switch {
+	case oldCount == 0:
+		return false, newCount > 0
+	case newCount == 0:
+		return true, false
+	}
+	return len(f.Added) > 0, len(f.Removed) > 0
+}
+
+// restrictionBroadening reports whether a change to a restricting field
+// (see restrictionChange) broadens a rule of the given kind: it widens an
+// allow rule or narrows a deny rule. It returns a note describing it.
+func restrictionBroadening(kind string, f FieldChange, oldCount int) (string, bool) {
+	widened, narrowed := restrictionChange(f, oldCount)
+	switch {
+	case kind == "allow" && widened:
+		return fmt.Sprintf("allow %s widened: %s", f.Field, describeFieldChange(f)), true
+	case kind == "deny" && narrowed:
+		return fmt.Sprintf("deny %s narrowed: %s", f.Field, describeFieldChange(f)), true
+	}
+	return "", false
+}
+
+// describeFieldChange lists a field's added and removed entries, e.g.
+// "added a, b; removed c"
+func describeFieldChange(f FieldChange) string {
+	var parts []string
+	if len(f.Added) > 0 {
+		parts = append(parts, "added "+strings.Join(f.Added, ", "))
+	}
+	if len(f.Removed) > 0 {
+		parts = append(parts, "removed "+strings.Join(f.Removed, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// globChanges splits a path_globs change into the changes that let the rule
+// cover more paths (an added glob, a removed ! exclusion) and those that
+// make it cover fewer
+func globChanges(f FieldChange) (widened, narrowed []string) {
+	for _, glob := range f.Added {
+		if strings.HasPrefix(glob, "!") {
+			narrowed = append(narrowed, "added "+glob)
+		} else {
+			widened = append(widened, "added "+glob)
+		}
+	}
+	for _, glob := range f.Removed {
+		if strings.HasPrefix(glob, "!") {
+			widened = append(widened, "removed "+glob)
+		} else {
+			narrowed = append(narrowed, "removed "+glob)
+		}
+	}
+	return widened, narrowed
+}
+
+// matchModeStrictness ranks a match_mode field value (empty meaning the
+// substring default) from loosest to strictest
+func matchModeStrictness(values []string) int {
+	if len(values) == 0 {
+		return 0
+	}
+	switch values[0] {
+	case config.MatchWord:
+		return 1
+	case config.MatchAnchored:
+		return 2
+	}
+	return 0
+}
+
+// broadenedNotes describes added allow entries, calling out entries that
+// replace a more specific one (e.g. "git commit" -> "git"). Added commands
+// already covered by an old signature (e.g. "git status" under "git") are
+// narrower, not broader, and are skipped.
+func broadenedNotes(f FieldChange, oldCommands []string) []string {
+	var notes []string
+	for _, added := range f.Added {
+		if f.Field == "commands" && coveredBy(added, oldCommands) {
+			continue
+		}
+		replaced := ""
+		for _, removed := range f.Removed {
+			if f.Field == "commands" && strings.HasPrefix(removed, added+" ") {
+				replaced = removed
+				break
+			}
+		}
+		if replaced != "" {
+			notes = append(notes, fmt.Sprintf("allow broadened: %q -> %q", replaced, added))
+		} else {
+			notes = append(notes, fmt.Sprintf("allow %s added: %q", f.Field, added))
+		}
+	}
+	return notes
+}
+
+// coveredBy reports whether a command signature was already matched by one
+// of the given signatures through prefix matching
+func coveredBy(command string, signatures []string) bool {
+	for _, sig := range signatures {
+		if command == sig || strings.HasPrefix(command, sig+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// printRuleChanges prints a readable diff of rule and setting changes
+func printRuleChanges(changes []RuleChange) {
+	if len(changes) == 0 {
+		fmt.Println("No changes")
+		return
+	}
+
+	symbols := map[string]string{"added": "+", "removed": "-", "modified": "~"}
+	broadening := 0
+	headers := map[string]string{"deny": "Deny rules:", "allow": "Allow rules:", "setting": "Settings:"}
+	for _, kind := range []string{"deny", "allow", "setting"} {
+		header := false
+		for _, c := range changes {
+			if c.Kind != kind {
+				continue
+			}
+			if !header {
+				fmt.Println(headers[kind])
+				header = true
+			}
+			fmt.Printf("  %s [%s] %s (%s)\n", symbols[c.Op], c.Tool, c.Name, c.Op)
+			for _, f := range c.Fields {
+				for _, v := range f.Added {
+					fmt.Printf("      + %s: %s\n", f.Field, v)
+				}
+				for _, v := range f.Removed {
+					fmt.Printf("      - %s: %s\n", f.Field, v)
+				}
+			}
+			for _, note := range c.Notes {
+				fmt.Printf("      ⚠️  %s\n", note)
+			}
+			if c.Broadening {
+				broadening++
+			}
+		}
+		if header {
+			fmt.Println()
+		}
+	}
+
+	fmt.Printf("%d change(s), %d broadening\n", len(changes), broadening)
+}
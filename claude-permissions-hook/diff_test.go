@@ -0,0 +1,488 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/config"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	oldCfg := &config.Config{
+		Deny: []config.Rule{
+			{Tool: "Bash", Description: "Block push", Commands: []string{"git push"}},
+			{Tool: "Read", Description: "Block secrets", PathPatterns: []string{`\.env$`}},
+		},
+		Allow: []config.Rule{
+			{Tool: "Bash", Description: "Git", Commands: []string{"git commit", "git add"}},
+			{Tool: "Bash", Description: "Read-only", Commands: []string{"ls", "cat"}},
+		},
+	}
+	newCfg := &config.Config{
+		Deny: []config.Rule{
+			{Tool: "Bash", Description: "Block push", Commands: []string{"git push"}},
+		},
+		Allow: []config.Rule{
+			{Tool: "Bash", Description: "Git", Commands: []string{"git add", "git"}},
+			{Tool: "Bash", Description: "Read-only", Commands: []string{"cat", "ls"}}, // reordered only
+			{Tool: "Bash", Description: "Node", Commands: []string{"npm test"}},
+		},
+	}
+
+	changes := diffConfigs(oldCfg, newCfg)
+
+	want := []struct {
+		kind, op, name string
+		broadening     bool
+	}{
+		{"deny", "removed", "Block secrets", true},
+		{"allow", "modified", "Git", true},
+		{"allow", "added", "Node", true},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes, want %d: %+v", len(changes), len(want), changes)
+	}
+	for i, w := range want {
+		c := changes[i]
+		if c.Kind != w.kind || c.Op != w.op || c.Name != w.name || c.Broadening != w.broadening {
+			t.Errorf("change[%d] = %s %s %q broadening=%v, want %s %s %q broadening=%v",
+				i, c.Kind, c.Op, c.Name, c.Broadening, w.kind, w.op, w.name, w.broadening)
+		}
+	}
+
+	notes := changes[1].Notes
+	if len(notes) != 1 || notes[0] != `allow broadened: "git commit" -> "git"` {
+		t.Errorf("modified notes = %v, want broadening from git commit to git", notes)
+	}
+}
+
+func TestDiffConfigsNarrowing(t *testing.T) {
+	oldCfg := &config.Config{
+		Allow: []config.Rule{
+			{Tool: "Bash", Description: "Git", Commands: []string{"git"}},
+		},
+	}
+	newCfg := &config.Config{
+		Allow: []config.Rule{
+			{Tool: "Bash", Description: "Git", Commands: []string{"git status"}},
+		},
+		Deny: []config.Rule{
+			{Tool: "Bash", Description: "Block push", Commands: []string{"git push"}},
+		},
+	}
+
+	for _, c := range diffConfigs(oldCfg, newCfg) {
+		if c.Broadening {
+			t.Errorf("unexpected broadening change: %+v", c)
+		}
+	}
+}
+
+func TestDiffSettings(t *testing.T) {
+	boolPtr := func(b bool) *bool { return &b }
+
+	tests := []struct {
+		name       string
+		old, new   config.Config
+		key        string
+		broadening bool
+	}{
+		{
+			name:       "deny_list_mode on",
+			new:        config.Config{Settings: config.Settings{DenyListMode: true}},
+			key:        "deny_list_mode",
+			broadening: true,
+		},
+		{
+			name:       "deny_list_mode off",
+			old:        config.Config{Settings: config.Settings{DenyListMode: true}},
+			key:        "deny_list_mode",
+			broadening: false,
+		},
+		{
+			name:       "parse_failure deny to ask",
+			old:        config.Config{Settings: config.Settings{ParseFailure: "deny"}},
+			key:        "parse_failure",
+			broadening: true,
+		},
+		{
+			name:       "compound_mode any_reached",
+			new:        config.Config{Settings: config.Settings{CompoundMode: config.CompoundAnyReached}},
+			key:        "compound_mode",
+			broadening: true,
+		},
+		{
+			name:       "background_decision tightened",
+			new:        config.Config{Bash: &config.BashConfig{BackgroundDecision: "deny"}},
+			key:        "background_decision",
+			broadening: false,
+		},
+		{
+			name:       "bash pipes allowed",
+			old:        config.Config{Bash: &config.BashConfig{AllowPipes: boolPtr(false)}},
+			key:        "allow_pipes",
+			broadening: true,
+		},
+		{
+			name:       "bash check turned off",
+			old:        config.Config{Bash: &config.BashConfig{BlockPipeToShell: boolPtr(true)}},
+			new:        config.Config{Bash: &config.BashConfig{BlockPipeToShell: boolPtr(false)}},
+			key:        "block_pipe_to_shell",
+			broadening: true,
+		},
+		{
+			name:       "bash check turned on",
+			new:        config.Config{Bash: &config.BashConfig{BlockGitConfigKeys: boolPtr(true)}},
+			key:        "block_git_config_keys",
+			broadening: false,
+		},
+		{
+			name:       "dynamic command decision loosened",
+			old:        config.Config{Bash: &config.BashConfig{DynamicCommandDecision: "deny"}},
+			key:        "dynamic_command_decision",
+			broadening: true,
+		},
+		{
+			name:       "defaults Bash allow",
+			new:        config.Config{Defaults: map[string]string{"Bash": "allow"}},
+			key:        "Bash",
+			broadening: true,
+		},
+		{
+			name:       "defaults deny removed under allow catch-all",
+			old:        config.Config{Defaults: map[string]string{"Bash": "deny", "default": "allow"}},
+			new:        config.Config{Defaults: map[string]string{"default": "allow"}},
+			key:        "Bash",
+			broadening: true,
+		},
+		{
+			name:       "defaults tightened",
+			old:        config.Config{Defaults: map[string]string{"Read": "allow"}},
+			new:        config.Config{Defaults: map[string]string{"Read": "ask"}},
+			key:        "Read",
+			broadening: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := diffConfigs(&tt.old, &tt.new)
+			if len(changes) != 1 {
+				t.Fatalf("got %d changes, want 1: %+v", len(changes), changes)
+			}
+			c := changes[0]
+			if c.Kind != "setting" || c.Name != tt.key || c.Broadening != tt.broadening {
+				t.Errorf("change = %s %q broadening=%v, want setting %q broadening=%v (notes: %v)",
+					c.Kind, c.Name, c.Broadening, tt.key, tt.broadening, c.Notes)
+			}
+		})
+	}
+
+	// Spelling out a default isn't a change
+	explicit := &config.Config{
+		Settings: config.Settings{CompoundMode: config.CompoundAll, ParseFailure: "ask"},
+		Bash:     &config.BashConfig{AllowPipes: boolPtr(true)},
+	}
+	if changes := diffConfigs(&config.Config{}, explicit); len(changes) != 0 {
+		t.Errorf("explicit defaults reported as changes: %+v", changes)
+	}
+}
+
+func TestDiffMatchMode(t *testing.T) {
+	oldCfg := &config.Config{
+		Allow: []config.Rule{{Tool: "Bash", Description: "Status", CommandPatterns: []string{"git status"}, MatchMode: config.MatchAnchored}},
+		Deny:  []config.Rule{{Tool: "Bash", Description: "No rm", CommandPatterns: []string{"rm"}}},
+	}
+	newCfg := &config.Config{
+		Allow: []config.Rule{{Tool: "Bash", Description: "Status", CommandPatterns: []string{"git status"}}},
+		Deny:  []config.Rule{{Tool: "Bash", Description: "No rm", CommandPatterns: []string{"rm"}, MatchMode: config.MatchWord}},
+	}
+
+	changes := diffConfigs(oldCfg, newCfg)
+	if len(changes) != 2 {
+		t.Fatalf("got %d changes, want 2: %+v", len(changes), changes)
+	}
+	for _, c := range changes {
+		if !c.Broadening {
+			t.Errorf("%s rule %q: match_mode change not flagged as broadening", c.Kind, c.Name)
+		}
+	}
+
+	// Tightening an allow rule is narrowing
+	changes = diffConfigs(newCfg, &config.Config{Allow: oldCfg.Allow, Deny: newCfg.Deny})
+	if len(changes) != 1 || changes[0].Broadening {
+		t.Errorf("anchoring an allow rule: changes = %+v, want one narrowing change", changes)
+	}
+}
+
+func TestDiffDeniedFlagSets(t *testing.T) {
+	oldCfg := &config.Config{
+		Allow: []config.Rule{{Tool: "Bash", Description: "rm", Commands: []string{"rm"}, DeniedFlagSets: [][]string{{"r", "f"}}}},
+		Deny:  []config.Rule{{Tool: "Bash", Description: "No rm", Commands: []string{"rm"}}},
+	}
+	newCfg := &config.Config{
+		Allow: []config.Rule{{Tool: "Bash", Description: "rm", Commands: []string{"rm"}}},
+		Deny:  []config.Rule{{Tool: "Bash", Description: "No rm", Commands: []string{"rm"}, DeniedFlagSets: [][]string{{"r", "f"}}}},
+	}
+
+	changes := diffConfigs(oldCfg, newCfg)
+	if len(changes) != 2 {
+		t.Fatalf("got %d changes, want 2: %+v", len(changes), changes)
+	}
+	for _, c := range changes {
+		if !c.Broadening {
+			t.Errorf("%s rule %q: denied_flag_sets change not flagged as broadening", c.Kind, c.Name)
+		}
+		if got := c.Fields[0]; got.Field != "denied_flag_sets" || len(got.Added)+len(got.Removed) != 1 {
+			t.Errorf("%s rule %q: fields = %+v, want one r+f flag set change", c.Kind, c.Name, c.Fields)
+		}
+	}
+}
+
+func TestDiffDisabledRules(t *testing.T) {
+	disabled := false
+	oldCfg := &config.Config{
+		Allow: []config.Rule{{Tool: "Bash", Description: "Deploy", Commands: []string{"make deploy"}, Enabled: &disabled}},
+		Deny:  []config.Rule{{Tool: "Bash", Description: "No push", Commands: []string{"git push"}}},
+	}
+	newCfg := &config.Config{
+		Allow: []config.Rule{{Tool: "Bash", Description: "Deploy", Commands: []string{"make deploy"}}},
+		Deny:  []config.Rule{{Tool: "Bash", Description: "No push", Commands: []string{"git push"}, Enabled: &disabled}},
+	}
+
+	changes := diffConfigs(oldCfg, newCfg)
+	if len(changes) != 2 {
+		t.Fatalf("got %d changes, want 2: %+v", len(changes), changes)
+	}
+	for _, c := range changes {
+		if !c.Broadening || len(c.Fields) != 1 || c.Fields[0].Field != "disabled" {
+			t.Errorf("%s rule %q = %+v, want a broadening disabled change", c.Kind, c.Name, c)
+		}
+	}
+
+	// Adding a disabled allow rule allows nothing new
+	oldCfg.Allow = nil
+	newCfg.Allow[0].Enabled = &disabled
+	for _, c := range diffConfigs(oldCfg, newCfg) {
+		if c.Kind == "allow" && c.Broadening {
+			t.Errorf("added disabled allow rule flagged as broadening: %+v", c)
+		}
+	}
+}
+
+func TestDiffAskAction(t *testing.T) {
+	oldCfg := &config.Config{
+		Allow: []config.Rule{{Tool: "Bash", Description: "Push", Commands: []string{"git push"}, Action: "ask"}},
+	}
+	newCfg := &config.Config{
+		Allow: []config.Rule{{Tool: "Bash", Description: "Push", Commands: []string{"git push"}, Action: "allow"}},
+	}
+
+	changes := diffConfigs(oldCfg, newCfg)
+	if len(changes) != 1 || !changes[0].Broadening || changes[0].Fields[0].Field != "action" {
+		t.Fatalf("changes = %+v, want one broadening action change", changes)
+	}
+
+	// Adding confirmation narrows the rule
+	changes = diffConfigs(newCfg, oldCfg)
+	if len(changes) != 1 || changes[0].Broadening {
+		t.Errorf("changes = %+v, want one non-broadening action change", changes)
+	}
+}
+
+func TestDiffRestrictionFields(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name       string
+		kind       string
+		old, new   config.Rule
+		broadening bool
+	}{
+		{
+			name:       "allow content restriction removed",
+			kind:       "allow",
+			old:        config.Rule{Tool: "Edit", PathPatterns: []string{`\.go$`}, ContentPatterns: []string{"TODO"}},
+			new:        config.Rule{Tool: "Edit", PathPatterns: []string{`\.go$`}},
+			broadening: true,
+		},
+		{
+			name:       "allow content alternative added",
+			kind:       "allow",
+			old:        config.Rule{Tool: "Edit", PathPatterns: []string{`\.go$`}, ContentPatterns: []string{"TODO"}},
+			new:        config.Rule{Tool: "Edit", PathPatterns: []string{`\.go$`}, ContentPatterns: []string{"TODO", "FIXME"}},
+			broadening: true,
+		},
+		{
+			name:       "allow content restriction added",
+			kind:       "allow",
+			old:        config.Rule{Tool: "Edit", PathPatterns: []string{`\.go$`}},
+			new:        config.Rule{Tool: "Edit", PathPatterns: []string{`\.go$`}, ContentPatterns: []string{"TODO"}},
+			broadening: false,
+		},
+		{
+			name:       "deny content restriction added",
+			kind:       "deny",
+			old:        config.Rule{Tool: "Write", PathPatterns: []string{`\.env$`}},
+			new:        config.Rule{Tool: "Write", PathPatterns: []string{`\.env$`}, ContentPatterns: []string{"SECRET"}},
+			broadening: true,
+		},
+		{
+			name:       "deny content alternative removed",
+			kind:       "deny",
+			old:        config.Rule{Tool: "Write", PathPatterns: []string{`.*`}, ContentPatterns: []string{"AKIA", "BEGIN PRIVATE KEY"}},
+			new:        config.Rule{Tool: "Write", PathPatterns: []string{`.*`}, ContentPatterns: []string{"AKIA"}},
+			broadening: true,
+		},
+		{
+			name:       "allow denied_env removed",
+			kind:       "allow",
+			old:        config.Rule{Tool: "Bash", Commands: []string{"make"}, DeniedEnv: []string{"^LD_"}},
+			new:        config.Rule{Tool: "Bash", Commands: []string{"make"}},
+			broadening: true,
+		},
+		{
+			name:       "allow denied_env added",
+			kind:       "allow",
+			old:        config.Rule{Tool: "Bash", Commands: []string{"make"}},
+			new:        config.Rule{Tool: "Bash", Commands: []string{"make"}, DeniedEnv: []string{"^LD_"}},
+			broadening: false,
+		},
+		{
+			name:       "deny narrowed to denied_env",
+			kind:       "deny",
+			old:        config.Rule{Tool: "Bash", Commands: []string{"make"}},
+			new:        config.Rule{Tool: "Bash", Commands: []string{"make"}, DeniedEnv: []string{"^LD_"}},
+			broadening: true,
+		},
+		{
+			name:       "deny denied_env alternative removed",
+			kind:       "deny",
+			old:        config.Rule{Tool: "Bash", DeniedEnv: []string{"^LD_", "^GIT_SSH"}},
+			new:        config.Rule{Tool: "Bash", DeniedEnv: []string{"^LD_"}},
+			broadening: true,
+		},
+		{
+			name:       "deny denied_env alternative added",
+			kind:       "deny",
+			old:        config.Rule{Tool: "Bash", DeniedEnv: []string{"^LD_"}},
+			new:        config.Rule{Tool: "Bash", DeniedEnv: []string{"^LD_", "^GIT_SSH"}},
+			broadening: false,
+		},
+		{
+			name:       "allow dir_patterns removed",
+			kind:       "allow",
+			old:        config.Rule{Tool: "Bash", Commands: []string{"git *"}, DirPatterns: []string{"^/home/me/work/"}},
+			new:        config.Rule{Tool: "Bash", Commands: []string{"git *"}},
+			broadening: true,
+		},
+		{
+			name:       "allow dir_patterns added",
+			kind:       "allow",
+			old:        config.Rule{Tool: "Bash", Commands: []string{"git *"}},
+			new:        config.Rule{Tool: "Bash", Commands: []string{"git *"}, DirPatterns: []string{"^/home/me/work/"}},
+			broadening: false,
+		},
+		{
+			name:       "deny narrowed to dir_patterns",
+			kind:       "deny",
+			old:        config.Rule{Tool: "Bash", Commands: []string{"git push"}},
+			new:        config.Rule{Tool: "Bash", Commands: []string{"git push"}, DirPatterns: []string{"^/etc(/|$)"}},
+			broadening: true,
+		},
+		{
+			name:       "deny dir_patterns removed",
+			kind:       "deny",
+			old:        config.Rule{Tool: "Bash", Commands: []string{"git push"}, DirPatterns: []string{"^/etc(/|$)"}},
+			new:        config.Rule{Tool: "Bash", Commands: []string{"git push"}},
+			broadening: false,
+		},
+		{
+			name:       "allow Bash script path_patterns removed",
+			kind:       "allow",
+			old:        config.Rule{Tool: "Bash", Commands: []string{"python"}, PathPatterns: []string{`^manage\.py$`}},
+			new:        config.Rule{Tool: "Bash", Commands: []string{"python"}},
+			broadening: true,
+		},
+		{
+			name:       "allow Bash script path_patterns added",
+			kind:       "allow",
+			old:        config.Rule{Tool: "Bash", Commands: []string{"python"}},
+			new:        config.Rule{Tool: "Bash", Commands: []string{"python"}, PathPatterns: []string{`^manage\.py$`}},
+			broadening: false,
+		},
+		{
+			name:       "deny Bash script path_patterns added",
+			kind:       "deny",
+			old:        config.Rule{Tool: "Bash", Commands: []string{"bash"}},
+			new:        config.Rule{Tool: "Bash", Commands: []string{"bash"}, PathPatterns: []string{`deploy\.sh$`}},
+			broadening: true,
+		},
+		{
+			name:       "deny Read path_patterns removed",
+			kind:       "deny",
+			old:        config.Rule{Tool: "Read", PathPatterns: []string{`\.env$`, `\.pem$`}},
+			new:        config.Rule{Tool: "Read", PathPatterns: []string{`\.env$`}},
+			broadening: true,
+		},
+		{
+			name:       "allow in_git_hook unset",
+			kind:       "allow",
+			old:        config.Rule{Tool: "Bash", Commands: []string{"git"}, InGitHook: &yes},
+			new:        config.Rule{Tool: "Bash", Commands: []string{"git"}},
+			broadening: true,
+		},
+		{
+			name:       "allow in_git_hook flipped",
+			kind:       "allow",
+			old:        config.Rule{Tool: "Bash", Commands: []string{"git"}, InGitHook: &yes},
+			new:        config.Rule{Tool: "Bash", Commands: []string{"git"}, InGitHook: &no},
+			broadening: true,
+		},
+		{
+			name:       "allow in_git_hook set",
+			kind:       "allow",
+			old:        config.Rule{Tool: "Bash", Commands: []string{"git"}},
+			new:        config.Rule{Tool: "Bash", Commands: []string{"git"}, InGitHook: &yes},
+			broadening: false,
+		},
+		{
+			name:       "deny in_git_hook set",
+			kind:       "deny",
+			old:        config.Rule{Tool: "Bash", Commands: []string{"git push"}},
+			new:        config.Rule{Tool: "Bash", Commands: []string{"git push"}, InGitHook: &no},
+			broadening: true,
+		},
+		{
+			name:       "deny in_git_hook unset",
+			kind:       "deny",
+			old:        config.Rule{Tool: "Bash", Commands: []string{"git push"}, InGitHook: &no},
+			new:        config.Rule{Tool: "Bash", Commands: []string{"git push"}},
+			broadening: false,
+		},
+		{
+			name:       "deny content restriction removed",
+			kind:       "deny",
+			old:        config.Rule{Tool: "Write", PathPatterns: []string{`\.env$`}, ContentPatterns: []string{"SECRET"}},
+			new:        config.Rule{Tool: "Write", PathPatterns: []string{`\.env$`}},
+			broadening: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.old.Description, tt.new.Description = "Rule", "Rule"
+			oldCfg, newCfg := &config.Config{}, &config.Config{}
+			if tt.kind == "allow" {
+				oldCfg.Allow, newCfg.Allow = []config.Rule{tt.old}, []config.Rule{tt.new}
+			} else {
+				oldCfg.Deny, newCfg.Deny = []config.Rule{tt.old}, []config.Rule{tt.new}
+			}
+			changes := diffConfigs(oldCfg, newCfg)
+			if len(changes) != 1 {
+				t.Fatalf("got %d changes, want 1: %+v", len(changes), changes)
+			}
+			if changes[0].Broadening != tt.broadening {
+				t.Errorf("broadening = %v, want %v (notes: %v)", changes[0].Broadening, tt.broadening, changes[0].Notes)
+			}
+		})
+	}
+}
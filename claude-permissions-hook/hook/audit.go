@@ -0,0 +1,63 @@
+package hook
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// AuditWriter is a destination for audit entries
+type AuditWriter interface {
+	WriteAudit(entry AuditEntry) error
+}
+
+// AuditWriters fans each entry out to several writers. Every writer is
+// tried even if an earlier one fails.
+type AuditWriters []AuditWriter
+
+// WriteAudit writes the entry to each writer, joining their errors
+func (ws AuditWriters) WriteAudit(entry AuditEntry) error {
+	var errs []error
+	for _, w := range ws {
+		if err := w.WriteAudit(entry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FileAuditWriter appends entries to a file as JSON lines, warning once
+// when the file grows past the size set with SetAuditWarnSize
+type FileAuditWriter struct {
+	Path string
+}
+
+// WriteAudit appends the entry to the file
+func (w FileAuditWriter) WriteAudit(entry AuditEntry) error {
+	if err := appendJSONLine(w.Path, entry); err != nil {
+		return err
+	}
+	warnAuditSize(w.Path)
+	return nil
+}
+
+// StreamAuditWriter writes entries as JSON lines to an io.Writer such as
+// os.Stderr
+type StreamAuditWriter struct {
+	out io.Writer
+}
+
+// NewStreamAuditWriter returns an AuditWriter that writes to out
+func NewStreamAuditWriter(out io.Writer) *StreamAuditWriter {
+	return &StreamAuditWriter{out: out}
+}
+
+// WriteAudit writes the entry as a line of JSON
+func (w *StreamAuditWriter) WriteAudit(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = w.out.Write(append(data, '\n'))
+	return err
+}
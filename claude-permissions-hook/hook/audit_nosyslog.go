@@ -0,0 +1,18 @@
+//go:build windows || plan9
+
+package hook
+
+import "errors"
+
+// SyslogAuditWriter is unavailable on this platform
+type SyslogAuditWriter struct{}
+
+// NewSyslogAuditWriter always fails, as there is no syslog on this platform
+func NewSyslogAuditWriter(tag string) (*SyslogAuditWriter, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+// WriteAudit does nothing
+func (w *SyslogAuditWriter) WriteAudit(entry AuditEntry) error {
+	return nil
+}
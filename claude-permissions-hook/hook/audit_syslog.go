@@ -0,0 +1,32 @@
+//go:build !windows && !plan9
+
+package hook
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// SyslogAuditWriter sends entries as JSON to the local syslog daemon
+type SyslogAuditWriter struct {
+	w *syslog.Writer
+}
+
+// NewSyslogAuditWriter connects to the local syslog daemon, logging under
+// tag with the user facility
+func NewSyslogAuditWriter(tag string) (*SyslogAuditWriter, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogAuditWriter{w: w}, nil
+}
+
+// WriteAudit sends the entry as one syslog message
+func (w *SyslogAuditWriter) WriteAudit(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return w.w.Info(string(data))
+}
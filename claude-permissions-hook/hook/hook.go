@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
+	"strings"
 	"time"
+	"unicode"
 )
 
 // HookInput represents the JSON input from Claude Code
@@ -27,7 +30,8 @@ type HookOutput struct {
 	// Values: "allow", "deny", "ask"
 	PermissionDecision string `json:"permissionDecision,omitempty"`
 
-	// PermissionDecisionReason is shown to Claude when denying
+	// PermissionDecisionReason is shown to Claude when denying, and to the
+	// user when asking
 	PermissionDecisionReason string `json:"permissionDecisionReason,omitempty"`
 
 	// Continue controls whether Claude should continue after the hook
@@ -39,14 +43,28 @@ type HookOutput struct {
 
 // AuditEntry represents a log entry for the audit file
 type AuditEntry struct {
-	Timestamp  string                 `json:"timestamp"`
-	SessionID  string                 `json:"session_id"`
-	ToolName   string                 `json:"tool_name"`
-	ToolInput  map[string]interface{} `json:"tool_input"`
-	Decision   string                 `json:"decision"`
-	Reason     string                 `json:"reason"`
-	RuleMatch  string                 `json:"rule_match,omitempty"`
-	Details    string                 `json:"details,omitempty"`
+	Timestamp string                 `json:"timestamp"`
+	SessionID string                 `json:"session_id"`
+	ToolUseID string                 `json:"tool_use_id,omitempty"`
+	ToolName  string                 `json:"tool_name"`
+	ToolInput map[string]interface{} `json:"tool_input"`
+	Decision  string                 `json:"decision"`
+	Reason    string                 `json:"reason"`
+	RuleMatch string                 `json:"rule_match,omitempty"`
+	Details   string                 `json:"details,omitempty"`
+	DryRun    bool                   `json:"dry_run,omitempty"`
+	DocsURL   string                 `json:"docs_url,omitempty"`
+	Halt      bool                   `json:"halt,omitempty"`
+
+	// Subcommands breaks down compound statements per command
+	Subcommands []AuditSubcommand `json:"subcommands,omitempty"`
+}
+
+// AuditSubcommand records the decision for one command of a compound statement
+type AuditSubcommand struct {
+	Command   string `json:"command"`
+	Signature string `json:"signature"`
+	Decision  string `json:"decision"`
 }
 
 // ReadInput reads and parses hook input from stdin
@@ -55,7 +73,11 @@ func ReadInput() (*HookInput, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read stdin: %w", err)
 	}
+	return ParseInput(data)
+}
 
+// ParseInput parses a single hook input JSON object
+func ParseInput(data []byte) (*HookInput, error) {
 	var input HookInput
 	if err := json.Unmarshal(data, &input); err != nil {
 		return nil, fmt.Errorf("failed to parse input JSON: %w", err)
@@ -64,40 +86,162 @@ func ReadInput() (*HookInput, error) {
 	return &input, nil
 }
 
-// WriteOutput writes the hook output to stdout
-func WriteOutput(output *HookOutput) error {
+// Writer emits hook decisions as JSON lines to an io.Writer
+type Writer struct {
+	out io.Writer
+}
+
+// NewWriter returns a Writer that writes to out
+func NewWriter(out io.Writer) *Writer {
+	return &Writer{out: out}
+}
+
+// defaultWriter backs the package-level Write functions
+var defaultWriter = NewWriter(os.Stdout)
+
+// SetOutput redirects the package-level Write functions to out (os.Stdout
+// by default)
+func SetOutput(out io.Writer) {
+	defaultWriter = NewWriter(out)
+}
+
+// maxReasonLength caps PermissionDecisionReason in characters; 0 disables
+// the cap. The default matches config.DefaultMaxReasonLength.
+var maxReasonLength = 500
+
+// SetMaxReasonLength sets how many characters of a decision reason are
+// written; longer reasons are cut short with an ellipsis. 0 disables the cap.
+func SetMaxReasonLength(n int) {
+	maxReasonLength = n
+}
+
+// SanitizeReason makes a reason safe to show on one line: each line break or
+// tab, with the spaces around it, becomes a single space, other control
+// characters are dropped, and the result is capped at max characters (0 for
+// no cap), ending in an ellipsis when cut short
+func SanitizeReason(reason string, max int) string {
+	out := make([]rune, 0, len(reason))
+	brk := false
+	for _, r := range reason {
+		switch {
+		case r == '\n' || r == '\r' || r == '\t':
+			for len(out) > 0 && out[len(out)-1] == ' ' {
+				out = out[:len(out)-1]
+			}
+			brk = true
+		case unicode.IsControl(r):
+		case r == ' ' && brk:
+		default:
+			if brk && len(out) > 0 {
+				out = append(out, ' ')
+			}
+			brk = false
+			out = append(out, r)
+		}
+	}
+
+	if max > 0 && len(out) > max {
+		return strings.TrimRight(string(out[:max-1]), " ") + "…"
+	}
+	return string(out)
+}
+
+// WriteOutput writes the hook output as a line of JSON, sanitizing and
+// capping its reasons (see SanitizeReason and SetMaxReasonLength)
+func (w *Writer) WriteOutput(output *HookOutput) error {
+	if output.PermissionDecisionReason != "" || output.StopReason != "" {
+		sanitized := *output
+		sanitized.PermissionDecisionReason = SanitizeReason(output.PermissionDecisionReason, maxReasonLength)
+		sanitized.StopReason = SanitizeReason(output.StopReason, maxReasonLength)
+		output = &sanitized
+	}
 	data, err := json.Marshal(output)
 	if err != nil {
 		return fmt.Errorf("failed to marshal output: %w", err)
 	}
 
-	fmt.Println(string(data))
+	if _, err := w.out.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
 	return nil
 }
 
 // WriteAllow outputs an allow decision
-func WriteAllow(reason string) error {
-	return WriteOutput(&HookOutput{
+func (w *Writer) WriteAllow(reason string) error {
+	return w.WriteOutput(&HookOutput{
 		PermissionDecision:       "allow",
 		PermissionDecisionReason: reason,
 	})
 }
 
 // WriteDeny outputs a deny decision
-func WriteDeny(reason string) error {
-	return WriteOutput(&HookOutput{
+func (w *Writer) WriteDeny(reason string) error {
+	return w.WriteOutput(&HookOutput{
 		PermissionDecision:       "deny",
 		PermissionDecisionReason: reason,
 	})
 }
 
-// WritePassthrough outputs an "ask" decision (passthrough to Claude's normal permissions)
-func WritePassthrough() {
-	WriteOutput(&HookOutput{
-		PermissionDecision: "ask",
+// WriteHalt outputs a deny decision that also stops Claude's turn, with the
+// reason as both the deny and the stop reason
+func (w *Writer) WriteHalt(reason string) error {
+	stop := false
+	return w.WriteOutput(&HookOutput{
+		PermissionDecision:       "deny",
+		PermissionDecisionReason: reason,
+		Continue:                 &stop,
+		StopReason:               reason,
 	})
 }
 
+// WriteAsk outputs an "ask" decision with a reason, so Claude prompts the
+// user with it
+func (w *Writer) WriteAsk(reason string) error {
+	return w.WriteOutput(&HookOutput{
+		PermissionDecision:       "ask",
+		PermissionDecisionReason: reason,
+	})
+}
+
+// WritePassthrough outputs an "ask" decision (passthrough to Claude's normal
+// permissions). A non-empty reason tells the user why the hook didn't decide.
+func (w *Writer) WritePassthrough(reason string) error {
+	return w.WriteOutput(&HookOutput{
+		PermissionDecision:       "ask",
+		PermissionDecisionReason: reason,
+	})
+}
+
+// WriteOutput writes the hook output to stdout
+func WriteOutput(output *HookOutput) error {
+	return defaultWriter.WriteOutput(output)
+}
+
+// WriteAllow outputs an allow decision
+func WriteAllow(reason string) error {
+	return defaultWriter.WriteAllow(reason)
+}
+
+// WriteDeny outputs a deny decision
+func WriteDeny(reason string) error {
+	return defaultWriter.WriteDeny(reason)
+}
+
+// WriteHalt outputs a deny decision that also stops Claude's turn
+func WriteHalt(reason string) error {
+	return defaultWriter.WriteHalt(reason)
+}
+
+// WriteAsk outputs an "ask" decision with a reason
+func WriteAsk(reason string) error {
+	return defaultWriter.WriteAsk(reason)
+}
+
+// WritePassthrough outputs an "ask" decision (passthrough to Claude's normal permissions)
+func WritePassthrough(reason string) error {
+	return defaultWriter.WritePassthrough(reason)
+}
+
 // GetBashCommand extracts the command from Bash tool input
 func (h *HookInput) GetBashCommand() string {
 	if cmd, ok := h.ToolInput["command"].(string); ok {
@@ -114,6 +258,66 @@ func (h *HookInput) GetFilePath() string {
 	return ""
 }
 
+// GetSearchPath extracts the directory or file searched by Grep/Glob, or
+// the working directory when the input doesn't name one
+func (h *HookInput) GetSearchPath() string {
+	if path, ok := h.ToolInput["path"].(string); ok && path != "" {
+		return path
+	}
+	return h.Cwd
+}
+
+// GetSearchPatternPath extracts where Glob's pattern or Grep's glob filter
+// reaches: the part before the first wildcard, resolved against the search
+// path, e.g. /secrets for {"pattern": "/secrets/**"}. It returns "" when the
+// pattern has no directory part, as with "*.go".
+func (h *HookInput) GetSearchPatternPath() string {
+	field := "pattern"
+	if h.ToolName == "Grep" {
+		field = "glob" // Grep's pattern is the regex searched for
+	}
+	pattern, _ := h.ToolInput[field].(string)
+	prefix := pattern
+	if i := strings.IndexAny(pattern, "*?[{"); i >= 0 {
+		prefix = pattern[:strings.LastIndex(pattern[:i], "/")+1]
+	}
+	if prefix == "" {
+		return ""
+	}
+	if !path.IsAbs(prefix) && !strings.HasPrefix(prefix, "~") {
+		prefix = path.Join(h.GetSearchPath(), prefix)
+	}
+	return path.Clean(prefix)
+}
+
+// GetFileContent extracts the text written by Write (content) or Edit
+// (old_string and new_string), skipping fields that aren't set
+func (h *HookInput) GetFileContent() []string {
+	var content []string
+	for _, field := range []string{"content", "old_string", "new_string"} {
+		if value, ok := h.ToolInput[field].(string); ok && value != "" {
+			content = append(content, value)
+		}
+	}
+	return content
+}
+
+// GetReadOffset extracts the line offset from Read tool input (0 if not set)
+func (h *HookInput) GetReadOffset() int {
+	if offset, ok := h.ToolInput["offset"].(float64); ok {
+		return int(offset)
+	}
+	return 0
+}
+
+// GetReadLimit extracts the line limit from Read tool input (0 if the whole file is requested)
+func (h *HookInput) GetReadLimit() int {
+	if limit, ok := h.ToolInput["limit"].(float64); ok {
+		return int(limit)
+	}
+	return 0
+}
+
 // GetSkillName extracts the skill name from Skill tool input
 func (h *HookInput) GetSkillName() string {
 	if skill, ok := h.ToolInput["skill"].(string); ok {
@@ -122,28 +326,104 @@ func (h *HookInput) GetSkillName() string {
 	return ""
 }
 
-// WriteAuditEntry writes an entry to the audit file
-func WriteAuditEntry(auditFile string, entry AuditEntry) error {
-	if auditFile == "" {
+// GetURL extracts the URL from WebFetch tool input
+func (h *HookInput) GetURL() string {
+	if u, ok := h.ToolInput["url"].(string); ok {
+		return u
+	}
+	return ""
+}
+
+var (
+	// auditWarnSize is the audit file size in bytes past which a warning is
+	// printed; 0 disables the warning
+	auditWarnSize int64
+	// auditWarned ensures the size warning is printed at most once per process
+	auditWarned bool
+	// warnOutput is where warnings are written
+	warnOutput io.Writer = os.Stderr
+)
+
+// SetAuditWarnSize sets the audit file size in bytes that triggers a one-time
+// warning to rotate or archive the file. 0 disables the warning.
+func SetAuditWarnSize(bytes int64) {
+	auditWarnSize = bytes
+}
+
+// auditWriters are the sinks WriteAuditEntry writes to
+var auditWriters AuditWriters
+
+// SetAuditWriters sets the sinks WriteAuditEntry writes to (none by default)
+func SetAuditWriters(writers ...AuditWriter) {
+	auditWriters = writers
+}
+
+// WriteAuditEntry timestamps an entry and writes it to every configured
+// audit writer (see SetAuditWriters)
+func WriteAuditEntry(entry AuditEntry) error {
+	return WriteAuditEntryTo(auditWriters, entry)
+}
+
+// WriteAuditEntryTo timestamps an entry and writes it to w
+func WriteAuditEntryTo(w AuditWriter, entry AuditEntry) error {
+	entry.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	if err := w.WriteAudit(entry); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
+
+// QueueEntry is a pending ask decision for a human to review out-of-band
+type QueueEntry struct {
+	Timestamp  string   `json:"timestamp"`
+	SessionID  string   `json:"session_id"`
+	ToolName   string   `json:"tool_name"`
+	Command    string   `json:"command"`
+	Signatures []string `json:"signatures,omitempty"`
+	Reason     string   `json:"reason"`
+}
+
+// WriteQueueEntry appends a pending ask decision to the review queue file
+func WriteQueueEntry(queueFile string, entry QueueEntry) error {
+	if queueFile == "" {
 		return nil
 	}
 
 	entry.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	if err := appendJSONLine(queueFile, entry); err != nil {
+		return fmt.Errorf("failed to write queue entry: %w", err)
+	}
+	return nil
+}
 
-	data, err := json.Marshal(entry)
+// appendJSONLine appends v to path as a single line of JSON
+func appendJSONLine(path string, v interface{}) error {
+	data, err := json.Marshal(v)
 	if err != nil {
-		return fmt.Errorf("failed to marshal audit entry: %w", err)
+		return err
 	}
 
-	f, err := os.OpenFile(auditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to open audit file: %w", err)
+		return err
 	}
 	defer f.Close()
 
-	if _, err := f.WriteString(string(data) + "\n"); err != nil {
-		return fmt.Errorf("failed to write audit entry: %w", err)
-	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
 
-	return nil
+// warnAuditSize prints a one-time warning once the audit file grows past the
+// configured size
+func warnAuditSize(auditFile string) {
+	if auditWarnSize <= 0 || auditWarned {
+		return
+	}
+	info, err := os.Stat(auditFile)
+	if err != nil || info.Size() <= auditWarnSize {
+		return
+	}
+	auditWarned = true
+	fmt.Fprintf(warnOutput, "Warning: audit file %s is %.1f MB, consider rotating or archiving it\n",
+		auditFile, float64(info.Size())/(1024*1024))
 }
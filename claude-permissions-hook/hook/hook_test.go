@@ -0,0 +1,206 @@
+package hook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestAuditWarnSizeOnce(t *testing.T) {
+	var out bytes.Buffer
+	warnOutput = &out
+	auditWarned = false
+	SetAuditWarnSize(200)
+	defer func() {
+		SetAuditWarnSize(0)
+		auditWarned = false
+	}()
+
+	auditFile := filepath.Join(t.TempDir(), "audit.json")
+	w := FileAuditWriter{Path: auditFile}
+	entry := AuditEntry{SessionID: "test", ToolName: "Bash", Decision: "allow", Reason: "test"}
+
+	// The first entry stays under the threshold
+	if err := WriteAuditEntryTo(w, entry); err != nil {
+		t.Fatalf("WriteAuditEntryTo() error = %v", err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("unexpected warning below threshold: %q", out.String())
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := WriteAuditEntryTo(w, entry); err != nil {
+			t.Fatalf("WriteAuditEntryTo() error = %v", err)
+		}
+	}
+
+	if got := strings.Count(out.String(), "Warning:"); got != 1 {
+		t.Errorf("got %d warnings, want 1: %q", got, out.String())
+	}
+}
+
+func TestGetFileContent(t *testing.T) {
+	tests := []struct {
+		name  string
+		input map[string]interface{}
+		want  []string
+	}{
+		{"write", map[string]interface{}{"file_path": "a", "content": "hello"}, []string{"hello"}},
+		{"edit", map[string]interface{}{"file_path": "a", "old_string": "x", "new_string": "y"}, []string{"x", "y"}},
+		{"empty old_string", map[string]interface{}{"old_string": "", "new_string": "y"}, []string{"y"}},
+		{"read", map[string]interface{}{"file_path": "a"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HookInput{ToolInput: tt.input}
+			got := h.GetFileContent()
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Errorf("GetFileContent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetSearchPatternPath(t *testing.T) {
+	tests := []struct {
+		tool  string
+		input map[string]interface{}
+		want  string
+	}{
+		{"Glob", map[string]interface{}{"pattern": "/secrets/**"}, "/secrets"},
+		{"Glob", map[string]interface{}{"pattern": "/etc/ssh/*_key"}, "/etc/ssh"},
+		{"Glob", map[string]interface{}{"pattern": "/etc/passwd"}, "/etc/passwd"},
+		{"Glob", map[string]interface{}{"pattern": "src/**/*.go", "path": "/repo"}, "/repo/src"},
+		{"Glob", map[string]interface{}{"pattern": "../secrets/*", "path": "/repo"}, "/secrets"},
+		{"Glob", map[string]interface{}{"pattern": "~/.ssh/*"}, "~/.ssh"},
+		{"Glob", map[string]interface{}{"pattern": "**/*.go"}, ""},
+		{"Glob", map[string]interface{}{}, ""},
+		{"Grep", map[string]interface{}{"pattern": "/secrets/", "glob": "/secrets/**"}, "/secrets"},
+		{"Grep", map[string]interface{}{"pattern": "/secrets/", "glob": "*.go"}, ""},
+	}
+
+	for _, tt := range tests {
+		h := &HookInput{ToolName: tt.tool, ToolInput: tt.input, Cwd: "/cwd"}
+		if got := h.GetSearchPatternPath(); got != tt.want {
+			t.Errorf("%s %v: GetSearchPatternPath() = %q, want %q", tt.tool, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+
+	if err := w.WriteDeny("Block push: Command matched deny rule"); err != nil {
+		t.Fatalf("WriteDeny() error = %v", err)
+	}
+	if err := w.WritePassthrough("No allow rule matched (Command signature: foo bar)"); err != nil {
+		t.Fatalf("WritePassthrough() error = %v", err)
+	}
+	if err := w.WritePassthrough(""); err != nil {
+		t.Fatalf("WritePassthrough() error = %v", err)
+	}
+
+	want := `{"permissionDecision":"deny","permissionDecisionReason":"Block push: Command matched deny rule"}` + "\n" +
+		`{"permissionDecision":"ask","permissionDecisionReason":"No allow rule matched (Command signature: foo bar)"}` + "\n" +
+		`{"permissionDecision":"ask"}` + "\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestSetOutput(t *testing.T) {
+	var out bytes.Buffer
+	SetOutput(&out)
+	defer SetOutput(os.Stdout)
+
+	WriteAllow("ok")
+	if got := out.String(); got != `{"permissionDecision":"allow","permissionDecisionReason":"ok"}`+"\n" {
+		t.Errorf("output = %q", got)
+	}
+}
+
+func TestSanitizeReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		reason string
+		max    int
+		want   string
+	}{
+		{"unchanged", "Git: Command matched allow rule", 500, "Git: Command matched allow rule"},
+		{"newlines", "Block push:\nuse the\r\n  release script", 500, "Block push: use the release script"},
+		{"control characters", "No\x1b[31m rm\x00", 500, "No[31m rm"},
+		{"truncated", "abcdefghij", 5, "abcd…"},
+		{"exact length", "abcde", 5, "abcde"},
+		{"no cap", strings.Repeat("x", 1000), 0, strings.Repeat("x", 1000)},
+		{"multibyte", "ééééé", 3, "éé…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeReason(tt.reason, tt.max); got != tt.want {
+				t.Errorf("SanitizeReason(%q, %d) = %q, want %q", tt.reason, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriterCapsReason(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+
+	if err := w.WriteDeny("Rule: " + strings.Repeat("long reason ", 100)); err != nil {
+		t.Fatalf("WriteDeny() error = %v", err)
+	}
+
+	var output HookOutput
+	if err := json.Unmarshal(out.Bytes(), &output); err != nil {
+		t.Fatalf("parsing output: %v", err)
+	}
+	reason := output.PermissionDecisionReason
+	if n := utf8.RuneCountInString(reason); n != 500 {
+		t.Errorf("reason length = %d, want 500", n)
+	}
+	if !strings.HasSuffix(reason, "…") {
+		t.Errorf("reason = %q, want an ellipsis at the end", reason)
+	}
+}
+
+type failingAuditWriter struct{}
+
+func (failingAuditWriter) WriteAudit(AuditEntry) error {
+	return errors.New("sink unavailable")
+}
+
+func TestWriteAuditEntryFansOut(t *testing.T) {
+	auditFile := filepath.Join(t.TempDir(), "audit.jsonl")
+	var buf bytes.Buffer
+	SetAuditWriters(failingAuditWriter{}, FileAuditWriter{Path: auditFile}, NewStreamAuditWriter(&buf))
+	defer SetAuditWriters()
+
+	entry := AuditEntry{SessionID: "test", ToolName: "Bash", Decision: "deny", Reason: "test"}
+	if err := WriteAuditEntry(entry); err == nil {
+		t.Error("WriteAuditEntry() with a failing sink succeeded, want error")
+	}
+
+	// The failing sink doesn't stop the others
+	data, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatalf("reading audit file: %v", err)
+	}
+	for name, got := range map[string][]byte{"file": data, "buffer": buf.Bytes()} {
+		var written AuditEntry
+		if err := json.Unmarshal(got, &written); err != nil {
+			t.Fatalf("%s sink: parsing entry %q: %v", name, got, err)
+		}
+		if written.Decision != "deny" || written.Timestamp == "" {
+			t.Errorf("%s sink: entry = %+v, want a timestamped deny", name, written)
+		}
+	}
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"bytes"
 	_ "embed"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/asbjornb/claude-hooks/claude-permissions-hook/config"
 	"github.com/asbjornb/claude-hooks/claude-permissions-hook/hook"
@@ -37,6 +41,12 @@ func main() {
 		analyzeCmd(os.Args[2:])
 	case "parse":
 		parseCmd(os.Args[2:])
+	case "diff":
+		diffCmd(os.Args[2:])
+	case "serve":
+		serveCmd(os.Args[2:])
+	case "metrics":
+		metricsCmd(os.Args[2:])
 	case "help", "-h", "--help":
 		printUsage()
 	default:
@@ -53,15 +63,34 @@ Commands:
   init      Initialize a default configuration file
   run       Run as a Claude Code hook (reads JSON from stdin)
   validate  Validate a configuration file
-  analyze   Analyze a session allowlist and suggest patterns
+  analyze   Analyze a session allowlist or transcript and suggest patterns
   parse     Parse a shell command and show its structure
+  diff      Compare two configuration files and show rule changes
+  serve     Evaluate newline-delimited JSON hook inputs from stdin in a loop
+  metrics   Summarize the decisions in an audit log
 
 Usage:
   claude-permissions-hook init [--config <config.toml>]
-  claude-permissions-hook run --config <config.toml>
-  claude-permissions-hook validate --config <config.toml>
-  claude-permissions-hook analyze --allowlist <permissions.json>
-  claude-permissions-hook parse <command>
+  claude-permissions-hook run <config source> [--dry-run [--report <file>]]
+                              [--fail-open|--fail-closed] [--enable-tags <tags>] [--disable-tags <tags>]
+                              [--verbose] [--why-not] [--allow <signature>]... [--deny <signature>]...
+                              [--mode json|exit-code]
+  claude-permissions-hook validate <config source> [--json|--quiet]
+  claude-permissions-hook analyze (--allowlist <permissions.json> | --transcript <session.jsonl> [--last <n>])
+                                  [--format toml|text|json|markdown | --json]
+                                  [--merge-into <config.toml> [--json|--quiet]]
+  claude-permissions-hook parse [--format text|json] [<config source>] <command>
+  claude-permissions-hook diff --old <old.toml> --new <new.toml> [--fail-on-broadening]
+  claude-permissions-hook serve <config source> [--fail-open|--fail-closed]
+                                [--enable-tags <tags>] [--disable-tags <tags>] [--watch [--watch-interval <dur>]]
+  claude-permissions-hook metrics --audit-file <audit.jsonl> [--format text|markdown] [--top <n>]
+                                  [--tool-use-id <id>]
+
+Config source: --config <config.toml>, --config-dir <dir> or --config-inline <toml>.
+With none of these, the path in $CLAUDE_HOOK_CONFIG is used, else the first of
+$XDG_CONFIG_HOME/claude-hooks/config.toml, ~/.config/claude-hooks/config.toml
+and ./.claude-hooks.toml that exists. run's --allow and
+--deny rules are added to the config source, or used alone when none is given.
 
 For more information, see the README.md`)
 }
@@ -109,7 +138,7 @@ func initCmd(args []string) {
 	}
 	fmt.Println("Next step: Run /hooks in Claude Code and add a PreToolUse hook:")
 	fmt.Println()
-	fmt.Println("  Matcher: Bash|Read|Write|Edit|Skill")
+	fmt.Println("  Matcher: Bash|Read|Write|Edit|Grep|Glob|Skill")
 	fmt.Printf("  Command: claude-permissions-hook run --config %s\n", configPath)
 	fmt.Println()
 	fmt.Println("Edit the config to customize which commands are allowed/denied.")
@@ -119,61 +148,192 @@ func initCmd(args []string) {
 func runCmd(args []string) {
 	fs := flag.NewFlagSet("run", flag.ExitOnError)
 	configPath := fs.String("config", "", "Path to TOML configuration file")
+	configDir := fs.String("config-dir", "", "Directory of TOML files to load in lexical order and merge")
+	configInline := fs.String("config-inline", "", "TOML configuration text")
+	dryRun := fs.Bool("dry-run", false, "Evaluate rules but always pass through to Claude")
+	reportPath := fs.String("report", "", "With --dry-run, append would-be decisions to this file instead of the audit file")
+	failOpen := fs.Bool("fail-open", false, "On internal errors, fall back to the normal permission prompt (overrides fail_mode)")
+	failClosed := fs.Bool("fail-closed", false, "On internal errors, deny the tool use (overrides fail_mode)")
+	enableTags := fs.String("enable-tags", "", "Comma-separated tags; only tagged rules with one of these tags apply")
+	disableTags := fs.String("disable-tags", "", "Comma-separated tags; rules with any of these tags are skipped")
+	verbose := fs.Bool("verbose", false, "Write a trace of the matching steps to stderr")
+	whyNot := fs.Bool("why-not", false, "When a Bash command isn't allowed, write the allow rules that came closest to stderr")
+	mode := fs.String("mode", "json", "How to report the decision: json (hook output on stdout) or exit-code")
+	var allowSigs, denySigs stringList
+	fs.Var(&allowSigs, "allow", "Bash command signature to allow, e.g. \"git status\" (repeatable)")
+	fs.Var(&denySigs, "deny", "Bash command signature to deny, e.g. \"git push\" (repeatable)")
 	fs.Parse(args)
 
-	if *configPath == "" {
-		fmt.Fprintln(os.Stderr, "Error: --config is required")
+	// Inline rules stand on their own unless a config source is given
+	cliOnly := len(allowSigs)+len(denySigs) > 0 && *configPath == "" && *configDir == "" && *configInline == ""
+	var path string
+	if !cliOnly {
+		var err error
+		if path, err = resolveConfigPath(*configPath, *configDir, *configInline); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *reportPath != "" && !*dryRun {
+		fmt.Fprintln(os.Stderr, "Error: --report requires --dry-run")
+		os.Exit(1)
+	}
+	if *failOpen && *failClosed {
+		fmt.Fprintln(os.Stderr, "Error: --fail-open and --fail-closed are mutually exclusive")
 		os.Exit(1)
 	}
+	if *mode != "json" && *mode != "exit-code" {
+		fmt.Fprintf(os.Stderr, "Error: unknown mode %q (want json or exit-code)\n", *mode)
+		os.Exit(1)
+	}
+
+	// In exit-code mode the hook output is collected and turned into an
+	// exit code once a decision has been written
+	out := io.Writer(os.Stdout)
+	if *mode == "exit-code" {
+		var buf bytes.Buffer
+		out = &buf
+		hook.SetOutput(&buf)
+		defer func() {
+			code, reason := exitCodeOutput(buf.Bytes())
+			if reason != "" {
+				fmt.Fprintln(os.Stderr, reason)
+			}
+			os.Exit(code)
+		}()
+	}
 
-	cfg, err := config.Load(*configPath)
+	// A CLI override applies even when the config itself fails to load
+	failMode := ""
+	if *failOpen {
+		failMode = config.FailOpen
+	} else if *failClosed {
+		failMode = config.FailClosed
+	}
+
+	cfg := &config.Config{}
+	var err error
+	if !cliOnly {
+		cfg, err = loadConfig(path, *configDir, *configInline)
+	}
+	if err == nil {
+		err = addCLIRules(cfg, allowSigs, denySigs)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
-		os.Exit(1)
+		hook.WriteOutput(failureOutput(failMode, "failed to load config"))
+		return
+	}
+	if failMode == "" {
+		failMode = cfg.FailMode
 	}
+	warnConfig(cfg)
+	hook.SetMaxReasonLength(cfg.Settings.ReasonLimit())
+	configureAudit(cfg.Audit)
 
 	input, err := hook.ReadInput()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
-		os.Exit(1)
+		hook.WriteOutput(failureOutput(failMode, "failed to read hook input"))
+		return
 	}
 
 	m := matcher.New(cfg)
-	var result matcher.MatchResult
-
-	switch input.ToolName {
-	case "Bash":
-		cmd := input.GetBashCommand()
-		if cmd == "" {
-			hook.WritePassthrough()
-			return
+	if *enableTags != "" || *disableTags != "" {
+		m.SetTagFilter(splitList(*enableTags), splitList(*disableTags))
+	}
+	if *verbose {
+		// Trace to stderr only; stdout must stay the hook JSON
+		m.SetTrace(os.Stderr)
+		fmt.Fprintf(os.Stderr, "[trace] tool %q, session %q\n", input.ToolName, input.SessionID)
+	}
+	result, ok := m.Match(input)
+	if !ok {
+		if *verbose {
+			fmt.Fprintf(os.Stderr, "[trace] no rules handle tool %q, passing through\n", input.ToolName)
 		}
-		result = m.MatchBashCommand(cmd)
+		hook.WritePassthrough(noRulesReason(input.ToolName))
+		return
+	}
+	if *verbose {
+		fmt.Fprintf(os.Stderr, "[trace] decision %s: %s\n", result.Decision, decisionReason(result))
+	}
+	if *whyNot && input.ToolName == "Bash" && result.Decision == matcher.DecisionPassthrough {
+		printNearMisses(os.Stderr, input.GetBashCommand(), m.NearMisses(input.GetBashCommand(), maxNearMisses))
+	}
 
-	case "Read", "Write", "Edit":
-		path := input.GetFilePath()
-		if path == "" {
-			hook.WritePassthrough()
-			return
-		}
-		result = m.MatchFilePath(input.ToolName, path)
+	respond(hook.NewWriter(out), cfg, input, result, *dryRun, *reportPath)
 
-	case "Skill":
-		skillName := input.GetSkillName()
-		if skillName == "" {
-			hook.WritePassthrough()
-			return
-		}
-		result = m.MatchSkill(skillName)
+	// Back the JSON deny with an exit code for Claude Code versions that
+	// block on it; exit-code mode already exits with exitDeny. On exit code
+	// 2 Claude Code ignores stdout and shows stderr, so the reason goes there.
+	if code := cfg.Settings.DenyExitCode; code != 0 && *mode == "json" && !*dryRun && result.Decision == matcher.DecisionDeny {
+		fmt.Fprintln(os.Stderr, decisionReason(result))
+		os.Exit(code)
+	}
+}
+
+// maxNearMisses is how many close allow rules --why-not lists per command
+const maxNearMisses = 3
 
+// printNearMisses writes the --why-not explanation for a command that
+// wasn't allowed
+func printNearMisses(w io.Writer, command string, misses []matcher.NearMiss) {
+	if len(misses) == 0 {
+		fmt.Fprintf(w, "[why-not] %s: no allow rule comes close\n", command)
+		return
+	}
+	for _, miss := range misses {
+		fmt.Fprintf(w, "[why-not] %s: allow rule %q: %s\n", miss.Command, miss.Rule, miss.Reason)
+	}
+}
+
+// Exit codes for run --mode exit-code
+const (
+	exitAllow = 0
+	exitAsk   = 1 // A non-blocking error to Claude Code: the normal prompt follows
+	exitDeny  = 2 // A blocking error: the tool call is blocked and stderr shown to Claude
+)
+
+// exitCodeOutput converts the hook output written for a decision into the
+// exit code for run --mode exit-code and the reason to write to stderr.
+// Output that can't be read is treated as ask.
+func exitCodeOutput(data []byte) (int, string) {
+	var output hook.HookOutput
+	if err := json.Unmarshal(data, &output); err != nil {
+		return exitAsk, ""
+	}
+	switch output.PermissionDecision {
+	case "allow":
+		return exitAllow, output.PermissionDecisionReason
+	case "deny":
+		return exitDeny, output.PermissionDecisionReason
 	default:
-		// Passthrough for other tools
-		hook.WritePassthrough()
+		return exitAsk, output.PermissionDecisionReason
+	}
+}
+
+// respond records a match result in the audit, report and queue files as
+// configured and writes the decision to w. With dryRun, the would-be
+// decision is only reported and w gets a passthrough.
+func respond(w *hook.Writer, cfg *config.Config, input *hook.HookInput, result matcher.MatchResult, dryRun bool, reportPath string) {
+	// Dry run: record the would-be decision, but leave the call to Claude
+	if dryRun {
+		reportFile := reportPath
+		if reportFile == "" {
+			reportFile = cfg.Audit.AuditFile
+		}
+		if reportFile != "" {
+			if err := writeReport(reportFile, input, result); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing dry-run report: %v\n", err)
+			}
+		}
+		w.WritePassthrough("")
 		return
 	}
 
 	// Write audit entry if enabled
-	if cfg.Audit.AuditFile != "" {
+	if len(cfg.Audit.SinkNames()) > 0 {
 		shouldAudit := false
 		switch cfg.Audit.AuditLevel {
 		case "all":
@@ -181,37 +341,261 @@ func runCmd(args []string) {
 		case "matched":
 			shouldAudit = result.Decision != matcher.DecisionPassthrough
 		}
+		// Per-rule override takes precedence over the global level
+		if result.Audit != nil {
+			shouldAudit = *result.Audit
+		}
 
 		if shouldAudit {
-			entry := hook.AuditEntry{
-				SessionID: input.SessionID,
-				ToolName:  input.ToolName,
-				ToolInput: input.ToolInput,
-				Decision:  string(result.Decision),
-				Reason:    result.Reason,
-				RuleMatch: result.MatchedRule,
-				Details:   result.Details,
-			}
-			hook.WriteAuditEntry(cfg.Audit.AuditFile, entry)
+			hook.WriteAuditEntry(auditEntry(input, result))
 		}
 	}
 
+	if err := queueAsk(cfg.AskQueueFile, input, result); err != nil {
+		fmt.Fprintf(os.Stderr, "Error queueing ask decision: %v\n", err)
+	}
+
 	// Output decision
 	switch result.Decision {
 	case matcher.DecisionAllow:
-		reason := result.Reason
-		if result.MatchedRule != "" {
-			reason = result.MatchedRule + ": " + reason
-		}
-		hook.WriteAllow(reason)
+		w.WriteAllow(decisionReason(result))
 	case matcher.DecisionDeny:
-		reason := result.Reason
-		if result.MatchedRule != "" {
-			reason = result.MatchedRule + ": " + reason
+		if result.Halt {
+			w.WriteHalt(decisionReason(result))
+		} else {
+			w.WriteDeny(decisionReason(result))
 		}
-		hook.WriteDeny(reason)
+	case matcher.DecisionAsk:
+		w.WriteAsk(decisionReason(result))
 	case matcher.DecisionPassthrough:
-		hook.WritePassthrough()
+		w.WritePassthrough(passthroughReason(result))
+	}
+}
+
+// passthroughReason explains why no rule decided, e.g. "No allow rule
+// matched (Command signature: make build)", for the prompt Claude shows.
+// Like decisionReason, it's prefixed with the setting that asked, if any.
+func passthroughReason(result matcher.MatchResult) string {
+	reason := result.Reason
+	if result.Details != "" {
+		reason += " (" + result.Details + ")"
+	}
+	if result.MatchedRule != "" {
+		reason = result.MatchedRule + ": " + reason
+	}
+	return reason
+}
+
+// noRulesReason is the passthrough reason for a tool no rules handle
+func noRulesReason(tool string) string {
+	return fmt.Sprintf("No rules for tool %s", tool)
+}
+
+// decisionReason formats the reason shown to Claude, prefixed with the
+// matched rule (unless the rule's reason_template wrote the reason) and
+// followed by the rule's policy link if it has one
+func decisionReason(result matcher.MatchResult) string {
+	reason := result.Reason
+	if result.MatchedRule != "" && !result.Templated {
+		reason = result.MatchedRule + ": " + reason
+	}
+	if result.DocsURL != "" {
+		reason += " (see " + result.DocsURL + ")"
+	}
+	if result.Suggestion != "" {
+		reason += ". Suggestion: " + result.Suggestion
+	}
+	return reason
+}
+
+// failureOutput returns the decision to emit when the hook can't evaluate a
+// tool use. Fail-closed denies; anything else falls back to the normal prompt.
+func failureOutput(failMode, reason string) *hook.HookOutput {
+	if failMode == config.FailClosed {
+		return &hook.HookOutput{
+			PermissionDecision:       "deny",
+			PermissionDecisionReason: "claude-permissions-hook: " + reason,
+		}
+	}
+	return &hook.HookOutput{
+		PermissionDecision: "ask",
+	}
+}
+
+// auditEntry builds an audit log entry for a match result
+func auditEntry(input *hook.HookInput, result matcher.MatchResult) hook.AuditEntry {
+	entry := hook.AuditEntry{
+		SessionID: input.SessionID,
+		ToolUseID: input.ToolUseID,
+		ToolName:  input.ToolName,
+		ToolInput: input.ToolInput,
+		Decision:  string(result.Decision),
+		Reason:    result.Reason,
+		RuleMatch: result.MatchedRule,
+		Details:   result.Details,
+		DocsURL:   result.DocsURL,
+		Halt:      result.Halt,
+	}
+	for _, sub := range result.Subcommands {
+		entry.Subcommands = append(entry.Subcommands, hook.AuditSubcommand{
+			Command:   sub.Command,
+			Signature: sub.Signature,
+			Decision:  string(sub.Decision),
+		})
+	}
+	return entry
+}
+
+// queueAsk appends ask decisions, from ask rules or no rule allowing, to
+// the review queue file for out-of-band review; other decisions aren't queued
+func queueAsk(queueFile string, input *hook.HookInput, result matcher.MatchResult) error {
+	if !asks(result.Decision) {
+		return nil
+	}
+	return hook.WriteQueueEntry(queueFile, queueEntry(input, result))
+}
+
+// asks reports whether a decision prompts the user
+func asks(d matcher.Decision) bool {
+	return d == matcher.DecisionPassthrough || d == matcher.DecisionAsk
+}
+
+// queueEntry builds a review queue entry for an ask decision. Signatures
+// list the commands that lacked an allow rule or matched an ask rule.
+func queueEntry(input *hook.HookInput, result matcher.MatchResult) hook.QueueEntry {
+	entry := hook.QueueEntry{
+		SessionID: input.SessionID,
+		ToolName:  input.ToolName,
+		Reason:    result.Reason,
+	}
+
+	switch input.ToolName {
+	case "Bash", "PowerShell":
+		entry.Command = input.GetBashCommand()
+	case "Read", "Write", "Edit":
+		entry.Command = input.GetFilePath()
+	case "Grep", "Glob":
+		entry.Command = input.GetSearchPath()
+	case "Skill":
+		entry.Command = input.GetSkillName()
+	}
+
+	if len(result.Subcommands) > 0 {
+		for _, sub := range result.Subcommands {
+			if asks(sub.Decision) {
+				entry.Signatures = append(entry.Signatures, sub.Signature)
+			}
+		}
+		return entry
+	}
+	switch input.ToolName {
+	case "Bash":
+		if stmt, err := parser.ParseShellCommand(entry.Command); err == nil && len(stmt.Commands) == 1 {
+			entry.Signatures = []string{parser.CommandSignature(stmt.Commands[0])}
+		}
+	case "PowerShell":
+		if stmt, err := parser.ParsePowerShellCommand(entry.Command); err == nil && len(stmt.Commands) == 1 {
+			entry.Signatures = []string{parser.PowerShellSignature(stmt.Commands[0])}
+		}
+	}
+	return entry
+}
+
+// writeReport appends a dry-run entry with the would-be decision
+func writeReport(reportFile string, input *hook.HookInput, result matcher.MatchResult) error {
+	entry := auditEntry(input, result)
+	entry.DryRun = true
+	return hook.WriteAuditEntryTo(hook.FileAuditWriter{Path: reportFile}, entry)
+}
+
+// configureAudit points the hook's audit writers at the config's sinks. A
+// sink that can't be opened is reported on stderr and skipped, so the
+// others still receive entries.
+func configureAudit(audit config.AuditConfig) {
+	hook.SetAuditWarnSize(int64(audit.AuditWarnSizeMB) * 1024 * 1024)
+
+	var writers []hook.AuditWriter
+	for _, sink := range audit.SinkNames() {
+		switch sink {
+		case config.SinkFile:
+			writers = append(writers, hook.FileAuditWriter{Path: audit.AuditFile})
+		case config.SinkStderr:
+			writers = append(writers, hook.NewStreamAuditWriter(os.Stderr))
+		case config.SinkSyslog:
+			w, err := hook.NewSyslogAuditWriter("claude-permissions-hook")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error opening syslog audit sink: %v\n", err)
+				continue
+			}
+			writers = append(writers, w)
+		}
+	}
+	hook.SetAuditWriters(writers...)
+}
+
+// configEnvVar names the environment variable holding a config file path,
+// used when no config flag is given
+const configEnvVar = "CLAUDE_HOOK_CONFIG"
+
+// resolveConfigPath checks that exactly one config source is given and
+// returns the path. With none, it falls back to $CLAUDE_HOOK_CONFIG, then to
+// the first file found in configSearchPaths.
+func resolveConfigPath(path, dir, inline string) (string, error) {
+	if path == "" && dir == "" && inline == "" {
+		path = os.Getenv(configEnvVar)
+		if path == "" {
+			path = findConfig()
+		}
+	}
+	sources := 0
+	for _, s := range []string{path, dir, inline} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return "", fmt.Errorf("one of --config, --config-dir or --config-inline (or %s) is required; no config found in %s",
+			configEnvVar, strings.Join(configSearchPaths(), ", "))
+	}
+	return path, nil
+}
+
+// configSearchPaths returns the config files tried, in order, when no config
+// source is given. The user's config comes first: a .claude-hooks.toml in
+// the working directory may come from a cloned repo, or be written by Claude
+// itself, and must not override the rules it runs under.
+func configSearchPaths() []string {
+	var paths []string
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		paths = append(paths, filepath.Join(xdg, "claude-hooks", "config.toml"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".config", "claude-hooks", "config.toml"))
+	}
+	return append(paths, ".claude-hooks.toml")
+}
+
+// findConfig returns the first of configSearchPaths that exists, or "" if
+// none does
+func findConfig() string {
+	for _, path := range configSearchPaths() {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// loadConfig parses inline TOML, merges a directory of config files, or
+// loads a single one
+func loadConfig(path, dir, inline string) (*config.Config, error) {
+	switch {
+	case inline != "":
+		return config.ParseString(inline)
+	case dir != "":
+		return config.LoadDir(dir)
+	default:
+		return config.Load(path)
 	}
 }
 
@@ -219,26 +603,111 @@ func runCmd(args []string) {
 func validateCmd(args []string) {
 	fs := flag.NewFlagSet("validate", flag.ExitOnError)
 	configPath := fs.String("config", "", "Path to TOML configuration file")
+	configDir := fs.String("config-dir", "", "Directory of TOML files to load in lexical order and merge")
+	configInline := fs.String("config-inline", "", "TOML configuration text")
+	jsonOut := fs.Bool("json", false, "Print the result as JSON")
+	quiet := fs.Bool("quiet", false, "Print nothing but errors; the exit code tells whether the config is valid")
 	fs.Parse(args)
 
-	if *configPath == "" {
-		fmt.Fprintln(os.Stderr, "Error: --config is required")
+	if *jsonOut && *quiet {
+		fmt.Fprintln(os.Stderr, "Error: --json and --quiet are mutually exclusive")
+		os.Exit(1)
+	}
+	path, err := resolveConfigPath(*configPath, *configDir, *configInline)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	cfg, err := config.Load(*configPath)
+	cfg, err := loadConfig(path, *configDir, *configInline)
+	switch {
+	case *jsonOut:
+		if err := printJSONValidation(os.Stdout, newValidation(cfg, err)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing JSON: %v\n", err)
+			os.Exit(1)
+		}
+	case *quiet:
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Configuration invalid: %v\n", err)
+		}
+	default:
+		printValidation(cfg, err)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "❌ Configuration invalid: %v\n", err)
 		os.Exit(1)
 	}
+}
+
+// Validation is the validate --json output
+type Validation struct {
+	Valid         bool     `json:"valid"`
+	Error         string   `json:"error,omitempty"`
+	AllowRules    int      `json:"allow_rules"`
+	DenyRules     int      `json:"deny_rules"`
+	DisabledRules int      `json:"disabled_rules"`
+	Warnings      []string `json:"warnings"`
+}
+
+// newValidation summarizes a config load for validate --json. A config
+// that failed to load has no rules or warnings.
+func newValidation(cfg *config.Config, loadErr error) Validation {
+	if loadErr != nil {
+		return Validation{Error: loadErr.Error(), Warnings: []string{}}
+	}
+	v := Validation{
+		Valid:         true,
+		AllowRules:    len(cfg.Allow),
+		DenyRules:     len(cfg.Deny),
+		DisabledRules: cfg.DisabledRules(),
+		Warnings:      cfg.Warnings,
+	}
+	if v.Warnings == nil {
+		v.Warnings = []string{}
+	}
+	return v
+}
+
+func printJSONValidation(w io.Writer, v Validation) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printValidation prints the validate summary for people
+func printValidation(cfg *config.Config, loadErr error) {
+	if loadErr != nil {
+		fmt.Fprintf(os.Stderr, "❌ Configuration invalid: %v\n", loadErr)
+		return
+	}
 
 	fmt.Println("✅ Configuration valid")
+	for _, warning := range cfg.Warnings {
+		fmt.Printf("   ⚠️  %s\n", warning)
+	}
 	fmt.Printf("   Allow rules: %d\n", len(cfg.Allow))
 	fmt.Printf("   Deny rules: %d\n", len(cfg.Deny))
+	if n := cfg.DisabledRules(); n > 0 {
+		fmt.Printf("   Disabled rules: %d\n", n)
+	}
 	fmt.Printf("   Audit level: %s\n", cfg.Audit.AuditLevel)
+	fmt.Printf("   Fail mode: %s\n", cfg.FailMode)
+	if cfg.Settings.DenyListMode {
+		fmt.Println("   ⚠️  Deny-list mode: anything not denied is allowed")
+	}
+	if len(cfg.Defaults) > 0 {
+		tools := make([]string, 0, len(cfg.Defaults))
+		for tool := range cfg.Defaults {
+			tools = append(tools, tool+"="+cfg.Defaults[tool])
+		}
+		sort.Strings(tools)
+		fmt.Printf("   Defaults: %s\n", strings.Join(tools, ", "))
+	}
 	if cfg.Audit.AuditFile != "" {
 		fmt.Printf("   Audit file: %s\n", cfg.Audit.AuditFile)
 	}
+	if len(cfg.Audit.Sinks) > 0 {
+		fmt.Printf("   Audit sinks: %s\n", strings.Join(cfg.Audit.Sinks, ", "))
+	}
 }
 
 // SessionPermissions represents the JSON format from Claude Code session allowlists
@@ -249,130 +718,517 @@ type SessionPermissions struct {
 	} `json:"permissions"`
 }
 
-// CommandGroup represents a group of similar commands
+// CommandGroup represents a group of similar commands, or of file paths for
+// Read/Write/Edit where Pattern is a path regex
 type CommandGroup struct {
-	Pattern  string
-	Examples []string
-	Count    int
+	Tool     string   `json:"tool"`
+	Pattern  string   `json:"pattern"`
+	Examples []string `json:"examples"`
+	Count    int      `json:"count"`
 }
 
 // analyzeCmd analyzes a session allowlist and suggests patterns
 func analyzeCmd(args []string) {
 	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
 	allowlistPath := fs.String("allowlist", "", "Path to session permissions JSON file")
-	outputFormat := fs.String("format", "toml", "Output format: toml or text")
+	transcriptPath := fs.String("transcript", "", "Path to a session transcript (JSONL) to analyze instead of an allowlist")
+	last := fs.Int("last", 0, "With --transcript, analyze only the last N tool uses (0 = all)")
+	outputFormat := fs.String("format", "toml", "Output format: toml, text, json or markdown")
+	mergeInto := fs.String("merge-into", "", "Append suggested rules not already covered to this TOML config")
+	maxExamples := fs.Int("max-examples", 3, "Examples to show per pattern in text and TOML output (0 = all)")
+	jsonOut := fs.Bool("json", false, "Print JSON; same as --format json, or with --merge-into a JSON summary")
+	quiet := fs.Bool("quiet", false, "With --merge-into, print nothing but errors")
 	fs.Parse(args)
 
-	if *allowlistPath == "" {
-		fmt.Fprintln(os.Stderr, "Error: --allowlist is required")
+	if *jsonOut {
+		formatSet := false
+		fs.Visit(func(f *flag.Flag) { formatSet = formatSet || f.Name == "format" })
+		if formatSet && *outputFormat != "json" {
+			fmt.Fprintln(os.Stderr, "Error: --json conflicts with --format "+*outputFormat)
+			os.Exit(1)
+		}
+		*outputFormat = "json"
+	}
+	if *quiet && (*mergeInto == "" || *jsonOut) {
+		fmt.Fprintln(os.Stderr, "Error: --quiet requires --merge-into and excludes --json")
 		os.Exit(1)
 	}
 
-	data, err := os.ReadFile(*allowlistPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading allowlist: %v\n", err)
+	if (*allowlistPath == "") == (*transcriptPath == "") {
+		fmt.Fprintln(os.Stderr, "Error: exactly one of --allowlist and --transcript is required")
 		os.Exit(1)
 	}
-
-	var perms SessionPermissions
-	if err := json.Unmarshal(data, &perms); err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing allowlist: %v\n", err)
+	if *last < 0 {
+		fmt.Fprintln(os.Stderr, "Error: --last must not be negative")
 		os.Exit(1)
 	}
 
-	groups := analyzePermissions(perms.Permissions.Allow)
+	// Relative permission paths are relative to where Claude runs
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 
-	if *outputFormat == "toml" {
-		printTOMLSuggestions(groups)
+	var groups []CommandGroup
+	if *transcriptPath != "" {
+		f, err := os.Open(*transcriptPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading transcript: %v\n", err)
+			os.Exit(1)
+		}
+		uses, skipped, err := readTranscript(f, *last)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading transcript: %v\n", err)
+			os.Exit(1)
+		}
+		if skipped > 0 && !*quiet {
+			fmt.Fprintf(os.Stderr, "Skipped %d transcript line(s) that aren't JSON\n", skipped)
+		}
+		groups = groupToolUses(uses, cwd)
 	} else {
-		printTextSuggestions(groups)
+		data, err := os.ReadFile(*allowlistPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading allowlist: %v\n", err)
+			os.Exit(1)
+		}
+
+		var perms SessionPermissions
+		if err := json.Unmarshal(data, &perms); err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing allowlist: %v\n", err)
+			os.Exit(1)
+		}
+
+		groups = analyzePermissions(perms.Permissions.Allow, cwd)
+	}
+
+	if *mergeInto != "" {
+		added, covered, err := mergeSuggestions(*mergeInto, groups, *maxExamples)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error merging into config: %v\n", err)
+			os.Exit(1)
+		}
+		switch {
+		case *jsonOut:
+			if err := printJSONMerge(os.Stdout, MergeSummary{Config: *mergeInto, Added: added, Covered: covered}); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing JSON: %v\n", err)
+				os.Exit(1)
+			}
+		case !*quiet:
+			fmt.Printf("Added %d new signature(s) to %s (%d already covered)\n", added, *mergeInto, covered)
+		}
+		return
+	}
+
+	switch *outputFormat {
+	case "toml":
+		printTOMLSuggestions(os.Stdout, groups, *maxExamples)
+	case "json":
+		if err := printJSONSuggestions(os.Stdout, groups); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing JSON: %v\n", err)
+			os.Exit(1)
+		}
+	case "markdown":
+		printMarkdownSuggestions(os.Stdout, groups, *maxExamples, time.Now().UTC())
+	default:
+		printTextSuggestions(groups, *maxExamples)
 	}
 }
 
 // parseCmd parses a shell command and shows its structure
 func parseCmd(args []string) {
-	if len(args) == 0 {
+	fs := flag.NewFlagSet("parse", flag.ExitOnError)
+	format := fs.String("format", "text", "Output format: text or json")
+	configPath := fs.String("config", "", "Also match the command against this TOML configuration")
+	configDir := fs.String("config-dir", "", "Also match the command against the merged TOML files in this directory")
+	configInline := fs.String("config-inline", "", "Also match the command against this TOML configuration text")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
 		fmt.Fprintln(os.Stderr, "Error: command required")
 		os.Exit(1)
 	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Error: unknown format %q (want text or json)\n", *format)
+		os.Exit(1)
+	}
+
+	// With a config, show the decision too. Without one, parse only shows
+	// structure, so it doesn't search for a default config. The matcher is
+	// built before parsing, since it sets up the config's runners, aliases
+	// and remote commands for the parser.
+	cmd := strings.Join(fs.Args(), " ")
+	var m *matcher.Matcher
+	if *configPath != "" || *configDir != "" || *configInline != "" {
+		path, err := resolveConfigPath(*configPath, *configDir, *configInline)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		cfg, err := loadConfig(path, *configDir, *configInline)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+			os.Exit(1)
+		}
+		m = matcher.New(cfg)
+	}
 
-	cmd := strings.Join(args, " ")
 	stmt, err := parser.ParseShellCommand(cmd)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing command: %v\n", err)
 		os.Exit(1)
 	}
+	var result *matcher.MatchResult
+	if m != nil {
+		r := matchParsed(m, cmd)
+		result = &r
+	}
+
+	if *format == "json" {
+		if err := printJSONStatement(os.Stdout, stmt, result); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing JSON: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	printTextStatement(os.Stdout, cmd, stmt)
+	if result != nil {
+		printDecision(os.Stdout, m, cmd, *result)
+	}
+}
 
-	fmt.Printf("Command: %s\n", cmd)
-	fmt.Printf("Parsed %d command(s):\n", len(stmt.Commands))
+// printTextStatement writes the parse text output for a statement
+func printTextStatement(w io.Writer, cmd string, stmt *parser.ShellStatement) {
+	fmt.Fprintf(w, "Command: %s\n", cmd)
+	fmt.Fprintf(w, "Parsed %d command(s):\n", len(stmt.Commands))
 
 	for i, c := range stmt.Commands {
-		fmt.Printf("\n  [%d] %s\n", i+1, c.Raw)
-		fmt.Printf("      Name: %s\n", c.Name)
-		fmt.Printf("      Args: %v\n", c.Args)
-		fmt.Printf("      Signature: %s\n", parser.CommandSignature(c))
+		fmt.Fprintf(w, "\n  [%d] %s\n", i+1, c.Raw)
+		fmt.Fprintf(w, "      Name: %s\n", c.Name)
+		fmt.Fprintf(w, "      Args: %v\n", c.Args)
+		fmt.Fprintf(w, "      Signature: %s\n", parser.CommandSignature(c))
+		if len(c.Env) > 0 {
+			fmt.Fprintf(w, "      Env: %v\n", c.Env)
+		}
+		if c.Dir != "" {
+			fmt.Fprintf(w, "      Dir: %s\n", c.Dir)
+		}
+		if len(c.GitConfig) > 0 {
+			fmt.Fprintf(w, "      Git config: %v\n", c.GitConfig)
+		}
+		if c.Script != "" {
+			fmt.Fprintf(w, "      Script: %s\n", c.Script)
+		}
 		if c.Operator != "" {
-			fmt.Printf("      Next operator: %s\n", c.Operator)
+			fmt.Fprintf(w, "      Next operator: %s\n", c.Operator)
+		}
+		if c.Nested {
+			fmt.Fprintln(w, "      Nested: yes (shell -c, runner script, find -exec, ssh or kubectl exec)")
+		}
+		if c.IsRemote {
+			fmt.Fprintln(w, "      Remote: yes (runs on the ssh host or in a container)")
 		}
 	}
 
 	if stmt.HasPipe {
-		fmt.Println("\n  ⚠️  Contains pipe")
+		fmt.Fprintln(w, "\n  ⚠️  Contains pipe")
 	}
 	if stmt.HasSubshell {
-		fmt.Println("\n  ⚠️  Contains subshell")
+		fmt.Fprintln(w, "\n  ⚠️  Contains subshell")
 	}
 	if stmt.HasBackground {
-		fmt.Println("\n  ⚠️  Contains background job")
+		fmt.Fprintln(w, "\n  ⚠️  Contains background job")
 	}
+	if stmt.HasProcessSubst {
+		fmt.Fprintln(w, "\n  ⚠️  Contains process substitution")
+	}
+	if stmt.HasFindDelete {
+		fmt.Fprintln(w, "\n  ⚠️  Contains find -delete")
+	}
+	if len(stmt.Redirects) > 0 {
+		fmt.Fprintln(w, "\n  Redirects:")
+		for _, rdr := range stmt.Redirects {
+			access := "write"
+			if rdr.Reads() && rdr.Writes() {
+				access = "read/write"
+			} else if rdr.Reads() {
+				access = "read"
+			}
+			fmt.Fprintf(w, "    %s %s (%s)\n", rdr.Op, rdr.Path, access)
+		}
+	}
+}
+
+// matchParsed matches a command given to parse as Bash input from the
+// current directory
+func matchParsed(m *matcher.Matcher, command string) matcher.MatchResult {
+	cwd, _ := os.Getwd()
+	result, _ := m.Match(&hook.HookInput{
+		ToolName:  "Bash",
+		Cwd:       cwd,
+		ToolInput: map[string]interface{}{"command": command},
+	})
+	return result
+}
+
+// printDecision writes the parse text output for a match result: the
+// decision and reason the hook would give, each subcommand's decision, and
+// for a command no rule allowed, the allow rules that came closest
+func printDecision(w io.Writer, m *matcher.Matcher, command string, result matcher.MatchResult) {
+	fmt.Fprintf(w, "\nDecision: %s\n", result.Decision)
+	fmt.Fprintf(w, "  Reason: %s\n", decisionReason(result))
+	if result.Details != "" {
+		fmt.Fprintf(w, "  Details: %s\n", result.Details)
+	}
+	if len(result.Subcommands) > 1 {
+		fmt.Fprintln(w, "  Subcommands:")
+		for _, sub := range result.Subcommands {
+			fmt.Fprintf(w, "    %s (%s): %s\n", sub.Command, sub.Signature, sub.Decision)
+		}
+	}
+	if result.Decision == matcher.DecisionPassthrough {
+		printNearMisses(w, command, m.NearMisses(command, maxNearMisses))
+	}
+}
+
+// parsedCommandJSON is a parsed command with the signature rules match
+type parsedCommandJSON struct {
+	parser.ParsedCommand
+	Signature string `json:"signature"`
+}
+
+// statementJSON is the parse --format json output: the statement, with
+// signatures added and the nested parse error as text
+type statementJSON struct {
+	*parser.ShellStatement
+	Commands    []parsedCommandJSON `json:"commands"`
+	NestedError string              `json:"nested_error,omitempty"`
+	Match       *matchJSON          `json:"match,omitempty"`
+}
+
+// matchJSON is the decision parse --config adds to its JSON output
+type matchJSON struct {
+	Decision    matcher.Decision `json:"decision"`
+	Reason      string           `json:"reason"`
+	MatchedRule string           `json:"matched_rule,omitempty"`
+	Details     string           `json:"details,omitempty"`
+}
+
+// printJSONStatement writes a parsed statement as indented JSON, with the
+// match result if there is one
+func printJSONStatement(w io.Writer, stmt *parser.ShellStatement, result *matcher.MatchResult) error {
+	out := statementJSON{ShellStatement: stmt, Commands: []parsedCommandJSON{}}
+	if result != nil {
+		out.Match = &matchJSON{
+			Decision:    result.Decision,
+			Reason:      decisionReason(*result),
+			MatchedRule: result.MatchedRule,
+			Details:     result.Details,
+		}
+	}
+	for _, c := range stmt.Commands {
+		out.Commands = append(out.Commands, parsedCommandJSON{ParsedCommand: c, Signature: parser.CommandSignature(c)})
+	}
+	if stmt.NestedError != nil {
+		out.NestedError = stmt.NestedError.Error()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	// Keep operators like && and > readable
+	enc.SetEscapeHTML(false)
+	return enc.Encode(out)
 }
 
-// analyzePermissions groups similar permissions and suggests patterns
-func analyzePermissions(perms []string) []CommandGroup {
+// analyzePermissions groups similar permissions and suggests patterns.
+// Relative file paths are resolved against dir.
+func analyzePermissions(perms []string, dir string) []CommandGroup {
 	// Parse Claude Code permission format: "Bash(command:*)" or "Bash(full command)"
 	bashPattern := regexp.MustCompile(`^Bash\((.+?)(?::\*)?\)$`)
+	// File tools take a path or glob: "Read(/home/me/project/src/*.go)"
+	filePattern := regexp.MustCompile(`^(Read|Write|Edit)\((.+)\)$`)
 
-	commandSigs := make(map[string][]string)
-
+	var uses []ToolUse
 	for _, perm := range perms {
-		matches := bashPattern.FindStringSubmatch(perm)
-		if matches == nil {
+		if matches := filePattern.FindStringSubmatch(perm); matches != nil {
+			uses = append(uses, ToolUse{Tool: matches[1], Value: matches[2]})
 			continue
 		}
+		if matches := bashPattern.FindStringSubmatch(perm); matches != nil {
+			uses = append(uses, ToolUse{Tool: "Bash", Value: matches[1]})
+		}
+	}
+	return groupToolUses(uses, dir)
+}
+
+// ToolUse is one use of a tool to analyze: a Bash command, or the path or
+// glob given to a file tool
+type ToolUse struct {
+	Tool  string
+	Value string
+}
 
-		cmd := matches[1]
+// groupToolUses groups Bash commands by signature and file paths by
+// pattern (see pathGroupPattern), most used first
+func groupToolUses(uses []ToolUse, dir string) []CommandGroup {
+	type groupKey struct{ tool, pattern string }
+	examplesByKey := make(map[groupKey][]string)
+
+	for _, use := range uses {
+		if use.Tool != "Bash" {
+			key := groupKey{use.Tool, pathGroupPattern(use.Value, dir)}
+			examplesByKey[key] = append(examplesByKey[key], use.Value)
+			continue
+		}
 
 		// Parse the command to get its signature
-		stmt, err := parser.ParseShellCommand(cmd)
+		stmt, err := parser.ParseShellCommand(use.Value)
 		if err != nil {
 			continue
 		}
 
+		example := oneLine(use.Value)
 		for _, c := range stmt.Commands {
-			sig := parser.CommandSignature(c)
-			commandSigs[sig] = append(commandSigs[sig], cmd)
+			key := groupKey{"Bash", parser.CommandSignature(c)}
+			examplesByKey[key] = append(examplesByKey[key], example)
 		}
 	}
 
 	// Convert to groups
 	var groups []CommandGroup
-	for sig, examples := range commandSigs {
+	for key, examples := range examplesByKey {
 		groups = append(groups, CommandGroup{
-			Pattern:  sig,
-			Examples: unique(examples),
+			Tool:     key.tool,
+			Pattern:  key.pattern,
+			Examples: sortedUnique(examples),
 			Count:    len(examples),
 		})
 	}
 
-	// Sort by count descending
+	// Sort by count descending, then by tool and pattern so the output is
+	// stable across runs
 	sort.Slice(groups, func(i, j int) bool {
-		return groups[i].Count > groups[j].Count
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		if groups[i].Tool != groups[j].Tool {
+			return groups[i].Tool < groups[j].Tool
+		}
+		return groups[i].Pattern < groups[j].Pattern
 	})
 
 	return groups
 }
 
-func unique(strs []string) []string {
+// oneLine joins the non-blank lines of a multi-line command with "; " so it
+// fits on one line of output, or in a TOML comment, as an example
+func oneLine(command string) string {
+	var lines []string
+	for _, line := range strings.Split(command, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "; ")
+}
+
+// pathGroupPattern turns a file permission path into an anchored regex. A
+// literal path matches only that file, while a glob covers its directory
+// and extension, so "src/*.go" suggests "^/project/src/.*\.go$" when dir is
+// /project. Claude's "//" prefix for absolute paths is reduced to "/", "~/"
+// is the home directory, and other relative paths are resolved against dir.
+func pathGroupPattern(path, dir string) string {
+	switch {
+	case strings.HasPrefix(path, "//"):
+		path = path[1:]
+	case strings.HasPrefix(path, "~/"):
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.ToSlash(home) + path[1:]
+		}
+	case !strings.HasPrefix(path, "/"):
+		path = strings.TrimSuffix(filepath.ToSlash(dir), "/") + "/" + strings.TrimPrefix(path, "./")
+	}
+	if !strings.ContainsAny(path, "*?[") {
+		return "^" + regexp.QuoteMeta(path) + "$"
+	}
+
+	// The directory is everything before the first segment with a glob
+	segments := strings.Split(path, "/")
+	dirEnd := len(segments) - 1
+	for i, segment := range segments[:dirEnd] {
+		if strings.ContainsAny(segment, "*?[") {
+			dirEnd = i
+			break
+		}
+	}
+	globDir := strings.Join(segments[:dirEnd], "/")
+
+	ext := filepath.Ext(segments[len(segments)-1])
+	if strings.ContainsAny(ext, "*?[") {
+		ext = ""
+	}
+
+	return "^" + regexp.QuoteMeta(globDir+"/") + ".*" + regexp.QuoteMeta(ext) + "$"
+}
+
+// warnConfig writes the config's size warnings to stderr, since stdout
+// carries the hook output
+func warnConfig(cfg *config.Config) {
+	for _, warning := range cfg.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: config %s\n", warning)
+	}
+}
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// addCLIRules appends a Bash rule for each --allow and --deny signature to
+// cfg and recompiles it. Deny rules are checked first as usual, so a
+// signature given to both is denied.
+func addCLIRules(cfg *config.Config, allow, deny []string) error {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil
+	}
+	for _, sig := range allow {
+		cfg.Allow = append(cfg.Allow, config.Rule{
+			Tool:        "Bash",
+			Commands:    []string{sig},
+			Description: "--allow " + sig,
+		})
+	}
+	for _, sig := range deny {
+		cfg.Deny = append(cfg.Deny, config.Rule{
+			Tool:        "Bash",
+			Commands:    []string{sig},
+			Description: "--deny " + sig,
+		})
+	}
+	return config.Compile(cfg)
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var result []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+// sortedUnique returns the distinct strings in sorted order
+func sortedUnique(strs []string) []string {
 	seen := make(map[string]bool)
 	var result []string
 	for _, s := range strs {
@@ -381,24 +1237,109 @@ func unique(strs []string) []string {
 			result = append(result, s)
 		}
 	}
+	sort.Strings(result)
 	return result
 }
 
-func printTOMLSuggestions(groups []CommandGroup) {
-	fmt.Println("# Suggested configuration based on session allowlist")
-	fmt.Println("# Review and customize before using")
-	fmt.Println()
+// limitExamples returns at most max examples (all of them when max is 0)
+// and how many were left out
+func limitExamples(examples []string, max int) ([]string, int) {
+	if max <= 0 || len(examples) <= max {
+		return examples, 0
+	}
+	return examples[:max], len(examples) - max
+}
+
+// formatExamples renders examples for display, e.g. "[a b] ... (2 more)"
+func formatExamples(examples []string, max int) string {
+	shown, more := limitExamples(examples, max)
+	if more > 0 {
+		return fmt.Sprintf("%v ... (%d more)", shown, more)
+	}
+	return fmt.Sprintf("%v", shown)
+}
+
+// mergeSuggestions appends allow rules for the suggested signatures that the
+// config at path doesn't already cover, leaving the existing text (and its
+// comments) untouched. It returns the number of signatures added and skipped.
+func mergeSuggestions(path string, groups []CommandGroup, maxExamples int) (added, covered int, err error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return 0, 0, err
+	}
 
+	existing := make(map[string][]string)
+	for _, rules := range [][]config.Rule{cfg.Allow, cfg.Deny} {
+		for _, rule := range rules {
+			existing[rule.Tool] = append(existing[rule.Tool], rule.Commands...)
+			existing[rule.Tool] = append(existing[rule.Tool], rule.PathPatterns...)
+		}
+	}
+
+	var fresh []CommandGroup
+	for _, g := range groups {
+		if g.Tool == "Bash" && coveredBy(g.Pattern, existing[g.Tool]) ||
+			g.Tool != "Bash" && slices.Contains(existing[g.Tool], g.Pattern) {
+			covered++
+			continue
+		}
+		fresh = append(fresh, g)
+	}
+	if len(fresh) == 0 {
+		return 0, covered, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var merged strings.Builder
+	merged.Write(data)
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		merged.WriteString("\n")
+	}
+	merged.WriteString("\n")
+	writeTOMLRules(&merged, fresh, maxExamples)
+
+	if err := os.WriteFile(path, []byte(merged.String()), 0644); err != nil {
+		return 0, 0, err
+	}
+	return len(fresh), covered, nil
+}
+
+func printTOMLSuggestions(w io.Writer, groups []CommandGroup, maxExamples int) {
+	fmt.Fprintln(w, "# Suggested configuration based on session usage")
+	fmt.Fprintln(w, "# Review and customize before using")
+	fmt.Fprintln(w)
+	writeTOMLRules(w, groups, maxExamples)
+}
+
+// writeTOMLRules writes one allow rule per command name, and one per file
+// tool with its path patterns. Each pattern's examples are listed in comments.
+func writeTOMLRules(w io.Writer, groups []CommandGroup, maxExamples int) {
 	// Group by command name for cleaner output
 	byCommand := make(map[string][]CommandGroup)
+	byFileTool := make(map[string][]CommandGroup)
 	for _, g := range groups {
+		if g.Tool != "Bash" {
+			byFileTool[g.Tool] = append(byFileTool[g.Tool], g)
+			continue
+		}
 		parts := strings.Fields(g.Pattern)
 		if len(parts) > 0 {
 			byCommand[parts[0]] = append(byCommand[parts[0]], g)
 		}
 	}
 
-	for cmd, cmdGroups := range byCommand {
+	// Map iteration order is random; sort so the output is stable
+	commandNames := make([]string, 0, len(byCommand))
+	for cmd := range byCommand {
+		commandNames = append(commandNames, cmd)
+	}
+	sort.Strings(commandNames)
+
+	for _, cmd := range commandNames {
+		cmdGroups := byCommand[cmd]
 		// Count total matches across all patterns
 		totalMatches := 0
 		var cmds []string
@@ -406,36 +1347,117 @@ func printTOMLSuggestions(groups []CommandGroup) {
 			totalMatches += g.Count
 			cmds = append(cmds, g.Pattern)
 		}
-		fmt.Printf("# %s commands (matched %d times)\n", cmd, totalMatches)
-		fmt.Println("[[allow]]")
-		fmt.Println("tool = \"Bash\"")
-		fmt.Printf("description = \"%s commands\"\n", cmd)
-		fmt.Printf("commands = %s\n", toTOMLArray(cmds))
-		fmt.Println()
+		fmt.Fprintf(w, "# %s commands (matched %d times)\n", cmd, totalMatches)
+		writeTOMLExamples(w, cmdGroups, maxExamples)
+		fmt.Fprintln(w, "[[allow]]")
+		fmt.Fprintln(w, "tool = \"Bash\"")
+		fmt.Fprintf(w, "description = \"%s commands\"\n", cmd)
+		fmt.Fprintf(w, "commands = %s\n", toTOMLArray(cmds))
+		fmt.Fprintln(w)
+	}
+
+	for _, tool := range []string{"Read", "Write", "Edit"} {
+		toolGroups := byFileTool[tool]
+		if len(toolGroups) == 0 {
+			continue
+		}
+		totalMatches := 0
+		var patterns []string
+		for _, g := range toolGroups {
+			totalMatches += g.Count
+			patterns = append(patterns, g.Pattern)
+		}
+		fmt.Fprintf(w, "# %s paths (matched %d times)\n", tool, totalMatches)
+		writeTOMLExamples(w, toolGroups, maxExamples)
+		fmt.Fprintln(w, "[[allow]]")
+		fmt.Fprintf(w, "tool = \"%s\"\n", tool)
+		fmt.Fprintf(w, "description = \"%s paths\"\n", tool)
+		fmt.Fprintf(w, "path_patterns = %s\n", toTOMLArray(patterns))
+		fmt.Fprintln(w)
+	}
+}
+
+// writeTOMLExamples lists each group's examples as comments, e.g.
+// "#   git add: [git add -A git add .]"
+func writeTOMLExamples(w io.Writer, groups []CommandGroup, maxExamples int) {
+	for _, g := range groups {
+		line := fmt.Sprintf("#   %s: %s", g.Pattern, formatExamples(g.Examples, maxExamples))
+		// Multi-line commands would end the comment early
+		fmt.Fprintln(w, strings.ReplaceAll(line, "\n", " "))
 	}
 }
 
-func printTextSuggestions(groups []CommandGroup) {
+// printJSONSuggestions writes the groups as a JSON array, most used first.
+// An empty analysis is written as [] rather than null.
+func printJSONSuggestions(w io.Writer, groups []CommandGroup) error {
+	if groups == nil {
+		groups = []CommandGroup{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(groups)
+}
+
+// MergeSummary is the analyze --merge-into --json output
+type MergeSummary struct {
+	Config  string `json:"config"`
+	Added   int    `json:"added"`   // New signatures appended
+	Covered int    `json:"covered"` // Signatures the config already allowed
+}
+
+// printJSONMerge writes the merge summary as a JSON object
+func printJSONMerge(w io.Writer, summary MergeSummary) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(summary)
+}
+
+func printTextSuggestions(groups []CommandGroup, maxExamples int) {
 	fmt.Println("Suggested command patterns:")
 	fmt.Println("===========================")
 	fmt.Println()
 
 	for _, g := range groups {
-		fmt.Printf("Pattern: %s\n", g.Pattern)
-		fmt.Printf("  Count: %d\n", g.Count)
-		if len(g.Examples) <= 3 {
-			fmt.Printf("  Examples: %v\n", g.Examples)
+		if g.Tool != "Bash" {
+			fmt.Printf("Pattern: %s %s\n", g.Tool, g.Pattern)
 		} else {
-			fmt.Printf("  Examples: %v ... (%d more)\n", g.Examples[:3], len(g.Examples)-3)
+			fmt.Printf("Pattern: %s\n", g.Pattern)
 		}
+		fmt.Printf("  Count: %d\n", g.Count)
+		fmt.Printf("  Examples: %s\n", formatExamples(g.Examples, maxExamples))
 		fmt.Println()
 	}
 }
 
+// printMarkdownSuggestions renders the suggested patterns as a Markdown
+// table. generated is the timestamp shown in the report.
+func printMarkdownSuggestions(w io.Writer, groups []CommandGroup, maxExamples int, generated time.Time) {
+	fmt.Fprintln(w, "# Suggested command patterns")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Generated at %s.\n", generated.Format(time.RFC3339))
+	fmt.Fprintln(w)
+
+	var rows [][]string
+	for _, g := range groups {
+		shown, more := limitExamples(g.Examples, maxExamples)
+		examples := make([]string, len(shown))
+		for i, e := range shown {
+			examples[i] = "`" + strings.ReplaceAll(e, "`", "'") + "`"
+		}
+		cell := strings.Join(examples, ", ")
+		if more > 0 {
+			cell += fmt.Sprintf(" (%d more)", more)
+		}
+		rows = append(rows, []string{g.Tool, g.Pattern, fmt.Sprint(g.Count), cell})
+	}
+	writeMarkdownTable(w, []string{"Tool", "Pattern", "Count", "Examples"}, []bool{false, false, true, false}, rows)
+}
+
 func toTOMLArray(strs []string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
 	var quoted []string
 	for _, s := range strs {
-		quoted = append(quoted, fmt.Sprintf(`"%s"`, s))
+		quoted = append(quoted, `"`+escape.Replace(s)+`"`)
 	}
 	return "[" + strings.Join(quoted, ", ") + "]"
 }
@@ -0,0 +1,1531 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/config"
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/hook"
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/matcher"
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/parser"
+)
+
+func TestDryRunReport(t *testing.T) {
+	cfg := &config.Config{
+		Allow: []config.Rule{
+			{
+				Tool:        "Bash",
+				Commands:    []string{"git status"},
+				Description: "Git status",
+			},
+		},
+	}
+	input := &hook.HookInput{
+		SessionID: "test",
+		ToolName:  "Bash",
+		ToolInput: map[string]interface{}{"command": "git status"},
+	}
+
+	result, ok := matcher.New(cfg).Match(input)
+	if !ok {
+		t.Fatal("Match() did not handle Bash input")
+	}
+
+	reportFile := filepath.Join(t.TempDir(), "decisions.jsonl")
+	if err := writeReport(reportFile, input, result); err != nil {
+		t.Fatalf("writeReport() error = %v", err)
+	}
+
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatalf("reading report: %v", err)
+	}
+
+	var entry hook.AuditEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("parsing report entry: %v", err)
+	}
+	if entry.Decision != string(matcher.DecisionAllow) {
+		t.Errorf("Decision = %q, want %q", entry.Decision, matcher.DecisionAllow)
+	}
+	if !entry.DryRun {
+		t.Error("DryRun = false, want true")
+	}
+}
+
+func TestFailModeOverride(t *testing.T) {
+	// Run as the hook in a child process, since runCmd writes to stdout
+	if os.Getenv("FAIL_MODE_HELPER") == "1" {
+		runCmd(strings.Fields(os.Getenv("FAIL_MODE_ARGS")))
+		os.Exit(0)
+	}
+
+	broken := filepath.Join(t.TempDir(), "broken.toml")
+	if err := os.WriteFile(broken, []byte("fail_mode = \"closed\"\n[[allow]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args string
+		want string
+	}{
+		{"fail open", "--fail-open --config " + broken, "ask"},
+		{"fail closed", "--fail-closed --config " + broken, "deny"},
+		{"no override", "--config " + broken, "ask"}, // the config's fail_mode can't be read
+		{"missing config", "--fail-closed --config " + filepath.Join(t.TempDir(), "missing.toml"), "deny"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestFailModeOverride$")
+			cmd.Env = append(os.Environ(), "FAIL_MODE_HELPER=1", "FAIL_MODE_ARGS="+tt.args)
+			cmd.Stdin = strings.NewReader(`{"tool_name":"Bash","tool_input":{"command":"ls"}}`)
+			stdout, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("running hook: %v", err)
+			}
+
+			var output hook.HookOutput
+			if err := json.Unmarshal(stdout, &output); err != nil {
+				t.Fatalf("stdout %q is not JSON: %v", stdout, err)
+			}
+			if output.PermissionDecision != tt.want {
+				t.Errorf("permissionDecision = %q, want %q", output.PermissionDecision, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditEntrySubcommands(t *testing.T) {
+	cfg := &config.Config{
+		Deny: []config.Rule{
+			{
+				Tool:        "Bash",
+				Commands:    []string{"git push"},
+				Description: "Block push",
+			},
+		},
+		Allow: []config.Rule{
+			{
+				Tool:        "Bash",
+				Commands:    []string{"git add", "git commit"},
+				Description: "Git commit flow",
+			},
+		},
+	}
+	input := &hook.HookInput{
+		ToolName:  "Bash",
+		ToolInput: map[string]interface{}{"command": "git add -A && git commit -m x && git push && curl example.com"},
+	}
+
+	result, _ := matcher.New(cfg).Match(input)
+	entry := auditEntry(input, result)
+
+	want := []hook.AuditSubcommand{
+		{Command: "git add -A", Signature: "git add", Decision: "allow"},
+		{Command: "git commit -m x", Signature: "git commit", Decision: "allow"},
+		{Command: "git push", Signature: "git push", Decision: "deny"},
+		{Command: "curl example.com", Signature: "curl", Decision: "passthrough"},
+	}
+	if len(entry.Subcommands) != len(want) {
+		t.Fatalf("got %d subcommands, want %d: %+v", len(entry.Subcommands), len(want), entry.Subcommands)
+	}
+	for i := range want {
+		if entry.Subcommands[i] != want[i] {
+			t.Errorf("subcommand[%d] = %+v, want %+v", i, entry.Subcommands[i], want[i])
+		}
+	}
+
+	// Single commands carry no breakdown
+	input.ToolInput["command"] = "git add -A"
+	result, _ = matcher.New(cfg).Match(input)
+	if entry := auditEntry(input, result); entry.Subcommands != nil {
+		t.Errorf("expected no subcommands for a single command, got %+v", entry.Subcommands)
+	}
+}
+
+func TestDenyReasonDocsURL(t *testing.T) {
+	cfg := &config.Config{
+		Deny: []config.Rule{
+			{
+				Tool:        "Bash",
+				Commands:    []string{"git push"},
+				Description: "Block push",
+				DocsURL:     "https://wiki.example.com/policies/git-push",
+			},
+			{
+				Tool:        "Bash",
+				Commands:    []string{"rm"},
+				Description: "Block rm",
+			},
+		},
+	}
+	m := matcher.New(cfg)
+
+	input := &hook.HookInput{
+		ToolName:  "Bash",
+		ToolInput: map[string]interface{}{"command": "git push origin main"},
+	}
+	result, _ := m.Match(input)
+	want := "Block push: Command matched deny rule (see https://wiki.example.com/policies/git-push)"
+	if got := decisionReason(result); got != want {
+		t.Errorf("decisionReason() = %q, want %q", got, want)
+	}
+	if entry := auditEntry(input, result); entry.DocsURL != cfg.Deny[0].DocsURL {
+		t.Errorf("audit entry DocsURL = %q, want %q", entry.DocsURL, cfg.Deny[0].DocsURL)
+	}
+
+	// Rules without a docs_url keep the plain reason
+	input.ToolInput["command"] = "rm -rf build"
+	result, _ = m.Match(input)
+	if got := decisionReason(result); got != "Block rm: Command matched deny rule" {
+		t.Errorf("decisionReason() = %q, want no docs link", got)
+	}
+}
+
+func TestDenySuggestionOutput(t *testing.T) {
+	cfg := &config.Config{
+		Deny: []config.Rule{
+			{
+				Tool:            "Bash",
+				CommandPatterns: []string{`git push .*--force\b`},
+				Description:     "No force push",
+				Suggestion:      "use git push --force-with-lease",
+			},
+		},
+	}
+	if err := config.Compile(cfg); err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+
+	input := &hook.HookInput{
+		ToolName:  "Bash",
+		ToolInput: map[string]interface{}{"command": "git push origin main --force"},
+	}
+	result, ok := matcher.New(cfg).Match(input)
+	if !ok {
+		t.Fatal("Match() did not handle Bash input")
+	}
+	var out strings.Builder
+	respond(hook.NewWriter(&out), cfg, input, result, false, "")
+
+	var output hook.HookOutput
+	if err := json.Unmarshal([]byte(out.String()), &output); err != nil {
+		t.Fatalf("output %q is not JSON: %v", out.String(), err)
+	}
+	want := "No force push: Command matched deny rule. Suggestion: use git push --force-with-lease"
+	if output.PermissionDecision != "deny" || output.PermissionDecisionReason != want {
+		t.Errorf("output = %+v, want deny with reason %q", output, want)
+	}
+}
+
+func TestAskActionOutput(t *testing.T) {
+	cfg := &config.Config{
+		Allow: []config.Rule{
+			{Tool: "Bash", Commands: []string{"git push"}, Description: "Push", Action: "ask"},
+		},
+	}
+	if err := config.Compile(cfg); err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+
+	input := &hook.HookInput{
+		ToolName:  "Bash",
+		ToolInput: map[string]interface{}{"command": "git push origin main"},
+	}
+	result, _ := matcher.New(cfg).Match(input)
+	var out strings.Builder
+	respond(hook.NewWriter(&out), cfg, input, result, false, "")
+
+	var output hook.HookOutput
+	if err := json.Unmarshal([]byte(out.String()), &output); err != nil {
+		t.Fatalf("output %q is not JSON: %v", out.String(), err)
+	}
+	want := "Push: Allow rule requires confirmation"
+	if output.PermissionDecision != "ask" || output.PermissionDecisionReason != want {
+		t.Errorf("output = %+v, want ask with reason %q", output, want)
+	}
+}
+
+func TestDecisionReasonTemplated(t *testing.T) {
+	result := matcher.MatchResult{
+		Decision:    matcher.DecisionDeny,
+		Reason:      "git push is blocked by SEC-7",
+		MatchedRule: "No push",
+		Templated:   true,
+		Suggestion:  "open a pull request",
+	}
+	want := "git push is blocked by SEC-7. Suggestion: open a pull request"
+	if got := decisionReason(result); got != want {
+		t.Errorf("decisionReason() = %q, want %q", got, want)
+	}
+
+	result.Templated = false
+	if got := decisionReason(result); !strings.HasPrefix(got, "No push: ") {
+		t.Errorf("decisionReason() = %q, want the rule description prefix", got)
+	}
+}
+
+func TestDefaultsForUninspectedTools(t *testing.T) {
+	cfg := &config.Config{Defaults: map[string]string{"WebFetch": "allow", "default": "deny"}}
+	if err := config.Compile(cfg); err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	m := matcher.New(cfg)
+
+	for tool, want := range map[string]matcher.Decision{
+		"WebFetch":  matcher.DecisionAllow,
+		"WebSearch": matcher.DecisionDeny,
+	} {
+		result, ok := m.Match(&hook.HookInput{ToolName: tool, ToolInput: map[string]interface{}{}})
+		if !ok || result.Decision != want {
+			t.Errorf("Match(%s) = %v, %v, want %v", tool, result.Decision, ok, want)
+		}
+	}
+
+	// Without defaults, tools no rule inspects aren't handled
+	if _, ok := matcher.New(&config.Config{}).Match(&hook.HookInput{ToolName: "WebFetch"}); ok {
+		t.Error("Match(WebFetch) handled a tool without rules or defaults")
+	}
+}
+
+func TestWebFetchRawIPHosts(t *testing.T) {
+	cfg := &config.Config{
+		Settings: config.Settings{BlockRawIPHosts: true},
+		Allow: []config.Rule{{
+			Tool:            "WebFetch",
+			InputField:      "url",
+			CommandPatterns: []string{`^https://`},
+			Description:     "HTTPS fetches",
+		}},
+	}
+	if err := config.Compile(cfg); err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	m := matcher.New(cfg)
+
+	for url, want := range map[string]matcher.Decision{
+		"https://127.0.0.1/":      matcher.DecisionDeny,
+		"https://[::1]/":          matcher.DecisionDeny,
+		"https://example.com/":    matcher.DecisionAllow,
+		"http://example.com/docs": matcher.DecisionPassthrough,
+	} {
+		input := &hook.HookInput{ToolName: "WebFetch", ToolInput: map[string]interface{}{"url": url}}
+		result, ok := m.Match(input)
+		if !ok || result.Decision != want {
+			t.Errorf("Match(WebFetch %s) = %v, %v, want %v (reason: %s)", url, result.Decision, ok, want, result.Reason)
+		}
+	}
+}
+
+func TestSearchTools(t *testing.T) {
+	cfg, err := config.ParseString(`
+[[deny]]
+tool = "Grep"
+path_patterns = ["^/secrets(/|$)"]
+description = "No searching secrets"
+
+[[deny]]
+tool = "Glob"
+path_patterns = ["^/secrets(/|$)"]
+description = "No searching secrets"
+
+[[allow]]
+tool = "Grep"
+path_patterns = ["^/app(/|$)"]
+
+[[allow]]
+tool = "Glob"
+path_patterns = ["^/app(/|$)"]
+`)
+	if err != nil {
+		t.Fatalf("ParseString() error = %v", err)
+	}
+	m := matcher.New(cfg)
+
+	tests := []struct {
+		tool  string
+		input map[string]interface{}
+		cwd   string
+		want  matcher.Decision
+	}{
+		{"Grep", map[string]interface{}{"pattern": "password", "path": "/secrets"}, "/app", matcher.DecisionDeny},
+		{"Grep", map[string]interface{}{"pattern": "password", "path": "/secrets/prod"}, "/app", matcher.DecisionDeny},
+		{"Glob", map[string]interface{}{"pattern": "**/*.key", "path": "/secrets"}, "/app", matcher.DecisionDeny},
+		{"Grep", map[string]interface{}{"pattern": "TODO", "path": "/app/src"}, "/", matcher.DecisionAllow},
+		{"Glob", map[string]interface{}{"pattern": "*.go", "path": "/home/me"}, "/app", matcher.DecisionPassthrough},
+		// Without a path the tools search the working directory
+		{"Grep", map[string]interface{}{"pattern": "password"}, "/secrets", matcher.DecisionDeny},
+		{"Glob", map[string]interface{}{"pattern": "*.go"}, "/app", matcher.DecisionAllow},
+	}
+
+	for _, tt := range tests {
+		input := &hook.HookInput{ToolName: tt.tool, ToolInput: tt.input, Cwd: tt.cwd}
+		result, ok := m.Match(input)
+		if !ok {
+			t.Fatalf("Match() did not handle %s input %v", tt.tool, tt.input)
+		}
+		if result.Decision != tt.want {
+			t.Errorf("%s %v in %s = %v, want %v (reason: %s)", tt.tool, tt.input, tt.cwd, result.Decision, tt.want, result.Reason)
+		}
+	}
+
+	// Nothing to check without a path or working directory
+	if _, ok := m.Match(&hook.HookInput{ToolName: "Grep", ToolInput: map[string]interface{}{"pattern": "x"}}); ok {
+		t.Error("Match() handled Grep input without a path")
+	}
+}
+
+func TestCLIRules(t *testing.T) {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	var allowSigs, denySigs stringList
+	fs.Var(&allowSigs, "allow", "")
+	fs.Var(&denySigs, "deny", "")
+	if err := fs.Parse([]string{"--allow", "git status", "--deny", "git push", "--allow", "npm test"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if want := []string{"git status", "npm test"}; !slices.Equal(allowSigs, want) {
+		t.Errorf("--allow = %q, want %q", allowSigs, want)
+	}
+	if want := []string{"git push"}; !slices.Equal(denySigs, want) {
+		t.Errorf("--deny = %q, want %q", denySigs, want)
+	}
+
+	decide := func(cfg *config.Config, command string) matcher.Decision {
+		return matcher.New(cfg).MatchBashCommand(command).Decision
+	}
+
+	// On their own
+	cfg := &config.Config{}
+	if err := addCLIRules(cfg, allowSigs, denySigs); err != nil {
+		t.Fatalf("addCLIRules() error = %v", err)
+	}
+	tests := []struct {
+		command string
+		want    matcher.Decision
+	}{
+		{"git status -s", matcher.DecisionAllow},
+		{"npm test -- --watch", matcher.DecisionAllow},
+		{"git push origin main", matcher.DecisionDeny},
+		{"git status && git push", matcher.DecisionDeny},
+		{"ls", matcher.DecisionPassthrough},
+	}
+	for _, tt := range tests {
+		if got := decide(cfg, tt.command); got != tt.want {
+			t.Errorf("MatchBashCommand(%q) = %v, want %v", tt.command, got, tt.want)
+		}
+	}
+	if result := matcher.New(cfg).MatchBashCommand("git push"); result.MatchedRule != "--deny git push" {
+		t.Errorf("MatchedRule = %q, want --deny git push", result.MatchedRule)
+	}
+
+	// Merged with a config, whose deny rules still win
+	cfg, err := config.ParseString(`
+[[allow]]
+tool = "Bash"
+commands = ["ls"]
+
+[[deny]]
+tool = "Bash"
+commands = ["rm"]
+`)
+	if err != nil {
+		t.Fatalf("ParseString() error = %v", err)
+	}
+	if err := addCLIRules(cfg, []string{"rm", "make"}, nil); err != nil {
+		t.Fatalf("addCLIRules() error = %v", err)
+	}
+	for command, want := range map[string]matcher.Decision{
+		"ls -la":      matcher.DecisionAllow,
+		"make build":  matcher.DecisionAllow,
+		"rm -rf dist": matcher.DecisionDeny,
+	} {
+		if got := decide(cfg, command); got != want {
+			t.Errorf("merged: MatchBashCommand(%q) = %v, want %v", command, got, want)
+		}
+	}
+}
+
+func TestValidationJSON(t *testing.T) {
+	valid, err := config.ParseString(`
+[settings]
+max_rules = 1
+
+[[allow]]
+tool = "Bash"
+commands = ["ls"]
+
+[[deny]]
+tool = "Bash"
+commands = ["rm"]
+`)
+	if err != nil {
+		t.Fatalf("ParseString() error = %v", err)
+	}
+	_, loadErr := config.ParseString("[[allow]]\ntool = \"Bash\"\ncommand_patterns = [\"(\"]\n")
+	if loadErr == nil {
+		t.Fatal("ParseString() of invalid config succeeded")
+	}
+	clean := &config.Config{Allow: []config.Rule{{Tool: "Bash", Commands: []string{"ls"}}}}
+
+	tests := []struct {
+		name string
+		v    Validation
+		want string
+	}{
+		{
+			name: "valid",
+			v:    newValidation(clean, nil),
+			want: `{"valid":true,"allow_rules":1,"deny_rules":0,"disabled_rules":0,"warnings":[]}`,
+		},
+		{
+			name: "valid with warnings",
+			v:    newValidation(valid, nil),
+			want: `{"valid":true,"allow_rules":1,"deny_rules":1,"disabled_rules":0,"warnings":["2 rules, over max_rules 1"]}`,
+		},
+		{
+			name: "invalid",
+			v:    newValidation(nil, loadErr),
+			want: `{"valid":false,"error":` + strconv.Quote(loadErr.Error()) + `,"allow_rules":0,"deny_rules":0,"disabled_rules":0,"warnings":[]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := printJSONValidation(&out, tt.v); err != nil {
+				t.Fatalf("printJSONValidation() error = %v", err)
+			}
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, out.Bytes()); err != nil {
+				t.Fatalf("output %q is not JSON: %v", out.String(), err)
+			}
+			if compact.String() != tt.want {
+				t.Errorf("output = %s, want %s", compact.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeSummaryJSON(t *testing.T) {
+	var out bytes.Buffer
+	if err := printJSONMerge(&out, MergeSummary{Config: "hook.toml", Added: 3, Covered: 2}); err != nil {
+		t.Fatalf("printJSONMerge() error = %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("output %q is not JSON: %v", out.String(), err)
+	}
+	want := map[string]interface{}{"config": "hook.toml", "added": 3.0, "covered": 2.0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("output = %v, want %v", got, want)
+	}
+}
+
+func TestExitCodeOutput(t *testing.T) {
+	cfg := &config.Config{
+		Allow: []config.Rule{{Tool: "Bash", Commands: []string{"ls"}, Description: "Listing"}},
+		Deny: []config.Rule{
+			{Tool: "Bash", Commands: []string{"git push"}, Description: "No push"},
+			{Tool: "Bash", Commands: []string{"rm"}, Description: "No rm", Halt: true},
+		},
+	}
+	m := matcher.New(cfg)
+
+	tests := []struct {
+		command    string
+		dryRun     bool
+		wantCode   int
+		wantReason string
+	}{
+		{"ls -la", false, exitAllow, "Listing: Command matches allowed signature"},
+		{"git push", false, exitDeny, "No push: Command matched deny rule"},
+		{"rm -rf /", false, exitDeny, "No rm: Command matched deny rule"},
+		{"make", false, exitAsk, "No allow rule matched (Command signature: make)"},
+		{"git push", true, exitAsk, ""},
+	}
+
+	for _, tt := range tests {
+		input := &hook.HookInput{ToolName: "Bash", ToolInput: map[string]interface{}{"command": tt.command}}
+		result, ok := m.Match(input)
+		if !ok {
+			t.Fatal("Match() did not handle Bash input")
+		}
+		var out bytes.Buffer
+		respond(hook.NewWriter(&out), cfg, input, result, tt.dryRun, filepath.Join(t.TempDir(), "report.jsonl"))
+		code, reason := exitCodeOutput(out.Bytes())
+		if code != tt.wantCode || reason != tt.wantReason {
+			t.Errorf("%q (dry run %v): exitCodeOutput() = %d, %q, want %d, %q",
+				tt.command, tt.dryRun, code, reason, tt.wantCode, tt.wantReason)
+		}
+	}
+
+	// Failures follow the fail mode
+	for failMode, want := range map[string]int{config.FailClosed: exitDeny, config.FailOpen: exitAsk} {
+		var out bytes.Buffer
+		hook.NewWriter(&out).WriteOutput(failureOutput(failMode, "failed to load config"))
+		if code, _ := exitCodeOutput(out.Bytes()); code != want {
+			t.Errorf("fail mode %s: exit code = %d, want %d", failMode, code, want)
+		}
+	}
+	if code, _ := exitCodeOutput(nil); code != exitAsk {
+		t.Errorf("exitCodeOutput(nil) = %d, want %d", code, exitAsk)
+	}
+}
+
+func TestAskQueue(t *testing.T) {
+	cfg := &config.Config{
+		Deny: []config.Rule{
+			{
+				Tool:        "Bash",
+				Commands:    []string{"git push"},
+				Description: "Block push",
+			},
+		},
+		Allow: []config.Rule{
+			{
+				Tool:        "Bash",
+				Commands:    []string{"git status", "git add"},
+				Description: "Git",
+			},
+			{
+				Tool:        "Bash",
+				Commands:    []string{"git commit"},
+				Description: "Commits",
+				Action:      config.ActionAsk,
+			},
+		},
+	}
+	m := matcher.New(cfg)
+	queueFile := filepath.Join(t.TempDir(), "queue.jsonl")
+
+	commands := []string{
+		"git status",                        // allow
+		"git push",                          // deny
+		"curl example.com",                  // ask
+		"git add -A && npm publish && make", // ask, two commands lack a rule
+		"git commit -m x",                   // ask rule
+		"git add -A && git commit -m x",     // ask rule for one command
+	}
+	for _, command := range commands {
+		input := &hook.HookInput{
+			SessionID: "s1",
+			ToolName:  "Bash",
+			ToolInput: map[string]interface{}{"command": command},
+		}
+		result, _ := m.Match(input)
+		if err := queueAsk(queueFile, input, result); err != nil {
+			t.Fatalf("queueAsk(%q) error = %v", command, err)
+		}
+	}
+
+	data, err := os.ReadFile(queueFile)
+	if err != nil {
+		t.Fatalf("reading queue file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d queue entries, want 4:\n%s", len(lines), data)
+	}
+
+	want := []hook.QueueEntry{
+		{SessionID: "s1", ToolName: "Bash", Command: "curl example.com", Signatures: []string{"curl"}},
+		{SessionID: "s1", ToolName: "Bash", Command: "git add -A && npm publish && make", Signatures: []string{"npm publish", "make"}},
+		{SessionID: "s1", ToolName: "Bash", Command: "git commit -m x", Signatures: []string{"git commit"}},
+		{SessionID: "s1", ToolName: "Bash", Command: "git add -A && git commit -m x", Signatures: []string{"git commit"}},
+	}
+	for i, line := range lines {
+		var got hook.QueueEntry
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+		if got.Timestamp == "" {
+			t.Errorf("entry %d has no timestamp", i)
+		}
+		if got.SessionID != want[i].SessionID || got.Command != want[i].Command ||
+			strings.Join(got.Signatures, ",") != strings.Join(want[i].Signatures, ",") {
+			t.Errorf("entry %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestMergeSuggestions(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.toml")
+	original := `# My rules
+[[allow]]
+tool = "Bash"
+description = "Git"
+commands = ["git"]
+
+[[deny]]
+tool = "Bash"
+description = "No publishing"
+commands = ["npm publish"]
+`
+	if err := os.WriteFile(configFile, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	groups := analyzePermissions([]string{
+		"Bash(git status)",
+		"Bash(git commit:*)",
+		"Bash(npm publish)",
+		"Bash(npm test)",
+		"Bash(make build)",
+	}, "/home/me/project")
+	added, covered, err := mergeSuggestions(configFile, groups, 3)
+	if err != nil {
+		t.Fatalf("mergeSuggestions() error = %v", err)
+	}
+	if added != 2 || covered != 3 {
+		t.Errorf("mergeSuggestions() = %d added, %d covered, want 2 and 3", added, covered)
+	}
+
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), original) {
+		t.Errorf("existing config was not preserved:\n%s", data)
+	}
+
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		t.Fatalf("merged config invalid: %v", err)
+	}
+	m := matcher.New(cfg)
+	for _, command := range []string{"npm test", "make build", "git status"} {
+		if result := m.MatchBashCommand(command); result.Decision != matcher.DecisionAllow {
+			t.Errorf("MatchBashCommand(%q) = %v after merge, want allow", command, result.Decision)
+		}
+	}
+
+	// Merging again adds nothing
+	if added, _, err := mergeSuggestions(configFile, groups, 3); err != nil || added != 0 {
+		t.Errorf("second merge added %d (err %v), want 0", added, err)
+	}
+}
+
+func TestAnalyzeFilePermissions(t *testing.T) {
+	groups := analyzePermissions([]string{
+		"Read(//home/me/project/src/*.go)",
+		"Read(//home/me/project/src/main.go)",
+		"Read(//home/me/project/src/**/*.go)",
+		"Read(//home/me/project/docs/**)",
+		"Edit(src/app.ts)",
+		"Bash(git status)",
+	}, "/home/me/project")
+
+	got := make(map[string]int)
+	for _, g := range groups {
+		got[g.Tool+" "+g.Pattern] = g.Count
+	}
+	want := map[string]int{
+		`Read ^/home/me/project/src/.*\.go$`:   2,
+		`Read ^/home/me/project/src/main\.go$`: 1,
+		`Read ^/home/me/project/docs/.*$`:      1,
+		`Edit ^/home/me/project/src/app\.ts$`:  1,
+		`Bash git status`:                      1,
+	}
+	if len(got) != len(want) {
+		t.Errorf("got groups %v, want %v", got, want)
+	}
+	for key, count := range want {
+		if got[key] != count {
+			t.Errorf("group %q count = %d, want %d (groups: %v)", key, got[key], count, got)
+		}
+	}
+
+	// The suggestions load as a valid config that allows the reads
+	var out strings.Builder
+	printTOMLSuggestions(&out, groups, 3)
+	configFile := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configFile, []byte(out.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		t.Fatalf("suggested config invalid: %v\n%s", err, out.String())
+	}
+	m := matcher.New(cfg)
+	if result := m.MatchFilePath("Read", "/home/me/project/src/pkg/util.go"); result.Decision != matcher.DecisionAllow {
+		t.Errorf("MatchFilePath(Read, util.go) = %v, want allow", result.Decision)
+	}
+	if result := m.MatchFilePath("Read", "/home/me/project/README.md"); result.Decision == matcher.DecisionAllow {
+		t.Errorf("MatchFilePath(Read, README.md) = allow, want not allowed")
+	}
+}
+
+func TestPathGroupPattern(t *testing.T) {
+	t.Setenv("HOME", "/home/me")
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"//etc/hosts", `^/etc/hosts$`},
+		{"app.ts", `^/home/me/project/app\.ts$`},
+		{"./src/main.go", `^/home/me/project/src/main\.go$`},
+		{"*", `^/home/me/project/.*$`},
+		{"src/**", `^/home/me/project/src/.*$`},
+		{"src/**/*.ts", `^/home/me/project/src/.*\.ts$`},
+		{"//srv/logs/*.log", `^/srv/logs/.*\.log$`},
+		{"~/.config/app.toml", `^/home/me/\.config/app\.toml$`},
+	}
+	for _, tt := range tests {
+		if got := pathGroupPattern(tt.path, "/home/me/project"); got != tt.want {
+			t.Errorf("pathGroupPattern(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyzeJSON(t *testing.T) {
+	groups := analyzePermissions([]string{
+		"Bash(git add:*)",
+		"Bash(git add -A)",
+		"Bash(npm test)",
+	}, "/home/me/project")
+
+	var out strings.Builder
+	if err := printJSONSuggestions(&out, groups); err != nil {
+		t.Fatalf("printJSONSuggestions() error = %v", err)
+	}
+
+	var got []map[string]interface{}
+	if err := json.Unmarshal([]byte(out.String()), &got); err != nil {
+		t.Fatalf("output is not a JSON array: %v\n%s", err, out.String())
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d groups, want 2:\n%s", len(got), out.String())
+	}
+	first := got[0]
+	if first["tool"] != "Bash" || first["pattern"] != "git add" || first["count"] != float64(2) {
+		t.Errorf("first group = %v, want Bash git add with count 2", first)
+	}
+	if examples, ok := first["examples"].([]interface{}); !ok || len(examples) != 2 {
+		t.Errorf("first group examples = %v, want 2 examples", first["examples"])
+	}
+
+	out.Reset()
+	if err := printJSONSuggestions(&out, nil); err != nil || strings.TrimSpace(out.String()) != "[]" {
+		t.Errorf("empty analysis = %q (err %v), want []", out.String(), err)
+	}
+}
+
+func TestAnalyzeStableOrder(t *testing.T) {
+	perms := []string{
+		"Bash(npm test)",
+		"Bash(git status)",
+		"Bash(git add -A)",
+		"Bash(cargo build)",
+		"Bash(git add src)",
+		"Bash(git add .)",
+		"Read(//src/**/*.go)",
+		"Read(//src/*.go)",
+	}
+
+	render := func() string {
+		var out strings.Builder
+		for _, g := range analyzePermissions(perms, "/home/me/project") {
+			fmt.Fprintf(&out, "%s %s %d %v\n", g.Tool, g.Pattern, g.Count, g.Examples)
+		}
+		return out.String()
+	}
+
+	want := "Bash git add 3 [git add -A git add . git add src]\n" +
+		"Read ^/src/.*\\.go$ 2 [//src/**/*.go //src/*.go]\n" +
+		"Bash cargo build 1 [cargo build]\n" +
+		"Bash git status 1 [git status]\n" +
+		"Bash npm test 1 [npm test]\n"
+	for i := 0; i < 20; i++ {
+		if got := render(); got != want {
+			t.Fatalf("run %d:\n%s\nwant:\n%s", i, got, want)
+		}
+	}
+}
+
+func TestFormatExamples(t *testing.T) {
+	examples := []string{"a", "b", "c", "d"}
+	tests := []struct {
+		max  int
+		want string
+	}{
+		{3, "[a b c] ... (1 more)"},
+		{4, "[a b c d]"},
+		{0, "[a b c d]"},
+		{1, "[a] ... (3 more)"},
+	}
+	for _, tt := range tests {
+		if got := formatExamples(examples, tt.max); got != tt.want {
+			t.Errorf("formatExamples(max %d) = %q, want %q", tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestTOMLSuggestionsDeterministic(t *testing.T) {
+	perms := []string{
+		"Bash(npm test)",
+		"Bash(git status)",
+		"Bash(cargo build)",
+		"Bash(docker ps)",
+		"Bash(make)",
+		"Bash(kubectl get pods)",
+		"Bash(go test ./...)",
+		"Read(//src/main.go)",
+		"Edit(//src/main.go)",
+	}
+
+	render := func() string {
+		var out strings.Builder
+		printTOMLSuggestions(&out, analyzePermissions(perms, "/home/me/project"), 3)
+		return out.String()
+	}
+
+	first := render()
+	for i := 0; i < 20; i++ {
+		if got := render(); got != first {
+			t.Fatalf("run %d differs:\n%s\nfirst run:\n%s", i, got, first)
+		}
+	}
+
+	// Bash rules are emitted in command name order
+	var names []string
+	for _, line := range strings.Split(first, "\n") {
+		if strings.HasPrefix(line, "description = ") && strings.HasSuffix(line, ` commands"`) {
+			names = append(names, line)
+		}
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("rules are not sorted by command: %v", names)
+	}
+}
+
+func TestServe(t *testing.T) {
+	cfg := &config.Config{
+		Allow: []config.Rule{
+			{Tool: "Bash", Commands: []string{"git status"}, Description: "Git status"},
+		},
+		Deny: []config.Rule{
+			{Tool: "Bash", Commands: []string{"rm"}, Description: "No rm"},
+		},
+	}
+	in := strings.Join([]string{
+		`{"tool_name":"Bash","tool_input":{"command":"git status"}}`,
+		`{"tool_name":"Bash","tool_input":{"command":"rm -rf /"}}`,
+		``,
+		`not json`,
+		`{"tool_name":"Bash","tool_input":{"command":"make"}}`,
+		`{"tool_name":"UnknownTool","tool_input":{}}`, // no trailing newline
+	}, "\n")
+
+	var out strings.Builder
+	s := newServer(cfg, config.FailClosed, nil, nil)
+	if err := s.serve(strings.NewReader(in), &out); err != nil {
+		t.Fatalf("serve() error = %v", err)
+	}
+
+	var got []string
+	var last hook.HookOutput
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		if err := json.Unmarshal([]byte(line), &last); err != nil {
+			t.Fatalf("parsing output line %q: %v", line, err)
+		}
+		got = append(got, last.PermissionDecision)
+	}
+	// The blank line is skipped and the malformed one fails closed
+	want := []string{"allow", "deny", "deny", "ask", "ask"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("decisions = %v, want %v", got, want)
+	}
+	if want := "No rules for tool UnknownTool"; last.PermissionDecisionReason != want {
+		t.Errorf("UnknownTool reason = %q, want %q", last.PermissionDecisionReason, want)
+	}
+}
+
+func TestServeRecoversFromPanic(t *testing.T) {
+	s := newServer(&config.Config{}, config.FailClosed, nil, nil)
+	s.m = nil // Matching panics on a nil matcher
+
+	in := `{"tool_name":"Bash","tool_input":{"command":"ls"}}` + "\n" +
+		`{"tool_name":"Bash","tool_input":{"command":"pwd"}}` + "\n"
+	var out strings.Builder
+	if err := s.serve(strings.NewReader(in), &out); err != nil {
+		t.Fatalf("serve() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d output lines, want 2: %q", len(lines), out.String())
+	}
+	for _, line := range lines {
+		var output hook.HookOutput
+		if err := json.Unmarshal([]byte(line), &output); err != nil {
+			t.Fatalf("parsing output line %q: %v", line, err)
+		}
+		if output.PermissionDecision != "deny" {
+			t.Errorf("decision = %q, want deny (fail closed)", output.PermissionDecision)
+		}
+	}
+}
+
+func TestServeReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	writeConfig := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decision := func(s *server) string {
+		t.Helper()
+		var out strings.Builder
+		in := `{"tool_name":"Bash","tool_input":{"command":"make build"}}`
+		if err := s.serve(strings.NewReader(in), &out); err != nil {
+			t.Fatalf("serve() error = %v", err)
+		}
+		var output hook.HookOutput
+		if err := json.Unmarshal([]byte(out.String()), &output); err != nil {
+			t.Fatalf("parsing output %q: %v", out.String(), err)
+		}
+		return output.PermissionDecision
+	}
+	poll := func(w *configWatcher, s *server) error {
+		t.Helper()
+		cfg, err := w.poll()
+		if cfg != nil {
+			s.reloads <- cfg
+		}
+		return err
+	}
+
+	writeConfig("[[allow]]\ntool = \"Bash\"\ncommands = [\"git\"]\n")
+	w := newConfigWatcher(path, "")
+	cfg, err := loadConfig(path, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(cfg, "", nil, nil)
+	if got := decision(s); got != "ask" {
+		t.Fatalf("before reload: decision = %q, want ask", got)
+	}
+
+	// Unchanged config: nothing to reload
+	if cfg, err := w.poll(); cfg != nil || err != nil {
+		t.Errorf("poll() without changes = %v, %v, want nil, nil", cfg, err)
+	}
+
+	writeConfig("[[allow]]\ntool = \"Bash\"\ncommands = [\"make\"]\n")
+	if err := poll(w, s); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	if got := decision(s); got != "allow" {
+		t.Errorf("after reload: decision = %q, want allow", got)
+	}
+
+	// An invalid config is reported and the previous rules stay in effect
+	writeConfig("[[deny]]\ntool = \"Bash\"\ncommands = [\"make\"]\ncommand_patterns = [\"(\"]\n")
+	if err := poll(w, s); err == nil {
+		t.Error("poll() of invalid config succeeded, want error")
+	}
+	if got := decision(s); got != "allow" {
+		t.Errorf("after invalid reload: decision = %q, want allow", got)
+	}
+}
+
+func TestHaltOutput(t *testing.T) {
+	cfg := &config.Config{
+		Deny: []config.Rule{
+			{Tool: "Bash", Commands: []string{"rm"}, Description: "No rm", Halt: true},
+			{Tool: "Bash", Commands: []string{"git push"}, Description: "No push"},
+		},
+	}
+	m := matcher.New(cfg)
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"git push", `{"permissionDecision":"deny","permissionDecisionReason":"No push: Command matched deny rule"}`},
+		{"rm -rf /", `{"permissionDecision":"deny","permissionDecisionReason":"No rm: Command matched deny rule",` +
+			`"continue":false,"stopReason":"No rm: Command matched deny rule"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			input := &hook.HookInput{ToolName: "Bash", ToolInput: map[string]interface{}{"command": tt.command}}
+			result, ok := m.Match(input)
+			if !ok {
+				t.Fatal("Match() did not handle Bash input")
+			}
+			var out strings.Builder
+			respond(hook.NewWriter(&out), cfg, input, result, false, "")
+			if got := strings.TrimSuffix(out.String(), "\n"); got != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveConfigPath(t *testing.T) {
+	tests := []struct {
+		name              string
+		path, dir, inline string
+		env               string
+		want              string
+		wantErr           bool
+	}{
+		{name: "flag", path: "a.toml", want: "a.toml"},
+		{name: "env fallback", env: "env.toml", want: "env.toml"},
+		{name: "flag wins over env", path: "a.toml", env: "env.toml", want: "a.toml"},
+		{name: "dir ignores env", dir: "conf.d", env: "env.toml"},
+		{name: "inline ignores env", inline: "[audit]", env: "env.toml"},
+		{name: "none", wantErr: true},
+		{name: "two sources", path: "a.toml", inline: "[audit]", wantErr: true},
+	}
+
+	// Keep the default locations from finding a real config
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(configEnvVar, tt.env)
+			got, err := resolveConfigPath(tt.path, tt.dir, tt.inline)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveConfigPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveConfigPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigSearch(t *testing.T) {
+	root := t.TempDir()
+	home := filepath.Join(root, "home")
+	xdg := filepath.Join(root, "xdg")
+	work := filepath.Join(root, "work")
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	t.Setenv(configEnvVar, "")
+	if err := os.Mkdir(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(work); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	homeConfig := filepath.Join(home, ".config", "claude-hooks", "config.toml")
+	xdgConfig := filepath.Join(xdg, "claude-hooks", "config.toml")
+	localConfig := ".claude-hooks.toml"
+
+	// Nothing to find
+	if _, err := resolveConfigPath("", "", ""); err == nil {
+		t.Fatal("resolveConfigPath() found a config in empty directories")
+	}
+
+	// Each location added takes precedence over those already present
+	for _, path := range []string{localConfig, homeConfig, xdgConfig} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("[audit]\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := resolveConfigPath("", "", "")
+		if err != nil || got != path {
+			t.Errorf("resolveConfigPath() = %q, %v, want %q", got, err, path)
+		}
+	}
+
+	// The environment variable and flags override the search
+	t.Setenv(configEnvVar, "env.toml")
+	if got, _ := resolveConfigPath("", "", ""); got != "env.toml" {
+		t.Errorf("with %s: resolveConfigPath() = %q, want env.toml", configEnvVar, got)
+	}
+	if got, _ := resolveConfigPath("a.toml", "", ""); got != "a.toml" {
+		t.Errorf("with --config: resolveConfigPath() = %q, want a.toml", got)
+	}
+	if got, err := resolveConfigPath("", "conf.d", ""); err != nil || got != "" {
+		t.Errorf("with --config-dir: resolveConfigPath() = %q, %v", got, err)
+	}
+}
+
+func TestDefaultConfigProtectsHookConfig(t *testing.T) {
+	cfg, err := config.ParseString(defaultConfig)
+	if err != nil {
+		t.Fatalf("ParseString(defaultConfig) error = %v", err)
+	}
+	m := matcher.New(cfg)
+
+	tests := []struct {
+		tool  string
+		input map[string]interface{}
+		want  matcher.Decision
+	}{
+		{"Write", map[string]interface{}{"file_path": "/repo/.claude-hooks.toml", "content": "[settings]"}, matcher.DecisionDeny},
+		{"Edit", map[string]interface{}{"file_path": "/home/me/.config/claude-hooks/config.toml"}, matcher.DecisionDeny},
+		{"Bash", map[string]interface{}{"command": "echo '[settings]' > .claude-hooks.toml"}, matcher.DecisionDeny},
+		{"Write", map[string]interface{}{"file_path": "/repo/main.go", "content": "package main"}, matcher.DecisionPassthrough},
+	}
+
+	for _, tt := range tests {
+		input := &hook.HookInput{ToolName: tt.tool, ToolInput: tt.input, Cwd: "/repo"}
+		result, _ := m.Match(input)
+		if result.Decision != tt.want {
+			t.Errorf("%s %v = %v, want %v (reason: %s)", tt.tool, tt.input, result.Decision, tt.want, result.Reason)
+		}
+	}
+}
+
+func TestParseWithConfig(t *testing.T) {
+	cfg, err := config.ParseString(`
+[[allow]]
+tool = "Bash"
+description = "Git"
+commands = ["git add", "git status"]
+
+[[deny]]
+tool = "Bash"
+description = "No push"
+commands = ["git push"]
+`)
+	if err != nil {
+		t.Fatalf("ParseString() error = %v", err)
+	}
+	m := matcher.New(cfg)
+
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"git add -A && git push", []string{
+			"Signature: git push",
+			"Decision: deny",
+			"Reason: No push: Compound command denied: git push matched deny rule",
+			"git add -A (git add): allow",
+		}},
+		{"git psuh", []string{
+			"Signature: git psuh",
+			"Decision: passthrough",
+			`[why-not] git psuh: allow rule "Git": signature was git psuh, the rule has git add`,
+		}},
+		{"git status", []string{"Decision: allow", "Reason: Git: Command matches allowed signature"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			stmt, err := parser.ParseShellCommand(tt.command)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			result := matchParsed(m, tt.command)
+
+			var out strings.Builder
+			printTextStatement(&out, tt.command, stmt)
+			printDecision(&out, m, tt.command, result)
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output missing %q:\n%s", want, out.String())
+				}
+			}
+
+			var jsonOut strings.Builder
+			if err := printJSONStatement(&jsonOut, stmt, &result); err != nil {
+				t.Fatalf("printJSONStatement() error = %v", err)
+			}
+			var got struct {
+				Match struct {
+					Decision string `json:"decision"`
+				} `json:"match"`
+			}
+			if err := json.Unmarshal([]byte(jsonOut.String()), &got); err != nil || got.Match.Decision != string(result.Decision) {
+				t.Errorf("JSON match = %+v (%v), want decision %s", got.Match, err, result.Decision)
+			}
+		})
+	}
+}
+
+func TestParseCmdUsesConfigRunners(t *testing.T) {
+	// Run parse in a child process, since parseCmd writes to stdout and the
+	// config's runners are set up for the whole process
+	if os.Getenv("PARSE_CMD_HELPER") == "1" {
+		parseCmd(strings.Fields(os.Getenv("PARSE_CMD_ARGS")))
+		os.Exit(0)
+	}
+
+	configFile := filepath.Join(t.TempDir(), "config.toml")
+	rules := "[[runner]]\ncommand = \"just\"\narg = 2\n\n[[deny]]\ntool = \"Bash\"\ndescription = \"No rm\"\ncommands = [\"rm\"]\n"
+	if err := os.WriteFile(configFile, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestParseCmdUsesConfigRunners$")
+	cmd.Env = append(os.Environ(), "PARSE_CMD_HELPER=1", "PARSE_CMD_ARGS=--config "+configFile+" just clean rm")
+	stdout, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("running parse: %v", err)
+	}
+	// The structure shows the runner's command the deny decision is based on
+	for _, want := range []string{"Parsed 2 command(s)", "Signature: rm", "Decision: deny"} {
+		if !strings.Contains(string(stdout), want) {
+			t.Errorf("output missing %q:\n%s", want, stdout)
+		}
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	stmt, err := parser.ParseShellCommand(`FOO=1 git -C /srv push && bash -c "if" | cat`)
+	if err != nil {
+		t.Fatalf("ParseShellCommand() error = %v", err)
+	}
+
+	var out strings.Builder
+	if err := printJSONStatement(&out, stmt, nil); err != nil {
+		t.Fatalf("printJSONStatement() error = %v", err)
+	}
+
+	var got struct {
+		HasPipe  bool `json:"has_pipe"`
+		Commands []struct {
+			Name      string   `json:"name"`
+			Args      []string `json:"args"`
+			Operator  string   `json:"operator"`
+			Env       []string `json:"env"`
+			Dir       string   `json:"dir"`
+			Signature string   `json:"signature"`
+		} `json:"commands"`
+		NestedError string `json:"nested_error"`
+	}
+	if err := json.Unmarshal([]byte(out.String()), &got); err != nil {
+		t.Fatalf("parsing output: %v\n%s", err, out.String())
+	}
+
+	if !got.HasPipe || len(got.Commands) != 3 {
+		t.Fatalf("output = %+v, want a pipe and 3 commands", got)
+	}
+	git := got.Commands[0]
+	if git.Signature != "git push" || git.Operator != "&&" || git.Dir != "/srv" ||
+		fmt.Sprint(git.Env) != "[FOO=1]" || len(git.Args) != 4 {
+		t.Errorf("git command = %+v", git)
+	}
+	if got.NestedError == "" {
+		t.Error("nested_error is empty, want the bash -c parse error")
+	}
+	if !strings.Contains(out.String(), `"operator": "&&"`) {
+		t.Errorf("output escapes operators:\n%s", out.String())
+	}
+}
+
+var update = flag.Bool("update", false, "Rewrite golden files with the current output")
+
+// checkGolden compares got to the golden file at path, or rewrites it with -update
+func checkGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s (run go test -update to accept)\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestMarkdownMetricsGolden(t *testing.T) {
+	f, err := os.Open(filepath.Join("tests", "sample-audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	metrics, err := collectMetrics(f, 10)
+	if err != nil {
+		t.Fatalf("collectMetrics() error = %v", err)
+	}
+	if metrics.Total != 8 || metrics.Skipped != 1 {
+		t.Errorf("collectMetrics() read %d entries and skipped %d, want 8 and 1", metrics.Total, metrics.Skipped)
+	}
+
+	var buf bytes.Buffer
+	printMarkdownMetrics(&buf, metrics, time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC))
+	checkGolden(t, filepath.Join("tests", "metrics.golden.md"), buf.Bytes())
+}
+
+func TestDenyExitCode(t *testing.T) {
+	// Run as the hook in a child process, so its exit code can be checked
+	if os.Getenv("DENY_EXIT_CODE_HELPER") == "1" {
+		runCmd([]string{"--config-inline", os.Getenv("DENY_EXIT_CODE_CONFIG")})
+		os.Exit(0)
+	}
+
+	conf := `
+[settings]
+deny_exit_code = 2
+
+[[allow]]
+tool = "Bash"
+commands = ["git status"]
+
+[[deny]]
+tool = "Bash"
+commands = ["rm"]
+`
+	tests := []struct {
+		command      string
+		wantDecision string
+		wantCode     int
+		wantStderr   string
+	}{
+		{"rm -rf build", "deny", 2, "Command matched deny rule"},
+		{"git status", "allow", 0, ""},
+		{"curl example.com", "ask", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestDenyExitCode$")
+			cmd.Env = append(os.Environ(), "DENY_EXIT_CODE_HELPER=1", "DENY_EXIT_CODE_CONFIG="+conf)
+			cmd.Stdin = strings.NewReader(fmt.Sprintf(`{"tool_name":"Bash","tool_input":{"command":%q}}`, tt.command))
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			stdout, err := cmd.Output()
+
+			code := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				code = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatalf("running hook: %v", err)
+			}
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+
+			var output hook.HookOutput
+			if err := json.Unmarshal(stdout, &output); err != nil {
+				t.Fatalf("stdout %q is not JSON: %v", stdout, err)
+			}
+			if output.PermissionDecision != tt.wantDecision {
+				t.Errorf("permissionDecision = %q, want %q", output.PermissionDecision, tt.wantDecision)
+			}
+			// Claude Code shows stderr, not stdout, on exit code 2
+			if got := stderr.String(); tt.wantStderr != "" && !strings.Contains(got, tt.wantStderr) {
+				t.Errorf("stderr = %q, want the deny reason %q", got, tt.wantStderr)
+			} else if tt.wantStderr == "" && strings.TrimSpace(got) != "" {
+				t.Errorf("stderr = %q, want nothing", got)
+			}
+		})
+	}
+
+	if _, err := config.ParseString("[settings]\ndeny_exit_code = 256\n"); err == nil {
+		t.Error("deny_exit_code = 256 loaded, want error")
+	}
+}
+
+func TestFindAuditEntries(t *testing.T) {
+	log := `{"tool_use_id":"toolu_1","tool_name":"Bash","decision":"allow"}
+{"tool_use_id":"toolu_2","tool_name":"Bash","decision":"deny","reason":"Command matched deny rule"}
+not json
+{"tool_name":"Read","decision":"passthrough"}
+{"tool_use_id":"toolu_2","tool_name":"Bash","decision":"deny","dry_run":true}`
+
+	entries, err := findAuditEntries(strings.NewReader(log), "toolu_2")
+	if err != nil {
+		t.Fatalf("findAuditEntries() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Reason != "Command matched deny rule" || !entries[1].DryRun {
+		t.Errorf("findAuditEntries() = %+v, want both toolu_2 entries in log order", entries)
+	}
+
+	if entries, _ := findAuditEntries(strings.NewReader(log), "toolu_9"); len(entries) != 0 {
+		t.Errorf("findAuditEntries() for an unknown id = %+v, want none", entries)
+	}
+
+	// The id is recorded from the hook input
+	input := &hook.HookInput{ToolName: "Bash", ToolUseID: "toolu_3"}
+	if entry := auditEntry(input, matcher.MatchResult{Decision: matcher.DecisionAllow}); entry.ToolUseID != "toolu_3" {
+		t.Errorf("auditEntry() ToolUseID = %q, want toolu_3", entry.ToolUseID)
+	}
+}
+
+func TestMarkdownSuggestionsGolden(t *testing.T) {
+	groups := analyzePermissions([]string{
+		"Bash(git status)",
+		"Bash(git status --short)",
+		"Bash(go test:*)",
+		"Bash(grep -r 'a|b' .)",
+		"Read(/home/me/project/src/main.go)",
+	}, "/home/me/project")
+
+	var buf bytes.Buffer
+	printMarkdownSuggestions(&buf, groups, 3, time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC))
+	checkGolden(t, filepath.Join("tests", "analyze.golden.md"), buf.Bytes())
+}
+
+func TestReadTranscript(t *testing.T) {
+	f, err := os.Open(filepath.Join("tests", "sample-transcript.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	uses, skipped, err := readTranscript(f, 0)
+	if err != nil {
+		t.Fatalf("readTranscript() error = %v", err)
+	}
+	want := []ToolUse{
+		{Tool: "Bash", Value: "git status"},
+		{Tool: "Read", Value: "/home/me/project/src/main.go"},
+		{Tool: "Bash", Value: "go test ./...\ngit status --short"},
+		{Tool: "Edit", Value: "/home/me/project/src/main_test.go"},
+	}
+	if fmt.Sprint(uses) != fmt.Sprint(want) {
+		t.Errorf("readTranscript() = %v, want %v", uses, want)
+	}
+	if skipped != 1 {
+		t.Errorf("readTranscript() skipped %d lines, want 1", skipped)
+	}
+
+	groups := groupToolUses(uses, "/home/me/project")
+	if len(groups) == 0 || groups[0].Pattern != "git status" || groups[0].Count != 2 ||
+		!slices.Contains(groups[0].Examples, "go test ./...; git status --short") {
+		t.Errorf("groupToolUses() = %+v, want git status first with 2 uses, examples on one line", groups)
+	}
+
+	// --last keeps the most recent uses
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	uses, _, err = readTranscript(f, 2)
+	if err != nil {
+		t.Fatalf("readTranscript() error = %v", err)
+	}
+	if fmt.Sprint(uses) != fmt.Sprint(want[2:]) {
+		t.Errorf("readTranscript(last 2) = %v, want %v", uses, want[2:])
+	}
+}
@@ -0,0 +1,194 @@
+package matcher
+
+import (
+	"net"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/parser"
+)
+
+// broadPermissionTargets are roots where a recursive permission change
+// affects far more than the task at hand
+var broadPermissionTargets = map[string]bool{
+	"/":       true,
+	"/*":      true,
+	"~":       true,
+	"~/*":     true,
+	"${HOME}": true,
+	".":       true,
+	"./*":     true,
+	"..":      true,
+	"*":       true,
+}
+
+// checkRecursivePermissionChange flags chmod/chown/chgrp run recursively on a
+// broad root such as /, ~, . or $HOME. Scoped changes (chmod -R 755 ./bin)
+// are left to the normal rules.
+func checkRecursivePermissionChange(cmd parser.ParsedCommand) (string, bool) {
+	cmd = parser.UnwrapCommand(cmd)
+	switch parser.GetCommandName(cmd) {
+	case "chmod", "chown", "chgrp":
+	default:
+		return "", false
+	}
+
+	recursive := false
+	var operands []string
+	for _, arg := range cmd.Args[1:] {
+		switch {
+		case arg == "--recursive":
+			recursive = true
+		case strings.HasPrefix(arg, "--"):
+		case strings.HasPrefix(arg, "-") && len(arg) > 1 && isFlagCluster(arg[1:]):
+			if strings.Contains(arg, "R") {
+				recursive = true
+			}
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	if !recursive || len(operands) < 2 {
+		return "", false
+	}
+
+	// The first operand is the mode or owner; the rest are targets
+	for _, target := range operands[1:] {
+		if isBroadTarget(target) {
+			return target, true
+		}
+	}
+	return "", false
+}
+
+// isFlagCluster reports whether s looks like bundled short flags (e.g. "Rf")
+// rather than a symbolic chmod mode like "w" in "chmod -w file"
+func isFlagCluster(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("RfvcHLPh", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// isBroadTarget reports whether a path names a broad root
+func isBroadTarget(target string) bool {
+	if target == "" {
+		return false
+	}
+	target = strings.Replace(target, "$HOME", "${HOME}", 1)
+	return broadPermissionTargets[path.Clean(target)]
+}
+
+// pipeShells are the shells that run a script piped to them
+var pipeShells = map[string]bool{
+	"sh":   true,
+	"bash": true,
+	"zsh":  true,
+	"dash": true,
+	"ksh":  true,
+}
+
+// checkPipeToShell flags a pipeline where the output of one of sources
+// (curl, wget) reaches a shell that reads its script from stdin, as in
+// curl -fsSL https://x | sudo bash. It returns the source and shell names.
+// A shell given a script file or -c reads its stdin as data and isn't
+// flagged.
+func checkPipeToShell(cmds []parser.ParsedCommand, sources []string) (string, string, bool) {
+	source := ""
+	for _, cmd := range cmds {
+		inner := parser.UnwrapCommand(cmd)
+		name := parser.GetCommandName(inner)
+		if source != "" && pipeShells[name] {
+			if _, fromStdin := parser.InterpreterScript(inner); fromStdin {
+				return source, name, true
+			}
+		}
+		if source == "" && (slices.Contains(sources, name) || slices.Contains(sources, "*")) {
+			source = name
+		}
+		// Only later stages of the same pipeline get the source's output
+		if cmd.Operator != "|" && cmd.Operator != "|&" {
+			source = ""
+		}
+	}
+	return "", "", false
+}
+
+// checkRawIPHost returns the host of a URL that names an IP address or
+// localhost rather than a domain: 127.0.0.1, [::1], localhost, and numeric
+// IPv4 forms like 2130706433 or 0x7f.1 that resolvers also accept
+func checkRawIPHost(rawURL string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", false
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return "", false
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return host, true
+	}
+	ip, _, _ := strings.Cut(host, "%") // IPv6 zone, as in [fe80::1%25eth0]
+	if net.ParseIP(ip) != nil || isNumericHost(host) {
+		return host, true
+	}
+	return "", false
+}
+
+// isNumericHost reports whether every dot-separated part of a host is a
+// decimal, octal or 0x hex number, which resolvers read as an IPv4 address
+func isNumericHost(host string) bool {
+	for _, part := range strings.Split(host, ".") {
+		digits := part
+		if strings.HasPrefix(part, "0x") {
+			digits = part[2:]
+		}
+		if digits == "" || strings.Trim(digits, "0123456789abcdef") != "" {
+			return false
+		}
+		if digits == part && strings.Trim(part, "0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// checkGitConfig returns the setting of a git command that sets one of the
+// given config keys with -c or --config-env. Keys compare ignoring case, as
+// git's section and variable names do, and * matches any run of characters.
+func checkGitConfig(cmd parser.ParsedCommand, keys []string) (string, bool) {
+	for _, setting := range cmd.GitConfig {
+		key, _, _ := strings.Cut(setting, "=")
+		for _, pattern := range keys {
+			if matchConfigKey(strings.ToLower(pattern), strings.ToLower(key)) {
+				return setting, true
+			}
+		}
+	}
+	return "", false
+}
+
+// matchConfigKey matches a config key against a pattern where * matches any
+// run of characters, including dots (protocol.*.allow covers
+// protocol.ext.allow)
+func matchConfigKey(pattern, key string) bool {
+	prefix, rest, found := strings.Cut(pattern, "*")
+	if !found {
+		return pattern == key
+	}
+	if !strings.HasPrefix(key, prefix) {
+		return false
+	}
+	key = key[len(prefix):]
+	for i := 0; i <= len(key); i++ {
+		if matchConfigKey(rest, key[i:]) {
+			return true
+		}
+	}
+	return false
+}
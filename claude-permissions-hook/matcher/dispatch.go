@@ -0,0 +1,80 @@
+package matcher
+
+import (
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/hook"
+)
+
+// Match matches a hook input against the rules, picking the matcher for the
+// tool and the input fields it reads. The boolean is false when the tool
+// isn't handled: it has no rules or default, or lacks the field its rules
+// match on, and the hook should pass the call through without a decision.
+//
+// The hook package only depends on the standard library, so it must not
+// import matcher; doing so would create an import cycle.
+func (m *Matcher) Match(input *hook.HookInput) (MatchResult, bool) {
+	m.SetCwd(input.Cwd)
+	switch input.ToolName {
+	case "Bash":
+		// Empty commands are classified by MatchBashCommand
+		return m.MatchBashCommand(input.GetBashCommand()), true
+
+	case "PowerShell":
+		return m.MatchPowerShellCommand(input.GetBashCommand()), true
+
+	case "Read", "Write", "Edit":
+		path := input.GetFilePath()
+		if path == "" {
+			return MatchResult{}, false
+		}
+		if input.ToolName == "Read" {
+			return m.MatchRead(path, ReadRange{
+				Offset: input.GetReadOffset(),
+				Limit:  input.GetReadLimit(),
+			}), true
+		}
+		return m.MatchFileWrite(input.ToolName, path, input.GetFileContent()), true
+
+	case "Grep", "Glob":
+		path := input.GetSearchPath()
+		if path == "" {
+			return MatchResult{}, false
+		}
+		result := m.MatchSearch(input.ToolName, path)
+		// A pattern like /secrets/** reaches past the searched path, so
+		// where it points must be allowed too
+		if reach := input.GetSearchPatternPath(); reach != "" && reach != path && result.Decision != DecisionDeny {
+			if reached := m.MatchSearch(input.ToolName, reach); reached.Decision == DecisionDeny || result.Decision == DecisionAllow {
+				return reached, true
+			}
+		}
+		return result, true
+
+	case "Skill":
+		skillName := input.GetSkillName()
+		if skillName == "" {
+			return MatchResult{}, false
+		}
+		return m.MatchSkill(skillName), true
+
+	case "WebFetch":
+		if result, ok := m.MatchURL(input.GetURL()); ok {
+			return result, true
+		}
+		return m.matchOtherTool(input)
+
+	default:
+		return m.matchOtherTool(input)
+	}
+}
+
+// matchOtherTool matches a tool without built-in handling. Such tools are
+// only matched when configured to inspect their input or given a default.
+func (m *Matcher) matchOtherTool(input *hook.HookInput) (MatchResult, bool) {
+	if m.HasMCPMapping(input.ToolName) {
+		return m.MatchMCP(input.ToolName, input.ToolInput), true
+	}
+	if m.HandlesTool(input.ToolName) {
+		return m.MatchToolInput(input.ToolName, input.ToolInput), true
+	}
+	return m.MatchDefault(input.ToolName)
+}
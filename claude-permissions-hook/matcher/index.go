@@ -0,0 +1,156 @@
+package matcher
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/config"
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/parser"
+)
+
+// ruleIndex groups rules by tool, and Bash rules by the leading words of
+// their commands entries, so matching only evaluates rules that could
+// apply. Rule order within a tool is preserved: candidates are returned as
+// ascending indices, so ties still go to the earliest rule.
+type ruleIndex struct {
+	byTool map[string]*toolRules
+}
+
+// toolRules holds one tool's rules in config order
+type toolRules struct {
+	rules  []config.Rule
+	all    []int            // Every index, for tools without a name index
+	byName map[string][]int // Bash: entry key (see entryKey) -> rule indices
+	always []int            // Bash: rules a name can't rule out (regexes, wildcards)
+}
+
+// newRuleIndex indexes rules, which must already be filtered and compiled
+func newRuleIndex(rules []config.Rule) ruleIndex {
+	ix := ruleIndex{byTool: make(map[string]*toolRules)}
+	for _, rule := range rules {
+		tr := ix.byTool[rule.Tool]
+		if tr == nil {
+			tr = &toolRules{byName: make(map[string][]int)}
+			ix.byTool[rule.Tool] = tr
+		}
+		i := len(tr.rules)
+		tr.rules = append(tr.rules, rule)
+		tr.all = append(tr.all, i)
+
+		keys, ok := entryKeys(rule)
+		if !ok {
+			tr.always = append(tr.always, i)
+			continue
+		}
+		for _, key := range keys {
+			tr.byName[key] = appendIndex(tr.byName[key], i)
+		}
+	}
+	return ix
+}
+
+// entryKeys returns the index keys of a Bash rule's commands entries. ok is
+// false when the rule can match commands regardless of their name: it has
+// command_patterns, a trailing wildcard entry (whose prefix match isn't
+// word-bounded), an entry starting with a wildcard, only denied_env,
+// dir_patterns or path_patterns, or isn't a Bash rule. An embedded wildcard
+// entry is keyed by the words before it.
+func entryKeys(rule config.Rule) ([]string, bool) {
+	if rule.Tool != "Bash" || len(rule.CommandPatterns) > 0 || len(rule.Commands) == 0 {
+		return nil, false
+	}
+	var keys []string
+	for _, entry := range rule.Commands {
+		entry = parser.NormalizeSignature(entry)
+		if strings.HasSuffix(entry, " *") {
+			return nil, false
+		}
+		words := strings.Fields(entry)
+		if i := slices.Index(words, "*"); i >= 0 {
+			if i == 0 {
+				return nil, false
+			}
+			words = words[:i]
+		}
+		if key := entryKey(words); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys, true
+}
+
+// entryKey keys an entry by its first two words. A single-word entry
+// matches a command by name or by a one-word signature, and a longer one
+// only matches signatures starting with the same two words, so looking up
+// both forms for a command finds every entry that could match it.
+func entryKey(words []string) string {
+	if len(words) > 2 {
+		words = words[:2]
+	}
+	return strings.Join(words, " ")
+}
+
+// appendIndex appends i unless it's already the last element
+func appendIndex(indices []int, i int) []int {
+	if n := len(indices); n > 0 && indices[n-1] == i {
+		return indices
+	}
+	return append(indices, i)
+}
+
+// rules returns a tool's rules in config order
+func (ix ruleIndex) rules(tool string) []config.Rule {
+	if tr := ix.byTool[tool]; tr != nil {
+		return tr.rules
+	}
+	return nil
+}
+
+// candidates returns the ascending indices into rules(tool) of the rules that
+// could match any of cmds. Tools other than Bash aren't indexed by name.
+func (ix ruleIndex) candidates(tool string, cmds []parser.ParsedCommand) []int {
+	tr := ix.byTool[tool]
+	if tr == nil {
+		return nil
+	}
+	if tool != "Bash" {
+		return tr.all
+	}
+
+	seen := make(map[int]bool)
+	var result []int
+	add := func(indices []int) {
+		for _, i := range indices {
+			if !seen[i] {
+				seen[i] = true
+				result = append(result, i)
+			}
+		}
+	}
+	add(tr.always)
+	for _, cmd := range cmds {
+		// The signature's first word is also the command's own name (or
+		// wrapper), which single-word entries match against. Deny entries
+		// also match the wrapped command, so look that up too.
+		for _, c := range wrappedCommands(tool, cmd) {
+			words := strings.Fields(commandSignature(tool, c))
+			if len(words) > 0 {
+				add(tr.byName[words[0]])
+			}
+			if len(words) > 1 {
+				add(tr.byName[entryKey(words)])
+			}
+		}
+	}
+	sort.Ints(result)
+	return result
+}
+
+// all returns the indices of every rule of a tool
+func (ix ruleIndex) all(tool string) []int {
+	if tr := ix.byTool[tool]; tr != nil {
+		return tr.all
+	}
+	return nil
+}
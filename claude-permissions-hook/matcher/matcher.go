@@ -2,6 +2,13 @@
 package matcher
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/asbjornb/claude-hooks/claude-permissions-hook/config"
@@ -15,28 +22,164 @@ const (
 	DecisionAllow       Decision = "allow"
 	DecisionDeny        Decision = "deny"
 	DecisionPassthrough Decision = "passthrough" // No rule matched, use default permissions
+	DecisionAsk         Decision = "ask"         // An allow rule with action = "ask" matched: prompt with its reason
 )
 
+// configDecisions maps decision settings like empty_command_decision to decisions
+var configDecisions = map[string]Decision{
+	"ask":   DecisionPassthrough,
+	"allow": DecisionAllow,
+	"deny":  DecisionDeny,
+}
+
 // MatchResult contains the result of matching and additional context
 type MatchResult struct {
 	Decision    Decision
 	Reason      string
 	MatchedRule string // Description of the rule that matched
+	Audit       *bool  // Per-rule audit override, nil to use the global audit level
 	Details     string // Additional details about what matched/didn't match
+	DocsURL     string // Policy documentation link of the matched deny rule
+	Suggestion  string // Allowed alternative suggested by the matched deny rule
+	Halt        bool   // The matched deny rule also stops Claude's turn
+	Templated   bool   // Reason was rendered from the rule's reason_template and stands on its own
+
+	// Subcommands holds the per-command decisions for compound statements
+	Subcommands []SubcommandResult
+
+	priority int // Priority of the rule that matched
+}
+
+// SubcommandResult is the decision for one command of a compound statement
+type SubcommandResult struct {
+	Command   string
+	Signature string
+	Decision  Decision
+	Rule      string // Description of the deciding rule, if any
 }
 
 // Matcher holds compiled configuration and provides matching methods
 type Matcher struct {
 	cfg     *config.Config
 	bashCfg config.BashConfigResolved
+	pathCfg config.PathConfigResolved
+	allow   []config.Rule // Active allow rules after tag and context filtering
+	deny    []config.Rule // Active deny rules after tag and context filtering
+
+	allowIndex ruleIndex // Active allow rules by tool and command name
+	denyIndex  ruleIndex // Active deny rules by tool and command name
+
+	inGitHook bool   // Whether the hook runs inside a git hook
+	cwd       string // Directory relative file paths are resolved against
+
+	trace io.Writer // Destination for matching traces, nil when disabled
 }
 
 // New creates a new Matcher with the given configuration
 func New(cfg *config.Config) *Matcher {
 	parser.SetSubcommandTools(cfg.SubcommandTools)
-	return &Matcher{
-		cfg:     cfg,
-		bashCfg: cfg.GetBashConfig(),
+	parser.SetSignatureDepths(cfg.SignatureDepth)
+	parser.SetAliases(cfg.Aliases)
+	runners := make([]parser.Runner, len(cfg.Runners))
+	for i, r := range cfg.Runners {
+		runners[i] = parser.Runner{Command: r.Command, Arg: r.Arg, Flag: r.Flag}
+	}
+	parser.SetRunners(runners)
+	parser.SetCacheSize(cfg.Settings.ParseCacheSize)
+	bashCfg := cfg.GetBashConfig()
+	parser.SetRemoteCommands(bashCfg.MatchRemoteCommands)
+	m := &Matcher{
+		cfg:       cfg,
+		bashCfg:   bashCfg,
+		pathCfg:   cfg.GetPathConfig(),
+		inGitHook: detectGitHook(cfg.Settings.GitHookEnvVars()),
+	}
+	m.SetTagFilter(nil, nil)
+	return m
+}
+
+// detectGitHook reports whether any of the given environment variables is set
+func detectGitHook(envVars []string) bool {
+	for _, name := range envVars {
+		if _, ok := os.LookupEnv(name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTagFilter restricts which rules participate in matching. Untagged rules
+// always apply. A tagged rule is skipped if it has any disabled tag, and, when
+// enable is non-empty, unless it has at least one enabled tag.
+func (m *Matcher) SetTagFilter(enable, disable []string) {
+	m.allow = m.filterRules(m.cfg.Allow, enable, disable)
+	m.deny = m.filterRules(m.cfg.Deny, enable, disable)
+	m.allowIndex = newRuleIndex(m.allow)
+	m.denyIndex = newRuleIndex(m.deny)
+}
+
+// SetCwd sets the directory relative file paths are resolved against,
+// normally the hook input's cwd
+func (m *Matcher) SetCwd(dir string) {
+	m.cwd = dir
+}
+
+// candidates returns the indices into ix.rules(tool) of the rules worth
+// evaluating for cmds. Tracing evaluates every rule so the trace shows why
+// each one didn't match.
+func (m *Matcher) candidates(ix ruleIndex, tool string, cmds []parser.ParsedCommand) []int {
+	if m.trace != nil {
+		return ix.all(tool)
+	}
+	return ix.candidates(tool, cmds)
+}
+
+// filterRules returns the rules that pass the tag filter and whose
+// in_git_hook condition matches the current context
+func (m *Matcher) filterRules(rules []config.Rule, enable, disable []string) []config.Rule {
+	var active []config.Rule
+	for _, rule := range rules {
+		if !rule.IsEnabled() {
+			continue
+		}
+		if rule.InGitHook != nil && *rule.InGitHook != m.inGitHook {
+			continue
+		}
+		if len(rule.Tags) == 0 {
+			active = append(active, rule)
+			continue
+		}
+		if hasAnyTag(rule.Tags, disable) {
+			continue
+		}
+		if len(enable) > 0 && !hasAnyTag(rule.Tags, enable) {
+			continue
+		}
+		active = append(active, rule)
+	}
+	return active
+}
+
+// hasAnyTag reports whether any of tags appears in set
+func hasAnyTag(tags, set []string) bool {
+	for _, tag := range tags {
+		for _, s := range set {
+			if tag == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseFailure returns the configured parse_failure decision for a command
+// the parser couldn't handle
+func (m *Matcher) parseFailure(reason string, err error) MatchResult {
+	setting := m.cfg.Settings.ParseFailureDecision()
+	return MatchResult{
+		Decision: configDecisions[setting],
+		Reason:   reason,
+		Details:  err.Error() + "; parse_failure: " + setting,
 	}
 }
 
@@ -44,12 +187,25 @@ func New(cfg *config.Config) *Matcher {
 // For compound commands (cmd1 && cmd2), ALL commands must be allowed for the result to be allow
 func (m *Matcher) MatchBashCommand(command string) MatchResult {
 	// Parse the shell command
-	stmt, err := parser.ParseShellCommand(command)
+	stmt, err := parser.ParseShellCommandCached(command)
 	if err != nil {
+		m.tracef("parse error: %v", err)
+		return m.parseFailure("Failed to parse command", err)
+	}
+	for _, cmd := range stmt.Commands {
+		m.tracef("command %q: signature %q", cmd.Raw, parser.CommandSignature(cmd))
+	}
+
+	if stmt.NestedError != nil {
+		return m.parseFailure("Failed to parse nested shell command", stmt.NestedError)
+	}
+
+	// Blank and comment-only commands get the configured decision
+	if stmt.IsEmpty {
 		return MatchResult{
-			Decision: DecisionPassthrough,
-			Reason:   "Failed to parse command",
-			Details:  err.Error(),
+			Decision: configDecisions[m.bashCfg.EmptyCommandDecision],
+			Reason:   "Empty command",
+			Details:  "empty_command_decision: " + m.bashCfg.EmptyCommandDecision,
 		}
 	}
 
@@ -65,12 +221,6 @@ func (m *Matcher) MatchBashCommand(command string) MatchResult {
 			Reason:   "Subshells are not allowed by config",
 		}
 	}
-	if !m.bashCfg.AllowBackground && stmt.HasBackground {
-		return MatchResult{
-			Decision: DecisionPassthrough,
-			Reason:   "Background commands are not allowed by config",
-		}
-	}
 	if !m.bashCfg.AllowRedirects && stmt.HasRedirect {
 		return MatchResult{
 			Decision: DecisionPassthrough,
@@ -83,43 +233,244 @@ func (m *Matcher) MatchBashCommand(command string) MatchResult {
 			Reason:   "Process substitution is not allowed by config",
 		}
 	}
+	if !m.bashCfg.AllowFindDelete && stmt.HasFindDelete {
+		return MatchResult{
+			Decision: DecisionPassthrough,
+			Reason:   "find -delete is not allowed by config",
+		}
+	}
 
-	// First, check deny rules on the full command and each subcommand
-	for _, rule := range m.cfg.Deny {
-		if rule.Tool != "Bash" {
-			continue
+	// Builtin checks run before any rule
+	if m.bashCfg.DenyBroadRecursivePermissions {
+		for _, cmd := range stmt.Commands {
+			if target, ok := checkRecursivePermissionChange(cmd); ok {
+				return MatchResult{
+					Decision:    DecisionDeny,
+					Reason:      "Recursive permission change on a broad target",
+					MatchedRule: "builtin: deny_broad_recursive_permissions",
+					Details:     "Target: " + target,
+				}
+			}
 		}
-		if match := m.matchBashRule(rule, command, stmt); match {
+	}
+
+	if m.bashCfg.BlockPipeToShell {
+		if source, shell, ok := checkPipeToShell(stmt.Commands, m.bashCfg.PipeToShellSources); ok {
 			return MatchResult{
 				Decision:    DecisionDeny,
-				Reason:      "Command matched deny rule",
-				MatchedRule: rule.Description,
+				Reason:      "Piping fetched content into a shell runs code nobody has reviewed",
+				MatchedRule: "builtin: block_pipe_to_shell",
+				Details:     fmt.Sprintf("%s output is piped into %s", source, shell),
 			}
 		}
 	}
 
-	// For compound commands, each individual command must be allowed
-	if len(stmt.Commands) > 1 {
+	if m.bashCfg.BlockGitConfigKeys {
 		for _, cmd := range stmt.Commands {
-			result := m.checkSingleCommand(cmd)
-			if result.Decision != DecisionAllow {
+			if setting, ok := checkGitConfig(cmd, m.bashCfg.GitConfigKeys); ok {
 				return MatchResult{
-					Decision: DecisionPassthrough,
-					Reason:   "Not all commands in compound statement are allowed",
-					Details:  "Command not allowed: " + cmd.Raw,
+					Decision:    DecisionDeny,
+					Reason:      "git command sets a config key that can run arbitrary commands",
+					MatchedRule: "builtin: block_git_config_keys",
+					Details:     "Config: " + setting,
+				}
+			}
+		}
+	}
+
+	if m.bashCfg.MatchRedirectPaths {
+		if deny := m.matchRedirects(stmt.Redirects); deny != nil {
+			return *deny
+		}
+	}
+
+	result := m.matchStatement("Bash", command, stmt)
+	if result.Decision != DecisionDeny && m.cfg.Settings.RestrictOutsideCwd {
+		for _, rdr := range stmt.Redirects {
+			if rdr.Writes() {
+				if restricted, ok := m.restrictOutsideCwd(rdr.Path); ok {
+					restricted.Subcommands = result.Subcommands
+					return restricted
+				}
+			}
+		}
+	}
+	if !m.bashCfg.AllowBackground && stmt.HasBackground && result.Decision != DecisionDeny {
+		// A background job outlives the tool call and any timeout on it
+		return MatchResult{
+			Decision:    configDecisions[m.bashCfg.BackgroundDecision],
+			Reason:      "Background commands are not allowed by config",
+			Details:     "background_decision: " + m.bashCfg.BackgroundDecision,
+			Subcommands: result.Subcommands,
+		}
+	}
+	if stmt.HasDynamicCommandName && result.Decision != DecisionDeny {
+		// The real command can't be known statically, so no allow rule can vouch for it
+		return MatchResult{
+			Decision:    configDecisions[m.bashCfg.DynamicCommandDecision],
+			Reason:      "Command name is built from a variable or command substitution",
+			Details:     "dynamic_command_decision: " + m.bashCfg.DynamicCommandDecision,
+			Subcommands: result.Subcommands,
+		}
+	}
+	return result
+}
+
+// matchStatement checks a parsed statement against the tool's allow and deny
+// rules. Any denied command denies the statement, and every command must be
+// allowed for the statement to be allowed (in compound_mode any_reached,
+// every command but || fallbacks).
+func (m *Matcher) matchStatement(tool, command string, stmt *parser.ShellStatement) MatchResult {
+	// Resolve the best allow rule for each command up front so deny rules
+	// can be weighed against them by priority
+	allowed := make([]MatchResult, len(stmt.Commands))
+	for i, cmd := range stmt.Commands {
+		allowed[i] = m.checkSingleCommand(tool, command, cmd)
+	}
+
+	// Check deny rules on the full command and each subcommand. A deny only
+	// loses if every command it matched is allowed with a higher priority.
+	var deny *config.Rule
+	var denyPattern string // The deny rule's command pattern that matched, if any
+	denied := make([]*config.Rule, len(stmt.Commands))
+	denyRules := m.denyIndex.rules(tool)
+	for _, i := range m.candidates(m.denyIndex, tool, stmt.Commands) {
+		rule := &denyRules[i]
+		matched, pattern, ok := matchCommandRule(*rule, command, stmt)
+		if !ok {
+			m.tracef("%s: no match", ruleName("deny", *rule))
+			continue
+		}
+		if allowOverrides(allowed, matched, rule.Priority) {
+			m.tracef("%s: matched, overridden by higher-priority allow rules", ruleName("deny", *rule))
+			continue
+		}
+		m.tracef("%s: matched", ruleName("deny", *rule))
+		for _, idx := range matched {
+			if denied[idx] == nil {
+				denied[idx] = rule
+			}
+		}
+		if deny == nil || rule.Priority > deny.Priority {
+			deny, denyPattern = rule, pattern
+		}
+	}
+
+	subcommands := subcommandBreakdown(tool, stmt, allowed, denied)
+
+	if deny != nil {
+		details := describeSubcommands(subcommands)
+		if denyPattern != "" {
+			details = "matched pattern: " + denyPattern + "; " + details
+		}
+		result := MatchResult{
+			Decision:    DecisionDeny,
+			Reason:      "Command matched deny rule",
+			MatchedRule: deny.Description,
+			Audit:       deny.Audit,
+			DocsURL:     deny.DocsURL,
+			Suggestion:  deny.Suggestion,
+			Halt:        deny.Halt,
+			Details:     details,
+			Subcommands: subcommands,
+		}
+		// Name the first command the rule denied, so Claude knows which
+		// part of a chain to drop; a rule that only matched the whole
+		// statement names the statement
+		cmdText, sig := command, ""
+		for i, rule := range denied {
+			if rule == deny {
+				cmdText, sig = stmt.Commands[i].Raw, commandSignature(tool, stmt.Commands[i])
+				if len(stmt.Commands) > 1 {
+					result.Reason = "Compound command denied: " + cmdText + " matched deny rule"
+				}
+				break
+			}
+		}
+		applyTemplate(*deny, &result, cmdText, sig)
+		return result
+	}
+
+	// For compound commands, each individual command must be allowed. Deny
+	// rules above still cover fallbacks skipped here.
+	if len(stmt.Commands) > 1 {
+		anyReached := m.cfg.Settings.CompoundModeResolved() == config.CompoundAnyReached
+		var audit *bool
+		var notAllowed, skipped []string
+		ask := -1 // The first command an ask rule matched
+		for i, cmd := range stmt.Commands {
+			result := allowed[i]
+			if !permits(result.Decision) {
+				if anyReached && isFallback(stmt.Commands, i) {
+					skipped = append(skipped, cmd.Raw)
+					continue
 				}
+				notAllowed = append(notAllowed, cmd.Raw)
+				continue
+			}
+			if result.Decision == DecisionAsk && ask < 0 {
+				ask = i
+			}
+			// A forced audit on any subcommand's rule wins
+			if result.Audit != nil && (audit == nil || *result.Audit) {
+				audit = result.Audit
+			}
+		}
+		if len(notAllowed) > 0 {
+			result := m.unmatched(tool, MatchResult{
+				Decision:    DecisionPassthrough,
+				Reason:      "Not all commands in compound statement are allowed",
+				Details:     "Commands not allowed: " + strings.Join(notAllowed, ", ") + "; " + describeSubcommands(subcommands),
+				Subcommands: subcommands,
+			})
+			if result.Decision == DecisionAllow && ask >= 0 {
+				// The defaults allow the rest, but a rule still asks
+				result.Decision = DecisionAsk
+				result.Reason = "Compound command requires confirmation: " + stmt.Commands[ask].Raw
+				result.MatchedRule = allowed[ask].MatchedRule
+			}
+			return result
+		}
+
+		if ask >= 0 {
+			// Allowed, but one of the commands needs confirmation
+			result := allowed[ask]
+			if !result.Templated {
+				result.Reason = "Compound command requires confirmation: " + stmt.Commands[ask].Raw
+			}
+			result.Audit = audit
+			result.Subcommands = subcommands
+			return result
+		}
+
+		if len(skipped) > 0 {
+			// A skipped fallback still runs whenever its guard fails, so
+			// confirm rather than allow
+			m.tracef("compound_mode any_reached: skipping fallbacks %s", strings.Join(skipped, ", "))
+			return MatchResult{
+				Decision:    DecisionAsk,
+				Reason:      "Compound command has fallbacks no rule allows",
+				Audit:       audit,
+				Details:     "Fallbacks not allowed: " + strings.Join(skipped, ", ") + "; " + describeSubcommands(subcommands),
+				Subcommands: subcommands,
 			}
 		}
+
 		// All commands allowed
 		return MatchResult{
-			Decision: DecisionAllow,
-			Reason:   "All commands in compound statement are allowed",
+			Decision:    DecisionAllow,
+			Reason:      "All commands in compound statement are allowed",
+			Audit:       audit,
+			Subcommands: subcommands,
 		}
 	}
 
-	// Single command - check allow rules
+	// Single command - use its allow result
 	if len(stmt.Commands) == 1 {
-		return m.checkSingleCommand(stmt.Commands[0])
+		if allowed[0].Decision == DecisionPassthrough {
+			return m.unmatched(tool, allowed[0])
+		}
+		return allowed[0]
 	}
 
 	return MatchResult{
@@ -128,39 +479,103 @@ func (m *Matcher) MatchBashCommand(command string) MatchResult {
 	}
 }
 
-// checkSingleCommand checks a single parsed command against allow rules
-func (m *Matcher) checkSingleCommand(cmd parser.ParsedCommand) MatchResult {
-	sig := parser.CommandSignature(cmd)
+// isFallback reports whether commands[i] is a || fallback that only runs
+// when the command before it fails, as echo in "test -f x || echo missing".
+// Only the simple case counts: a fallback piped onward, or one that came from
+// a nested script or whose name is dynamic, must still be allowed. So must
+// one after a command that always fails, as in "false || curl x", since it
+// always runs.
+func isFallback(commands []parser.ParsedCommand, i int) bool {
+	if i == 0 || commands[i-1].Operator != "||" || alwaysFails(commands[i-1]) {
+		return false
+	}
+	cmd := commands[i]
+	switch cmd.Operator {
+	case "|", "|&", "&":
+		return false
+	}
+	return !cmd.Nested && !cmd.IsRemote && !cmd.DynamicName
+}
 
-	for _, rule := range m.cfg.Allow {
-		if rule.Tool != "Bash" {
-			continue
+// alwaysFails reports whether a command fails no matter what: false, a
+// negated command (! true), or test and [ with nothing to test
+func alwaysFails(cmd parser.ParsedCommand) bool {
+	if cmd.Negated {
+		return true
+	}
+	switch parser.GetCommandName(cmd) {
+	case "false":
+		return true
+	case "test":
+		return len(cmd.Args) == 1
+	case "[":
+		return len(cmd.Args) == 2 && cmd.Args[1] == "]"
+	}
+	return false
+}
+
+// subcommandBreakdown returns each command's individual decision for compound
+// statements, or nil for a single command
+func subcommandBreakdown(tool string, stmt *parser.ShellStatement, allowed []MatchResult, denied []*config.Rule) []SubcommandResult {
+	if len(stmt.Commands) < 2 {
+		return nil
+	}
+	breakdown := make([]SubcommandResult, len(stmt.Commands))
+	for i, cmd := range stmt.Commands {
+		decision, rule := allowed[i].Decision, allowed[i].MatchedRule
+		if denied[i] != nil {
+			decision, rule = DecisionDeny, denied[i].Description
 		}
+		breakdown[i] = SubcommandResult{
+			Command:   cmd.Raw,
+			Signature: commandSignature(tool, cmd),
+			Decision:  decision,
+			Rule:      rule,
+		}
+	}
+	return breakdown
+}
 
-		// Check explicit command list first (most specific)
-		for _, allowedCmd := range rule.Commands {
-			if matchCommandSignature(allowedCmd, sig, cmd) {
-				return MatchResult{
-					Decision:    DecisionAllow,
-					Reason:      "Command matches allowed signature",
-					MatchedRule: rule.Description,
-					Details:     "Matched: " + allowedCmd,
-				}
-			}
+// describeSubcommands renders a per-command breakdown for Details, e.g.
+// "git add -A: allow (Git), ./deploy.sh: passthrough"
+func describeSubcommands(subcommands []SubcommandResult) string {
+	parts := make([]string, len(subcommands))
+	for i, sub := range subcommands {
+		parts[i] = sub.Command + ": " + string(sub.Decision)
+		if sub.Rule != "" {
+			parts[i] += " (" + sub.Rule + ")"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// checkSingleCommand checks a single parsed command of the statement
+// command against allow rules, returning the highest-priority match
+// (earliest rule wins ties)
+func (m *Matcher) checkSingleCommand(tool, command string, cmd parser.ParsedCommand) MatchResult {
+	sig := commandSignature(tool, cmd)
+
+	var best *MatchResult
+	allowRules := m.allowIndex.rules(tool)
+	for _, i := range m.candidates(m.allowIndex, tool, []parser.ParsedCommand{cmd}) {
+		rule := allowRules[i]
+		if outranks(best, rule) {
+			m.tracef("%s: skipped for %q, a higher-priority allow already matched", ruleName("allow", rule), sig)
+			continue
 		}
 
-		// Check regex patterns
-		for _, re := range rule.GetCompiledCommandPatterns() {
-			if re.MatchString(cmd.Raw) {
-				return MatchResult{
-					Decision:    DecisionAllow,
-					Reason:      "Command matches allowed pattern",
-					MatchedRule: rule.Description,
-				}
-			}
+		if result, ok := matchAllowRule(rule, sig, command, cmd); ok {
+			m.tracef("%s: matched %q", ruleName("allow", rule), sig)
+			best = &result
+		} else {
+			m.tracef("%s: no match for %q", ruleName("allow", rule), sig)
 		}
 	}
 
+	if best != nil {
+		return *best
+	}
+
 	return MatchResult{
 		Decision: DecisionPassthrough,
 		Reason:   "No allow rule matched",
@@ -168,13 +583,122 @@ func (m *Matcher) checkSingleCommand(cmd parser.ParsedCommand) MatchResult {
 	}
 }
 
+// matchAllowRule checks a single command of the statement fullCmd against
+// one allow rule
+func matchAllowRule(rule config.Rule, sig, fullCmd string, cmd parser.ParsedCommand) (MatchResult, bool) {
+	if allowBlocker(rule, fullCmd, cmd) != "" {
+		return MatchResult{}, false
+	}
+	text := patternText(rule, fullCmd, cmd)
+
+	// Check explicit command list first (most specific)
+	for _, allowedCmd := range rule.Commands {
+		if matchSignature(rule.Tool, allowedCmd, sig, cmd) {
+			result := MatchResult{
+				Decision:    DecisionAllow,
+				Reason:      "Command matches allowed signature",
+				MatchedRule: rule.Description,
+				Audit:       rule.Audit,
+				Details:     "Matched: " + allowedCmd,
+				priority:    rule.Priority,
+			}
+			applyAction(rule, &result)
+			applyTemplate(rule, &result, cmd.Raw, sig)
+			return result, true
+		}
+	}
+
+	// Check regex patterns
+	for _, re := range rule.GetCompiledCommandPatterns() {
+		if re.MatchString(text) {
+			result := MatchResult{
+				Decision:    DecisionAllow,
+				Reason:      "Command matches allowed pattern",
+				MatchedRule: rule.Description,
+				Audit:       rule.Audit,
+				priority:    rule.Priority,
+			}
+			applyAction(rule, &result)
+			applyTemplate(rule, &result, cmd.Raw, sig)
+			return result, true
+		}
+	}
+
+	return MatchResult{}, false
+}
+
+// allowBlocker returns what keeps an allow rule from vouching for a command
+// its commands or patterns might match, or "" if nothing does
+func allowBlocker(rule config.Rule, fullCmd string, cmd parser.ParsedCommand) string {
+	switch {
+	case hasDeniedEnv(rule, cmd):
+		return "a denied_env variable is set"
+	case hasDeniedFlags(rule, cmd):
+		return "it has every flag of a denied_flag_sets entry"
+	case len(rule.DirPatterns) > 0 && !matchesDir(rule, cmd):
+		return "its repository doesn't match dir_patterns"
+	case len(rule.PathPatterns) > 0 && !matchesScript(rule, cmd):
+		return "it doesn't run a script matching path_patterns"
+	case excludedCommand(rule, patternText(rule, fullCmd, cmd)):
+		return "it matches exclude_patterns"
+	}
+	return ""
+}
+
+// patternText returns the text a rule's patterns match: the command's own
+// text unless the rule asks for the whole statement
+func patternText(rule config.Rule, fullCmd string, cmd parser.ParsedCommand) string {
+	if patternScope(rule, config.ScopeCommand) == config.ScopeStatement {
+		return fullCmd
+	}
+	return cmd.Raw
+}
+
+// allowOverrides reports whether every matched command is allowed with a
+// priority strictly higher than the deny priority
+func allowOverrides(allowed []MatchResult, matched []int, denyPriority int) bool {
+	if len(matched) == 0 {
+		return false
+	}
+	for _, i := range matched {
+		if !permits(allowed[i].Decision) || allowed[i].priority <= denyPriority {
+			return false
+		}
+	}
+	return true
+}
+
+// commandSignature returns a command's signature in the tool's shell
+func commandSignature(tool string, cmd parser.ParsedCommand) string {
+	if tool == "PowerShell" {
+		return parser.PowerShellSignature(cmd)
+	}
+	return parser.CommandSignature(cmd)
+}
+
+// matchSignature checks a rule's command entry against a command in the
+// tool's shell
+func matchSignature(tool, pattern, sig string, cmd parser.ParsedCommand) bool {
+	if tool == "PowerShell" {
+		return matchCmdlet(pattern, cmd)
+	}
+	return matchCommandSignature(pattern, sig, cmd)
+}
+
 // matchCommandSignature checks if a command matches an allowed signature
 func matchCommandSignature(pattern, sig string, cmd parser.ParsedCommand) bool {
+	pattern = parser.NormalizeSignature(pattern)
+
 	// Exact signature match
 	if pattern == sig {
 		return true
 	}
 
+	// Embedded wildcards (e.g., "docker * rm")
+	if words := strings.Fields(pattern); slices.Contains(words[:max(len(words)-1, 0)], "*") {
+		return matchWildcardSignature(words, strings.Fields(sig))
+	}
+
 	// Pattern with wildcard (e.g., "git *" matches any git command)
 	if strings.HasSuffix(pattern, " *") {
 		prefix := strings.TrimSuffix(pattern, " *")
@@ -182,7 +706,7 @@ func matchCommandSignature(pattern, sig string, cmd parser.ParsedCommand) bool {
 			return true
 		}
 		// Also check command name directly
-		cmdName := parser.GetCommandName(cmd)
+		cmdName := parser.GetCommandName(parser.NormalizeCommand(cmd))
 		if cmdName == prefix {
 			return true
 		}
@@ -197,7 +721,7 @@ func matchCommandSignature(pattern, sig string, cmd parser.ParsedCommand) bool {
 
 	// Just command name (e.g., "ls" matches "ls -la")
 	if !strings.Contains(pattern, " ") {
-		cmdName := parser.GetCommandName(cmd)
+		cmdName := parser.GetCommandName(parser.NormalizeCommand(cmd))
 		if pattern == cmdName {
 			return true
 		}
@@ -206,118 +730,887 @@ func matchCommandSignature(pattern, sig string, cmd parser.ParsedCommand) bool {
 	return false
 }
 
-// matchBashRule checks if a command matches a deny rule
-func (m *Matcher) matchBashRule(rule config.Rule, fullCmd string, stmt *parser.ShellStatement) bool {
-	// Check regex patterns against full command
+// matchWildcardSignature matches a signature against pattern words where a
+// "*" before the last word stands for exactly one signature word, so
+// "docker * rm" matches "docker container rm" and "docker image rm" but not
+// "docker rm". A trailing "*" stands for any number of words, including
+// none. Like other multi-word patterns, the pattern matches signatures it's
+// a prefix of.
+func matchWildcardSignature(pattern, sig []string) bool {
+	if pattern[len(pattern)-1] == "*" {
+		pattern = pattern[:len(pattern)-1]
+	}
+	if len(sig) < len(pattern) {
+		return false
+	}
+	for i, word := range pattern {
+		if word != "*" && word != sig[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// matchCommandRule checks if a command matches a deny rule, returning the
+// indices of the matched commands (all of them when a pattern matches the full
+// command) and the command pattern that matched, if any
+func matchCommandRule(rule config.Rule, fullCmd string, stmt *parser.ShellStatement) ([]int, string, bool) {
+	scope := patternScope(rule, config.ScopeStatement)
+	matched, pattern := matchCommands(rule, fullCmd, stmt, scope)
+	if len(rule.DeniedEnv) > 0 || len(rule.DeniedFlagSets) > 0 || len(rule.DirPatterns) > 0 || len(rule.PathPatterns) > 0 {
+		// denied_env, denied_flag_sets, dir_patterns and path_patterns
+		// narrow the rule to commands run with a matching assignment, flags,
+		// directory or script; on their own they apply to every command
+		if len(rule.Commands) == 0 && len(rule.CommandPatterns) == 0 {
+			matched = make([]int, len(stmt.Commands))
+			for i := range matched {
+				matched[i] = i
+			}
+		}
+		var narrowed []int
+		for _, i := range matched {
+			cmd := stmt.Commands[i]
+			if len(rule.DeniedEnv) > 0 && !hasDeniedEnv(rule, cmd) {
+				continue
+			}
+			if len(rule.DeniedFlagSets) > 0 && !hasDeniedFlags(rule, cmd) {
+				continue
+			}
+			if len(rule.DirPatterns) > 0 && !matchesDir(rule, cmd) {
+				continue
+			}
+			if len(rule.PathPatterns) > 0 && !matchesScript(rule, cmd) {
+				continue
+			}
+			narrowed = append(narrowed, i)
+		}
+		matched = narrowed
+	}
+
+	// Exclusions spare only the commands they match, in either scope, so
+	// "rm -i x; rm -rf /" can't hide behind an exclusion for rm -i. A
+	// pattern that matched the whole statement must still match without
+	// the excluded commands.
+	var kept []int
+	for _, i := range matched {
+		if !excludedCommand(rule, stmt.Commands[i].Raw) {
+			kept = append(kept, i)
+		}
+	}
+	if len(kept) < len(matched) && pattern != "" && scope == config.ScopeStatement && !matchesRemaining(rule, stmt, kept) {
+		kept = nil
+	}
+	return kept, pattern, len(kept) > 0
+}
+
+// matchesRemaining reports whether a rule's command_patterns match the
+// statement text made of just the commands at indices, joined by their
+// operators
+func matchesRemaining(rule config.Rule, stmt *parser.ShellStatement, indices []int) bool {
+	var b strings.Builder
+	for _, i := range indices {
+		cmd := stmt.Commands[i]
+		b.WriteString(cmd.Raw)
+		if cmd.Operator != "" {
+			b.WriteString(" " + cmd.Operator + " ")
+		}
+	}
+	text := b.String()
 	for _, re := range rule.GetCompiledCommandPatterns() {
-		if re.MatchString(fullCmd) {
+		if re.MatchString(text) {
 			return true
 		}
 	}
+	return false
+}
 
-	// Check command signatures against deny list
-	for _, cmd := range stmt.Commands {
-		sig := parser.CommandSignature(cmd)
-		for _, deniedCmd := range rule.Commands {
-			if matchCommandSignature(deniedCmd, sig, cmd) {
-				return true
+// matchCommands returns the indices of the commands matched by a rule's
+// commands and command_patterns, and the source of the command pattern that
+// matched, if any. In statement scope a pattern matching the full command
+// matches all of them; in command scope patterns match each command's text.
+func matchCommands(rule config.Rule, fullCmd string, stmt *parser.ShellStatement, scope string) ([]int, string) {
+	// Check regex patterns against full command
+	if scope == config.ScopeStatement {
+		for _, re := range rule.GetCompiledCommandPatterns() {
+			if re.MatchString(fullCmd) {
+				all := make([]int, len(stmt.Commands))
+				for i := range all {
+					all[i] = i
+				}
+				return all, re.String()
+			}
+		}
+	}
+
+	// Check command signatures (and per-command patterns) against deny list
+	var matched []int
+	var pattern string
+	for i, cmd := range stmt.Commands {
+		if slices.ContainsFunc(rule.Commands, func(deniedCmd string) bool {
+			return slices.ContainsFunc(wrappedCommands(rule.Tool, cmd), func(c parser.ParsedCommand) bool {
+				return matchSignature(rule.Tool, deniedCmd, commandSignature(rule.Tool, c), c)
+			})
+		}) {
+			matched = append(matched, i)
+			continue
+		}
+		if scope != config.ScopeCommand {
+			continue
+		}
+		for _, re := range rule.GetCompiledCommandPatterns() {
+			if re.MatchString(cmd.Raw) {
+				matched = append(matched, i)
+				if pattern == "" {
+					pattern = re.String()
+				}
+				break
 			}
 		}
 	}
+	return matched, pattern
+}
+
+// wrappedCommands returns a command along with the command it wraps, if
+// any, so a deny entry for rm also matches time rm and sudo timeout 5 rm
+func wrappedCommands(tool string, cmd parser.ParsedCommand) []parser.ParsedCommand {
+	if tool == "PowerShell" {
+		return []parser.ParsedCommand{cmd}
+	}
+	inner := parser.UnwrapCommand(cmd)
+	if len(inner.Args) == len(cmd.Args) {
+		return []parser.ParsedCommand{cmd}
+	}
+	return []parser.ParsedCommand{cmd, inner}
+}
+
+// patternScope returns what a rule's command_patterns and exclude_patterns
+// are matched against: its pattern_scope, or def when it has none
+func patternScope(rule config.Rule, def string) string {
+	if rule.PatternScope == "" {
+		return def
+	}
+	return rule.PatternScope
+}
+
+// excludedCommand reports whether text matches one of the rule's
+// exclude_patterns
+func excludedCommand(rule config.Rule, text string) bool {
+	for _, re := range rule.GetCompiledExcludePatterns() {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesDir reports whether a command targets a directory (git -C) that
+// matches the rule's dir_patterns
+func matchesDir(rule config.Rule, cmd parser.ParsedCommand) bool {
+	if cmd.Dir == "" {
+		return false
+	}
+	for _, re := range rule.GetCompiledDirPatterns() {
+		if re.MatchString(cmd.Dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesScript reports whether a command runs a script file (bash
+// deploy.sh) that matches the rule's path_patterns
+func matchesScript(rule config.Rule, cmd parser.ParsedCommand) bool {
+	if cmd.Script == "" {
+		return false
+	}
+	for _, re := range rule.GetCompiledPathPatterns() {
+		if re.MatchString(cmd.Script) {
+			return true
+		}
+	}
+	return false
+}
 
+// hasDeniedFlags reports whether a command has every flag of one of the
+// rule's denied_flag_sets
+func hasDeniedFlags(rule config.Rule, cmd parser.ParsedCommand) bool {
+	for _, set := range rule.DeniedFlagSets {
+		if parser.HasCombinedFlags(cmd, set) {
+			return true
+		}
+	}
 	return false
 }
 
+// hasDeniedEnv reports whether any of a command's assignments has a name
+// matching the rule's denied_env patterns
+func hasDeniedEnv(rule config.Rule, cmd parser.ParsedCommand) bool {
+	for _, assignment := range cmd.Env {
+		name := parser.EnvName(assignment)
+		for _, re := range rule.GetCompiledDeniedEnv() {
+			if re.MatchString(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadRange describes the portion of a file requested by the Read tool
+type ReadRange struct {
+	Offset int
+	Limit  int // 0 means the whole file
+}
+
 // MatchFilePath checks a file path against rules for Read/Write/Edit operations
 func (m *Matcher) MatchFilePath(toolName, filePath string) MatchResult {
-	// Check deny rules first
-	for _, rule := range m.cfg.Deny {
-		if rule.Tool != toolName {
+	return m.matchFilePath(toolName, filePath, ReadRange{}, nil)
+}
+
+// MatchRead checks a Read operation against rules, taking the requested range into account
+func (m *Matcher) MatchRead(filePath string, readRange ReadRange) MatchResult {
+	return m.matchFilePath("Read", filePath, readRange, nil)
+}
+
+// MatchSearch checks the path searched by a Grep or Glob operation against
+// rules for the tool, the same way as a Read path
+func (m *Matcher) MatchSearch(toolName, path string) MatchResult {
+	return m.matchFilePath(toolName, path, ReadRange{}, nil)
+}
+
+// MatchFileWrite checks a Write or Edit operation against rules, matching
+// content_patterns against the text being written or replaced
+func (m *Matcher) MatchFileWrite(toolName, filePath string, content []string) MatchResult {
+	return m.matchFilePath(toolName, filePath, ReadRange{}, content)
+}
+
+// matchFilePath checks a file path and content against rules for the given tool
+func (m *Matcher) matchFilePath(toolName, filePath string, readRange ReadRange, content []string) MatchResult {
+	if m.pathCfg.ExpandPaths {
+		if expanded := expandPath(filePath, m.cwd); expanded != filePath {
+			m.tracef("expanded path %q to %q", filePath, expanded)
+			filePath = expanded
+		}
+	}
+
+	deny := m.denyFilePath(toolName, filePath, content)
+	if deny == nil && (toolName == "Write" || toolName == "Edit") && m.cfg.Settings.RestrictOutsideCwd {
+		if restricted, ok := m.restrictOutsideCwd(filePath); ok {
+			return restricted
+		}
+	}
+
+	// Find the highest-priority allow rule
+	var allow *MatchResult
+	for _, rule := range m.allowIndex.rules(toolName) {
+		if outranks(allow, rule) {
 			continue
 		}
 
-		// Check path patterns
-		for _, re := range rule.GetCompiledPathPatterns() {
-			if re.MatchString(filePath) {
-				return MatchResult{
-					Decision:    DecisionDeny,
-					Reason:      "Path matched deny rule",
-					MatchedRule: rule.Description,
-				}
+		// Unbounded reads don't satisfy rules that require a limit
+		if rule.RequireLimit && readRange.Limit <= 0 {
+			m.tracef("%s: skipped, read has no limit", ruleName("allow", rule))
+			continue
+		}
+
+		if _, ok := m.matchFileRule("allow", rule, filePath, content); ok {
+			allow = &MatchResult{
+				Decision:    DecisionAllow,
+				Reason:      "Path matched allow pattern",
+				MatchedRule: rule.Description,
+				Audit:       rule.Audit,
+				priority:    rule.Priority,
 			}
+			applyAction(rule, allow)
+			applyTemplate(rule, allow, filePath, filePath)
 		}
 	}
 
-	// Check allow rules
-	for _, rule := range m.cfg.Allow {
-		if rule.Tool != toolName {
+	return resolve(deny, allow, m.unmatched(toolName, MatchResult{
+		Decision: DecisionPassthrough,
+		Reason:   "No rule matched for path",
+	}))
+}
+
+// denyFilePath returns the highest-priority deny rule match for a file
+// path and content, or nil if no deny rule matches
+func (m *Matcher) denyFilePath(toolName, filePath string, content []string) *MatchResult {
+	var deny *MatchResult
+	for _, rule := range m.denyIndex.rules(toolName) {
+		if deny != nil && rule.Priority <= deny.priority {
 			continue
 		}
 
-		// Check path patterns
-		for _, re := range rule.GetCompiledPathPatterns() {
-			if re.MatchString(filePath) {
-				// Check exclude patterns
-				excluded := false
-				for _, excl := range rule.GetCompiledPathExclude() {
-					if excl.MatchString(filePath) {
-						excluded = true
-						break
-					}
+		if patterns, ok := m.matchFileRule("deny", rule, filePath, content); ok {
+			deny = &MatchResult{
+				Decision:    DecisionDeny,
+				Reason:      "Path matched deny rule",
+				MatchedRule: rule.Description,
+				Audit:       rule.Audit,
+				DocsURL:     rule.DocsURL,
+				Suggestion:  rule.Suggestion,
+				Halt:        rule.Halt,
+				Details:     patterns,
+				priority:    rule.Priority,
+			}
+			if len(rule.ContentPatterns) > 0 {
+				deny.Reason = "Content matched deny rule"
+			}
+			applyTemplate(rule, deny, filePath, filePath)
+		}
+	}
+	return deny
+}
+
+// matchRedirects checks the files a command redirects from or to against
+// the Read and Write deny rules, as if the Read or Write tool had been used
+// on them. It returns the first deny, or nil.
+func (m *Matcher) matchRedirects(redirects []parser.Redirect) *MatchResult {
+	for _, rdr := range redirects {
+		filePath := rdr.Path
+		if m.pathCfg.ExpandPaths {
+			filePath = expandPath(filePath, m.cwd)
+		}
+		for _, tool := range redirectTools(rdr) {
+			if deny := m.denyFilePath(tool, filePath, nil); deny != nil {
+				m.tracef("redirect %s %s: denied as %s", rdr.Op, rdr.Path, tool)
+				if !deny.Templated {
+					deny.Reason = "Redirect target matched " + tool + " deny rule"
 				}
-				if !excluded {
-					return MatchResult{
-						Decision:    DecisionAllow,
-						Reason:      "Path matched allow pattern",
-						MatchedRule: rule.Description,
-					}
+				deny.Details = "redirect " + rdr.Op + " " + rdr.Path + "; " + deny.Details
+				return deny
+			}
+		}
+	}
+	return nil
+}
+
+// redirectTools returns the file tools whose deny rules apply to a redirect
+func redirectTools(rdr parser.Redirect) []string {
+	var tools []string
+	if rdr.Reads() {
+		tools = append(tools, "Read")
+	}
+	if rdr.Writes() {
+		tools = append(tools, "Write")
+	}
+	return tools
+}
+
+// matchFileRule checks a rule's path and content patterns. Each kind of
+// pattern the rule has must match; a rule with neither never matches. Path
+// exclusions only apply to allow rules. On a match it also describes the
+// patterns that matched, e.g. "matched pattern: \.env$".
+func (m *Matcher) matchFileRule(kind string, rule config.Rule, filePath string, content []string) (string, bool) {
+	pathPatterns := rule.GetCompiledPathPatterns()
+	contentPatterns := rule.GetCompiledContentPatterns()
+	if len(pathPatterns) == 0 && len(rule.PathGlobs) == 0 && len(contentPatterns) == 0 {
+		return "", false
+	}
+
+	var matchedPatterns []string
+	if len(pathPatterns) > 0 || len(rule.PathGlobs) > 0 {
+		path := m.rulePath(rule, filePath)
+		if m.trace != nil {
+			// Runs for every file rule, so skip building the arguments
+			m.tracef("%s: checking path %q", ruleName(kind, rule), path)
+		}
+		matched := false
+		for _, re := range pathPatterns {
+			if !re.MatchString(path) {
+				continue
+			}
+			if excl := excludedBy(rule, path); kind == "allow" && excl != "" {
+				m.tracef("%s: pattern %q matched but excluded by %q", ruleName(kind, rule), re.String(), excl)
+				continue
+			}
+			m.tracef("%s: matched pattern %q", ruleName(kind, rule), re.String())
+			matchedPatterns = append(matchedPatterns, "matched pattern: "+re.String())
+			matched = true
+			break
+		}
+		if glob, ok := rule.MatchPathGlobs(path); ok && !matched {
+			if excl := excludedBy(rule, path); kind == "allow" && excl != "" {
+				m.tracef("%s: glob %q matched but excluded by %q", ruleName(kind, rule), glob, excl)
+			} else {
+				m.tracef("%s: matched glob %q", ruleName(kind, rule), glob)
+				matchedPatterns = append(matchedPatterns, "matched glob: "+glob)
+				matched = true
+			}
+		}
+		if !matched {
+			return "", false
+		}
+	}
+
+	if len(contentPatterns) > 0 {
+		for _, re := range contentPatterns {
+			for _, text := range content {
+				if re.MatchString(text) {
+					m.tracef("%s: matched content pattern %q", ruleName(kind, rule), re.String())
+					matchedPatterns = append(matchedPatterns, "matched content pattern: "+re.String())
+					return strings.Join(matchedPatterns, "; "), true
 				}
 			}
 		}
+		return "", false
 	}
 
+	return strings.Join(matchedPatterns, "; "), true
+}
+
+// excludedBy returns the rule's first path exclude pattern matching path, or
+// "" if none does
+func excludedBy(rule config.Rule, path string) string {
+	for _, excl := range rule.GetCompiledPathExclude() {
+		if excl.MatchString(path) {
+			return excl.String()
+		}
+	}
+	return ""
+}
+
+// deviceFiles are write targets that aren't files in any workspace, such
+// as the common > /dev/null
+var deviceFiles = map[string]bool{
+	"/dev/null":   true,
+	"/dev/stdout": true,
+	"/dev/stderr": true,
+	"/dev/tty":    true,
+}
+
+// restrictOutsideCwd returns the outside_cwd_decision for a write to a path
+// outside the working directory, or false when the path is inside it. A
+// path is only judged when both it and the working directory are known, so
+// there's no answer without a cwd or for ~user and Windows paths. Symlinks
+// aren't resolved.
+func (m *Matcher) restrictOutsideCwd(path string) (MatchResult, bool) {
+	if m.cwd == "" || !filepath.IsAbs(m.cwd) {
+		return MatchResult{}, false
+	}
+	abs := expandPath(path, m.cwd)
+	if !filepath.IsAbs(abs) || deviceFiles[abs] || strings.HasPrefix(abs, "/dev/fd/") {
+		return MatchResult{}, false
+	}
+	cwd := filepath.Clean(m.cwd)
+	if abs == cwd || strings.HasPrefix(abs, strings.TrimSuffix(cwd, "/")+"/") {
+		return MatchResult{}, false
+	}
+	decision := m.cfg.Settings.OutsideCwdDecisionResolved()
+	m.tracef("restrict_outside_cwd: %q is outside %q", abs, cwd)
 	return MatchResult{
-		Decision: DecisionPassthrough,
-		Reason:   "No rule matched for path",
+		Decision:    configDecisions[decision],
+		Reason:      "Write target is outside the working directory",
+		MatchedRule: "setting: restrict_outside_cwd",
+		Details:     fmt.Sprintf("Target: %s; cwd: %s; outside_cwd_decision: %s", abs, cwd, decision),
+	}, true
+}
+
+// expandPath expands a leading ~ to the home directory and resolves a
+// relative path against cwd, cleaning . and .. out of the result. Paths it
+// can't resolve (no home or cwd, ~user) and Windows paths are left as is.
+func expandPath(path, cwd string) string {
+	if path == "" || isWindowsPath(path) {
+		return path
+	}
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return path
+		}
+		return filepath.Join(home, path[1:])
+	}
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
 	}
+	if strings.HasPrefix(path, "~") || cwd == "" {
+		return path
+	}
+	return filepath.Join(cwd, path)
+}
+
+// isWindowsPath reports whether a path has a drive letter (C:) or starts with
+// a backslash, which the host's path functions may not recognize
+func isWindowsPath(path string) bool {
+	if strings.HasPrefix(path, "\\") {
+		return true
+	}
+	return len(path) >= 2 && path[1] == ':' &&
+		('a' <= path[0] && path[0] <= 'z' || 'A' <= path[0] && path[0] <= 'Z')
+}
+
+// rulePath returns the path as a rule should see it. Backslash separators are
+// normalized to forward slashes unless disabled in [paths]; case-insensitive
+// rules target Windows and always normalize.
+func (m *Matcher) rulePath(rule config.Rule, filePath string) string {
+	if m.pathCfg.NormalizeSeparators || rule.CaseInsensitive {
+		return strings.ReplaceAll(filePath, "\\", "/")
+	}
+	return filePath
 }
 
 // MatchSkill checks a skill name against rules for Skill tool
 func (m *Matcher) MatchSkill(skillName string) MatchResult {
-	// Check deny rules first
-	for _, rule := range m.cfg.Deny {
+	// Find the highest-priority deny rule
+	var deny *MatchResult
+	for _, rule := range m.deny {
 		if rule.Tool != "Skill" {
 			continue
 		}
+		if deny != nil && rule.Priority <= deny.priority {
+			continue
+		}
 
 		if matchesSkillRule(rule, skillName) {
-			return MatchResult{
+			m.tracef("%s: matched skill %q", ruleName("deny", rule), skillName)
+			deny = &MatchResult{
 				Decision:    DecisionDeny,
 				Reason:      "Skill matched deny rule",
 				MatchedRule: rule.Description,
+				Audit:       rule.Audit,
+				DocsURL:     rule.DocsURL,
+				Suggestion:  rule.Suggestion,
+				Halt:        rule.Halt,
+				priority:    rule.Priority,
 			}
+			applyTemplate(rule, deny, skillName, skillName)
 		}
 	}
 
-	// Check allow rules
-	for _, rule := range m.cfg.Allow {
+	// Find the highest-priority allow rule
+	var allow *MatchResult
+	for _, rule := range m.allow {
 		if rule.Tool != "Skill" {
 			continue
 		}
+		if outranks(allow, rule) {
+			continue
+		}
 
 		if matchesSkillRule(rule, skillName) {
-			return MatchResult{
+			m.tracef("%s: matched skill %q", ruleName("allow", rule), skillName)
+			allow = &MatchResult{
 				Decision:    DecisionAllow,
 				Reason:      "Skill matched allow rule",
 				MatchedRule: rule.Description,
+				Audit:       rule.Audit,
+				priority:    rule.Priority,
 			}
+			applyAction(rule, allow)
+			applyTemplate(rule, allow, skillName, skillName)
 		}
 	}
 
-	return MatchResult{
+	return resolve(deny, allow, m.unmatched("Skill", MatchResult{
 		Decision: DecisionPassthrough,
 		Reason:   "No rule matched for skill",
+	}))
+}
+
+// MatchURL checks the URL of a WebFetch call against the block_raw_ip_hosts
+// setting. It returns a deny for a host that is an IP address or localhost,
+// and false when the URL is left to the tool's rules.
+func (m *Matcher) MatchURL(rawURL string) (MatchResult, bool) {
+	if !m.cfg.Settings.BlockRawIPHosts {
+		return MatchResult{}, false
+	}
+	host, ok := checkRawIPHost(rawURL)
+	if !ok {
+		return MatchResult{}, false
+	}
+	m.tracef("url %q: host %q is an IP address or localhost", rawURL, host)
+	return MatchResult{
+		Decision:    DecisionDeny,
+		Reason:      "URL host is an IP address or localhost instead of a domain",
+		MatchedRule: "setting: block_raw_ip_hosts",
+		Details:     "Host: " + host,
+	}, true
+}
+
+// HandlesTool reports whether any rule inspects the input of the given tool
+// through input_field
+func (m *Matcher) HandlesTool(toolName string) bool {
+	for _, rules := range [][]config.Rule{m.deny, m.allow} {
+		for _, rule := range rules {
+			if rule.Tool == toolName && rule.InputField != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MatchDefault returns the [defaults] decision for a tool no rule inspects,
+// and whether one is configured
+func (m *Matcher) MatchDefault(toolName string) (MatchResult, bool) {
+	if decision, _ := m.cfg.DefaultDecision(toolName); decision == "" {
+		return MatchResult{}, false
+	}
+	return m.unmatched(toolName, MatchResult{
+		Decision: DecisionPassthrough,
+		Reason:   "No rule matched for tool",
+	}), true
+}
+
+// HasMCPMapping reports whether an [[mcp]] entry maps the given tool
+func (m *Matcher) HasMCPMapping(toolName string) bool {
+	for _, entry := range m.cfg.MCP {
+		if entry.Tool == toolName {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchToolInput checks a field of an arbitrary tool's input against rules
+// that set input_field, optionally following a JSON pointer into the value
+func (m *Matcher) MatchToolInput(toolName string, toolInput map[string]interface{}) MatchResult {
+	return m.matchInput(toolName, toolInput, inputSelector{})
+}
+
+// MatchMCP checks an MCP tool's input against its rules. The command is taken
+// from the field configured in the tool's [[mcp]] entry; rules that set their
+// own input_field use that instead.
+func (m *Matcher) MatchMCP(toolName string, toolInput map[string]interface{}) MatchResult {
+	var selector inputSelector
+	for _, entry := range m.cfg.MCP {
+		if entry.Tool == toolName {
+			selector = parseInputPath(entry.InputField)
+			break
+		}
+	}
+	return m.matchInput(toolName, toolInput, selector)
+}
+
+// inputSelector locates a value in tool input: a top-level field and an
+// optional JSON pointer into it
+type inputSelector struct {
+	field   string
+	pointer string
+}
+
+// parseInputPath converts a JSONPath-like path ("$.params.cmd" or
+// "params.cmd") into a field and JSON pointer
+func parseInputPath(inputPath string) inputSelector {
+	inputPath = strings.TrimPrefix(strings.TrimPrefix(inputPath, "$"), ".")
+	field, rest, found := strings.Cut(inputPath, ".")
+	if !found {
+		return inputSelector{field: field}
+	}
+	pointer := "/" + strings.ReplaceAll(strings.ReplaceAll(rest, "~", "~0"), ".", "/")
+	return inputSelector{field: field, pointer: pointer}
+}
+
+// selectorFor returns the rule's own input field if set, else the default
+func selectorFor(rule config.Rule, def inputSelector) inputSelector {
+	if rule.InputField != "" {
+		return inputSelector{field: rule.InputField, pointer: rule.JSONPointer}
+	}
+	return def
+}
+
+// matchInput checks tool input against the tool's rules, extracting the value
+// to match with each rule's selector
+func (m *Matcher) matchInput(toolName string, toolInput map[string]interface{}, def inputSelector) MatchResult {
+	// Find the highest-priority deny rule
+	var deny *MatchResult
+	for _, rule := range m.deny {
+		selector := selectorFor(rule, def)
+		if rule.Tool != toolName || selector.field == "" {
+			continue
+		}
+		if deny != nil && rule.Priority <= deny.priority {
+			continue
+		}
+
+		if value, ok := matchesInputRule(rule, toolInput, selector); ok {
+			m.tracef("%s: matched input %q", ruleName("deny", rule), value)
+			deny = &MatchResult{
+				Decision:    DecisionDeny,
+				Reason:      "Tool input matched deny rule",
+				MatchedRule: rule.Description,
+				Audit:       rule.Audit,
+				DocsURL:     rule.DocsURL,
+				Suggestion:  rule.Suggestion,
+				Halt:        rule.Halt,
+				Details:     "Matched: " + value,
+				priority:    rule.Priority,
+			}
+			applyTemplate(rule, deny, value, value)
+		}
+	}
+
+	// Find the highest-priority allow rule
+	var allow *MatchResult
+	for _, rule := range m.allow {
+		selector := selectorFor(rule, def)
+		if rule.Tool != toolName || selector.field == "" {
+			continue
+		}
+		if outranks(allow, rule) {
+			continue
+		}
+
+		if value, ok := matchesInputRule(rule, toolInput, selector); ok {
+			m.tracef("%s: matched input %q", ruleName("allow", rule), value)
+			allow = &MatchResult{
+				Decision:    DecisionAllow,
+				Reason:      "Tool input matched allow rule",
+				MatchedRule: rule.Description,
+				Audit:       rule.Audit,
+				Details:     "Matched: " + value,
+				priority:    rule.Priority,
+			}
+			applyAction(rule, allow)
+			applyTemplate(rule, allow, value, value)
+		}
+	}
+
+	return resolve(deny, allow, m.unmatched(toolName, MatchResult{
+		Decision: DecisionPassthrough,
+		Reason:   "No rule matched for tool input",
+	}))
+}
+
+// matchesInputRule extracts the selected input value and checks it against
+// the rule's command patterns, returning the matched value
+func matchesInputRule(rule config.Rule, toolInput map[string]interface{}, selector inputSelector) (string, bool) {
+	value, ok := extractInputField(toolInput, selector.field, selector.pointer)
+	if !ok || excludedCommand(rule, value) {
+		return "", false
+	}
+	for _, re := range rule.GetCompiledCommandPatterns() {
+		if re.MatchString(value) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// extractInputField returns the string at field, or at pointer inside field
+// when a JSON pointer is given. The field may hold a JSON-encoded string or an
+// already structured value.
+func extractInputField(toolInput map[string]interface{}, field, pointer string) (string, bool) {
+	value, ok := toolInput[field]
+	if !ok {
+		return "", false
+	}
+
+	if pointer != "" {
+		if encoded, isString := value.(string); isString {
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(encoded), &decoded); err != nil {
+				return "", false
+			}
+			value = decoded
+		}
+		value, ok = resolvePointer(value, pointer)
+		if !ok {
+			return "", false
+		}
+	}
+
+	str, ok := value.(string)
+	return str, ok
+}
+
+// resolvePointer follows an RFC 6901 JSON pointer through decoded JSON
+func resolvePointer(value interface{}, pointer string) (interface{}, bool) {
+	for _, token := range strings.Split(pointer, "/")[1:] {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[token]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			idx, err := strconv.Atoi(token)
+			if err != nil || idx < 0 || idx >= len(v) {
+				return nil, false
+			}
+			value = v[idx]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// applyAction turns the allow result of a rule with action = "ask" into a
+// prompt for confirmation
+func applyAction(rule config.Rule, result *MatchResult) {
+	if !rule.Asks() {
+		return
+	}
+	result.Decision = DecisionAsk
+	result.Reason = "Allow rule requires confirmation"
+}
+
+// outranks reports whether the best allow match so far beats an allow rule
+// still to be tried: it has a higher priority, or the same priority and the
+// rule wouldn't ask where best doesn't. An ask rule wins a tie with a plain
+// allow, so a broad allow listed first can't skip its confirmation.
+func outranks(best *MatchResult, rule config.Rule) bool {
+	if best == nil {
+		return false
+	}
+	if rule.Priority != best.priority {
+		return rule.Priority < best.priority
+	}
+	return !rule.Asks() || best.Decision == DecisionAsk
+}
+
+// permits reports whether an allow rule vouched for a command, whether or not
+// it asks for confirmation
+func permits(d Decision) bool {
+	return d == DecisionAllow || d == DecisionAsk
+}
+
+// applyTemplate renders the rule's reason_template, if it has one, as the
+// result's reason. command is what the rule matched (a command, path, skill
+// or input value) and signature its command signature.
+func applyTemplate(rule config.Rule, result *MatchResult, command, signature string) {
+	if rule.ReasonTemplate == "" {
+		return
+	}
+	result.Reason = strings.NewReplacer(
+		"{signature}", signature,
+		"{command}", command,
+		"{rule_id}", rule.ID,
+		"{description}", rule.Description,
+	).Replace(rule.ReasonTemplate)
+	result.Templated = true
+}
+
+// unmatched returns the result for a tool use no allow rule matched: the
+// tool's [defaults] decision if one is set, else in deny-list mode anything
+// that wasn't denied is allowed.
+func (m *Matcher) unmatched(tool string, result MatchResult) MatchResult {
+	if decision, key := m.cfg.DefaultDecision(tool); decision != "" {
+		result.Decision = configDecisions[decision]
+		result.MatchedRule = "setting: defaults." + key
+		m.tracef("no rule matched: defaults.%s is %s", key, decision)
+		return result
+	}
+	if m.cfg.Settings.DenyListMode {
+		result.Decision = DecisionAllow
+		result.Reason += " (allowed by deny_list_mode)"
+	}
+	return result
+}
+
+// resolve picks between the best deny and allow matches. Allow only wins
+// with a strictly higher priority, so deny wins ties.
+func resolve(deny, allow *MatchResult, passthrough MatchResult) MatchResult {
+	if allow != nil && (deny == nil || allow.priority > deny.priority) {
+		return *allow
+	}
+	if deny != nil {
+		return *deny
 	}
+	return passthrough
 }
 
 // matchesSkillRule checks if a skill name matches a rule's commands list
@@ -0,0 +1,125 @@
+package matcher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/config"
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/parser"
+)
+
+// NearMiss is an allow rule that came close to allowing a command
+type NearMiss struct {
+	Command string // The command that wasn't allowed
+	Rule    string // Description of the allow rule
+	Reason  string // What differed, e.g. "signature was npm install, the rule has npm run"
+
+	score int // Lower is closer
+}
+
+// Near-miss scores, closest first
+const (
+	missBlocked    = iota // The signature matched but something else kept the rule from applying
+	missSubcommand        // Same command name, different subcommand
+	missName              // A command name a typo away
+)
+
+// maxNameDistance is the largest edit distance between command names that
+// still counts as a near miss (gh vs git). Names must also start with the
+// same letter, so short unrelated names like ls and cd don't qualify.
+const maxNameDistance = 2
+
+// NearMisses explains why a Bash command wasn't allowed: for each command
+// of the statement no allow rule matched, up to limit allow rules that came
+// closest, with what differed. A command that fails to parse has none.
+func (m *Matcher) NearMisses(command string, limit int) []NearMiss {
+	stmt, err := parser.ParseShellCommandCached(command)
+	if err != nil {
+		return nil
+	}
+
+	var misses []NearMiss
+	for _, cmd := range stmt.Commands {
+		if permits(m.checkSingleCommand("Bash", command, cmd).Decision) {
+			continue
+		}
+		sig := commandSignature("Bash", cmd)
+
+		var candidates []NearMiss
+		for _, rule := range m.allowIndex.rules("Bash") {
+			if miss, ok := nearMiss(rule, sig, command, cmd); ok {
+				candidates = append(candidates, miss)
+			}
+		}
+		// Stable, so equally close rules keep config order
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].score < candidates[j].score
+		})
+		if len(candidates) > limit {
+			candidates = candidates[:limit]
+		}
+		misses = append(misses, candidates...)
+	}
+	return misses
+}
+
+// nearMiss compares a command against one allow rule's command entries and
+// returns the closest of them, if any is close
+func nearMiss(rule config.Rule, sig, fullCmd string, cmd parser.ParsedCommand) (NearMiss, bool) {
+	miss := NearMiss{Command: cmd.Raw, Rule: rule.Description}
+	sigFields := strings.Fields(sig)
+	found := false
+	for _, entry := range rule.Commands {
+		if matchSignature(rule.Tool, entry, sig, cmd) {
+			blocker := allowBlocker(rule, fullCmd, cmd)
+			if blocker == "" {
+				continue
+			}
+			miss.score = missBlocked
+			miss.Reason = fmt.Sprintf("signature %s matches %s, but %s", sig, entry, blocker)
+			return miss, true
+		}
+
+		entryFields := strings.Fields(parser.NormalizeSignature(entry))
+		if len(entryFields) == 0 || len(sigFields) == 0 {
+			continue
+		}
+		switch {
+		case entryFields[0] == sigFields[0]:
+			if !found || miss.score > missSubcommand {
+				miss.score = missSubcommand
+				miss.Reason = fmt.Sprintf("signature was %s, the rule has %s", sig, entry)
+				found = true
+			}
+		case entryFields[0][0] == sigFields[0][0] && editDistance(entryFields[0], sigFields[0]) <= maxNameDistance:
+			if !found {
+				miss.score = missName
+				miss.Reason = fmt.Sprintf("the rule expects command name %s but got %s", entryFields[0], sigFields[0])
+				found = true
+			}
+		}
+	}
+	return miss, found
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr := make([]int, len(b)+1)
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev = curr
+	}
+	return prev[len(b)]
+}
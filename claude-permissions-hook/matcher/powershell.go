@@ -0,0 +1,64 @@
+package matcher
+
+import (
+	"strings"
+
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/parser"
+)
+
+// MatchPowerShellCommand checks a PowerShell command against rules with
+// tool = "PowerShell". Rule commands name a cmdlet, optionally followed by
+// switches it must be given (e.g. "Remove-Item -Recurse"), and match
+// case-insensitively like PowerShell itself.
+func (m *Matcher) MatchPowerShellCommand(command string) MatchResult {
+	stmt, err := parser.ParsePowerShellCommand(command)
+	if err != nil {
+		return m.parseFailure("Failed to parse command", err)
+	}
+	if stmt.IsEmpty {
+		return MatchResult{
+			Decision: DecisionPassthrough,
+			Reason:   "No commands parsed",
+		}
+	}
+
+	for _, cmd := range stmt.Commands {
+		m.tracef("command %q: cmdlet %q", cmd.Raw, parser.PowerShellSignature(cmd))
+	}
+
+	return m.matchStatement("PowerShell", command, stmt)
+}
+
+// matchCmdlet checks a rule entry like "Remove-Item" or
+// "Remove-Item -Recurse -Force" against a cmdlet invocation. "*" matches any
+// cmdlet. Like PowerShell, a switch may be abbreviated, so "-rec" and "-r"
+// count as -Recurse.
+func matchCmdlet(pattern string, cmd parser.ParsedCommand) bool {
+	fields := strings.Fields(pattern)
+	if len(fields) == 0 {
+		return false
+	}
+	if fields[0] != "*" && !strings.EqualFold(fields[0], parser.PowerShellSignature(cmd)) {
+		return false
+	}
+
+	params := parser.PowerShellParameters(cmd)
+	for _, want := range fields[1:] {
+		if !hasParameter(params, strings.TrimPrefix(want, "-")) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasParameter reports whether params includes name or a prefix of it,
+// ignoring case. Prefixes another parameter shares are taken to mean name:
+// PowerShell would reject them as ambiguous, so matching is harmless.
+func hasParameter(params []string, name string) bool {
+	for _, p := range params {
+		if len(p) <= len(name) && strings.EqualFold(p, name[:len(p)]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package matcher
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/config"
+)
+
+// SetTrace makes the matcher write a line-per-step trace of how it reached
+// its decision (parsed signatures, rules considered, which matched) to w.
+// A nil writer disables tracing.
+func (m *Matcher) SetTrace(w io.Writer) {
+	m.trace = w
+}
+
+// tracef writes one trace line if tracing is enabled
+func (m *Matcher) tracef(format string, args ...interface{}) {
+	if m.trace == nil {
+		return
+	}
+	fmt.Fprintf(m.trace, "[trace] "+format+"\n", args...)
+}
+
+// ruleRef identifies a rule in trace output. It's formatted lazily so
+// matching doesn't pay for trace messages that are never written.
+type ruleRef struct {
+	kind        string
+	description string
+	priority    int
+}
+
+// ruleName identifies a rule in trace output
+func ruleName(kind string, rule config.Rule) ruleRef {
+	return ruleRef{kind: kind, description: rule.Description, priority: rule.Priority}
+}
+
+func (r ruleRef) String() string {
+	name := r.description
+	if name == "" {
+		name = "(no description)"
+	}
+	return fmt.Sprintf("%s rule %q (priority %d)", r.kind, name, r.priority)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/hook"
+)
+
+// AuditMetrics summarizes the decisions recorded in an audit log
+type AuditMetrics struct {
+	Total     int          // Entries read
+	Skipped   int          // Lines that weren't valid audit entries
+	Decisions []CountedRow // Entries per decision, most frequent first
+	TopRules  []CountedRow // Entries per matched rule and decision, most frequent first
+}
+
+// CountedRow is one line of a metrics table
+type CountedRow struct {
+	Decision string
+	Rule     string // Empty in the decision breakdown
+	Count    int
+}
+
+// metricsCmd summarizes an audit log
+func metricsCmd(args []string) {
+	fs := flag.NewFlagSet("metrics", flag.ExitOnError)
+	auditPath := fs.String("audit-file", "", "Path to the JSONL audit log")
+	format := fs.String("format", "text", "Output format: text or markdown")
+	top := fs.Int("top", 10, "Rules to show in the top rules table (0 = all)")
+	toolUseID := fs.String("tool-use-id", "", "Print the audit entries for this tool_use_id instead of metrics")
+	fs.Parse(args)
+
+	if *auditPath == "" {
+		fmt.Fprintln(os.Stderr, "Error: --audit-file is required")
+		os.Exit(1)
+	}
+	if *format != "text" && *format != "markdown" {
+		fmt.Fprintf(os.Stderr, "Error: unknown format %q (want text or markdown)\n", *format)
+		os.Exit(1)
+	}
+
+	f, err := os.Open(*auditPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading audit log: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	if *toolUseID != "" {
+		entries, err := findAuditEntries(f, *toolUseID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading audit log: %v\n", err)
+			os.Exit(1)
+		}
+		if len(entries) == 0 {
+			fmt.Fprintf(os.Stderr, "No audit entry for tool_use_id %q\n", *toolUseID)
+			os.Exit(1)
+		}
+		for _, entry := range entries {
+			data, _ := json.MarshalIndent(entry, "", "  ")
+			fmt.Println(string(data))
+		}
+		return
+	}
+
+	metrics, err := collectMetrics(f, *top)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading audit log: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *format == "markdown" {
+		printMarkdownMetrics(os.Stdout, metrics, time.Now().UTC())
+		return
+	}
+	printTextMetrics(os.Stdout, metrics)
+}
+
+// collectMetrics counts the decisions and matched rules in a JSONL audit
+// log, keeping the top most frequent rules (all of them if top is 0). Lines
+// that aren't audit entries are counted as skipped rather than failing the
+// whole log, since a log can end in a partly written line.
+func collectMetrics(r io.Reader, top int) (AuditMetrics, error) {
+	var metrics AuditMetrics
+	decisions := make(map[string]int)
+	type ruleKey struct{ decision, rule string }
+	rules := make(map[ruleKey]int)
+
+	err := scanAuditLog(r, func(entry hook.AuditEntry, ok bool) {
+		if !ok {
+			metrics.Skipped++
+			return
+		}
+		metrics.Total++
+		decisions[entry.Decision]++
+		if entry.RuleMatch != "" {
+			rules[ruleKey{entry.Decision, entry.RuleMatch}]++
+		}
+	})
+	if err != nil {
+		return AuditMetrics{}, err
+	}
+
+	for decision, count := range decisions {
+		metrics.Decisions = append(metrics.Decisions, CountedRow{Decision: decision, Count: count})
+	}
+	for key, count := range rules {
+		metrics.TopRules = append(metrics.TopRules, CountedRow{Decision: key.decision, Rule: key.rule, Count: count})
+	}
+	sortCountedRows(metrics.Decisions)
+	sortCountedRows(metrics.TopRules)
+	if top > 0 && len(metrics.TopRules) > top {
+		metrics.TopRules = metrics.TopRules[:top]
+	}
+	return metrics, nil
+}
+
+// scanAuditLog calls visit for each non-blank line of a JSONL audit log, with
+// ok false for lines that aren't audit entries
+func scanAuditLog(r io.Reader, visit func(entry hook.AuditEntry, ok bool)) error {
+	br := bufio.NewReader(r)
+	for {
+		line, readErr := br.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var entry hook.AuditEntry
+			err := json.Unmarshal(line, &entry)
+			visit(entry, err == nil && entry.Decision != "")
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
+// findAuditEntries returns the entries of a JSONL audit log recorded for a
+// tool use, in log order. There is usually one, but a dry run and a real run
+// of the same input both leave one.
+func findAuditEntries(r io.Reader, toolUseID string) ([]hook.AuditEntry, error) {
+	var found []hook.AuditEntry
+	err := scanAuditLog(r, func(entry hook.AuditEntry, ok bool) {
+		if ok && entry.ToolUseID == toolUseID {
+			found = append(found, entry)
+		}
+	})
+	return found, err
+}
+
+// sortCountedRows orders rows by count descending, then by decision and rule
+// so the output is stable across runs
+func sortCountedRows(rows []CountedRow) {
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Count != rows[j].Count {
+			return rows[i].Count > rows[j].Count
+		}
+		if rows[i].Decision != rows[j].Decision {
+			return rows[i].Decision < rows[j].Decision
+		}
+		return rows[i].Rule < rows[j].Rule
+	})
+}
+
+// share formats count as a percentage of total
+func share(count, total int) string {
+	if total == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", float64(count)*100/float64(total))
+}
+
+func printTextMetrics(w io.Writer, metrics AuditMetrics) {
+	fmt.Fprintf(w, "Entries: %d\n", metrics.Total)
+	if metrics.Skipped > 0 {
+		fmt.Fprintf(w, "Skipped lines: %d\n", metrics.Skipped)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "Decisions:")
+	for _, row := range metrics.Decisions {
+		fmt.Fprintf(w, "  %-12s %6d  %6s\n", row.Decision, row.Count, share(row.Count, metrics.Total))
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "Top rules:")
+	if len(metrics.TopRules) == 0 {
+		fmt.Fprintln(w, "  (no matched rules)")
+	}
+	for _, row := range metrics.TopRules {
+		fmt.Fprintf(w, "  %6d  %-12s %s\n", row.Count, row.Decision, row.Rule)
+	}
+}
+
+// printMarkdownMetrics renders metrics as Markdown tables for pasting into
+// PRs or wikis. generated is the timestamp shown in the report.
+func printMarkdownMetrics(w io.Writer, metrics AuditMetrics, generated time.Time) {
+	fmt.Fprintln(w, "# Audit metrics")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Generated at %s from %d entries", generated.Format(time.RFC3339), metrics.Total)
+	if metrics.Skipped > 0 {
+		fmt.Fprintf(w, " (%d unreadable line(s) skipped)", metrics.Skipped)
+	}
+	fmt.Fprintln(w, ".")
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "## Decisions")
+	fmt.Fprintln(w)
+	var rows [][]string
+	for _, row := range metrics.Decisions {
+		rows = append(rows, []string{row.Decision, fmt.Sprint(row.Count), share(row.Count, metrics.Total)})
+	}
+	writeMarkdownTable(w, []string{"Decision", "Count", "Share"}, []bool{false, true, true}, rows)
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "## Top rules")
+	fmt.Fprintln(w)
+	if len(metrics.TopRules) == 0 {
+		fmt.Fprintln(w, "No matched rules.")
+		return
+	}
+	rows = nil
+	for _, row := range metrics.TopRules {
+		rows = append(rows, []string{row.Rule, row.Decision, fmt.Sprint(row.Count)})
+	}
+	writeMarkdownTable(w, []string{"Rule", "Decision", "Count"}, []bool{false, false, true}, rows)
+}
+
+// markdownCell escapes the characters that would break a table cell
+var markdownCell = strings.NewReplacer("|", `\|`, "\n", " ", "\r", "")
+
+// writeMarkdownTable writes a Markdown table with its columns padded to a
+// common width, so it also reads well as plain text. Columns with
+// rightAlign set (counts) are right-aligned.
+func writeMarkdownTable(w io.Writer, headers []string, rightAlign []bool, rows [][]string) {
+	widths := make([]int, len(headers))
+	escaped := make([][]string, len(rows))
+	for i, h := range headers {
+		widths[i] = max(utf8.RuneCountInString(h), 3)
+	}
+	for r, row := range rows {
+		escaped[r] = make([]string, len(row))
+		for i, cell := range row {
+			escaped[r][i] = markdownCell.Replace(cell)
+			widths[i] = max(widths[i], utf8.RuneCountInString(escaped[r][i]))
+		}
+	}
+
+	writeRow := func(cells []string) {
+		var b strings.Builder
+		b.WriteString("|")
+		for i, cell := range cells {
+			pad := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+			if rightAlign[i] {
+				b.WriteString(" " + pad + cell + " |")
+			} else {
+				b.WriteString(" " + cell + pad + " |")
+			}
+		}
+		fmt.Fprintln(w, b.String())
+	}
+
+	writeRow(headers)
+	var sep strings.Builder
+	sep.WriteString("|")
+	for i, width := range widths {
+		if rightAlign[i] {
+			sep.WriteString(" " + strings.Repeat("-", width-1) + ": |")
+		} else {
+			sep.WriteString(" " + strings.Repeat("-", width) + " |")
+		}
+	}
+	fmt.Fprintln(w, sep.String())
+	for _, row := range escaped {
+		writeRow(row)
+	}
+}
@@ -0,0 +1,106 @@
+package parser
+
+import (
+	"container/list"
+	"sync"
+)
+
+// statementCache is a least-recently-used cache of parsed statements keyed
+// by the command string
+type statementCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List // Front is most recently used
+}
+
+type cacheEntry struct {
+	command string
+	stmt    *ShellStatement
+}
+
+var cache = &statementCache{}
+
+// SetCacheSize sets how many parsed statements ParseShellCommandCached keeps.
+// Zero (the default) disables caching. Changing the size empties the cache.
+func SetCacheSize(size int) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.size = size
+	cache.reset()
+}
+
+// reset empties the cache; the caller holds mu
+func (c *statementCache) reset() {
+	c.entries = make(map[string]*list.Element)
+	c.order = list.New()
+}
+
+// clear empties the cache, e.g. when a setting that affects parsing changes
+func (c *statementCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reset()
+}
+
+// ParseShellCommandCached is ParseShellCommand with an LRU cache in front of
+// it (see SetCacheSize). Each call returns its own copy of the statement, so
+// callers may modify it. Failed parses aren't cached.
+func ParseShellCommandCached(command string) (*ShellStatement, error) {
+	if stmt, ok := cache.get(command); ok {
+		return stmt, nil
+	}
+	stmt, err := ParseShellCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	cache.put(command, stmt)
+	return stmt, nil
+}
+
+func (c *statementCache) get(command string) (*ShellStatement, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size <= 0 {
+		return nil, false
+	}
+	elem, ok := c.entries[command]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return copyStatement(elem.Value.(*cacheEntry).stmt), true
+}
+
+func (c *statementCache) put(command string, stmt *ShellStatement) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size <= 0 {
+		return
+	}
+	if elem, ok := c.entries[command]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[command] = c.order.PushFront(&cacheEntry{command: command, stmt: copyStatement(stmt)})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).command)
+	}
+}
+
+// copyStatement copies a statement deep enough that changes to the copy's
+// commands and redirects don't reach the cached one
+func copyStatement(stmt *ShellStatement) *ShellStatement {
+	copied := *stmt
+	copied.Redirects = append([]Redirect(nil), stmt.Redirects...)
+	copied.Commands = make([]ParsedCommand, len(stmt.Commands))
+	for i, cmd := range stmt.Commands {
+		cmd.Args = append([]string(nil), cmd.Args...)
+		cmd.Env = append([]string(nil), cmd.Env...)
+		cmd.GitConfig = append([]string(nil), cmd.GitConfig...)
+		copied.Commands[i] = cmd
+	}
+	return &copied
+}
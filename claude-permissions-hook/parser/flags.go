@@ -0,0 +1,111 @@
+package parser
+
+import "strings"
+
+// longFlagsByCommand maps a command's long flags to the short flag they
+// stand for, so "--recursive" and "-r" count as the same flag
+var longFlagsByCommand = map[string]map[string]string{
+	"rm":    {"recursive": "r", "force": "f", "dir": "d", "interactive": "i", "verbose": "v"},
+	"cp":    {"recursive": "r", "force": "f", "archive": "a", "verbose": "v"},
+	"mv":    {"force": "f", "interactive": "i", "verbose": "v"},
+	"chmod": {"recursive": "R", "verbose": "v"},
+	"chown": {"recursive": "R", "verbose": "v"},
+	"chgrp": {"recursive": "R", "verbose": "v"},
+	"git":   {"force": "f"},
+}
+
+// shortAliasesByCommand maps short flags that mean the same as another,
+// like rm -R for -r
+var shortAliasesByCommand = map[string]map[string]string{
+	"rm": {"R": "r"},
+	"cp": {"R": "r"},
+}
+
+// canonicalFlag returns the short flag a flag name stands for in a command:
+// "recursive" and "R" are "r" for rm. Other names are returned unchanged.
+func canonicalFlag(cmdName, flag string) string {
+	if short, ok := longFlagsByCommand[cmdName][flag]; ok {
+		return short
+	}
+	if short, ok := shortAliasesByCommand[cmdName][flag]; ok {
+		return short
+	}
+	return flag
+}
+
+// canonicalLongFlag is canonicalFlag for a long flag as given on the command
+// line, where GNU tools also accept any unambiguous prefix of a long name:
+// "--recur" is "--recursive" for rm
+func canonicalLongFlag(cmdName, flag string) string {
+	long := longFlagsByCommand[cmdName]
+	if short, ok := long[flag]; ok {
+		return short
+	}
+	match := ""
+	for name, short := range long {
+		if flag != "" && strings.HasPrefix(name, flag) {
+			if match != "" && match != short {
+				return flag
+			}
+			match = short
+		}
+	}
+	if match != "" {
+		return match
+	}
+	return flag
+}
+
+// CommandFlags returns the flags a command is run with, by canonical name.
+// Combined short flags are split ("-rf" is r and f), long flags are mapped
+// to their short equivalent where the command has one (abbreviations
+// included), and values of flags
+// known to take one are skipped. Flags end at "--".
+func CommandFlags(cmd ParsedCommand) map[string]bool {
+	cmd = UnwrapCommand(cmd)
+	name := GetCommandName(cmd)
+	flags := make(map[string]bool)
+	if len(cmd.Args) < 2 {
+		return flags
+	}
+	args := cmd.Args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return flags
+		case strings.HasPrefix(arg, "--"):
+			long, _, hasValue := strings.Cut(arg[2:], "=")
+			flags[canonicalLongFlag(name, long)] = true
+			if !hasValue && flagTakesValue(name, arg) {
+				i++
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			for _, c := range arg[1:] {
+				flags[canonicalFlag(name, string(c))] = true
+			}
+			if flagTakesValue(name, arg) {
+				i++
+			}
+		}
+	}
+	return flags
+}
+
+// HasCombinedFlags reports whether a command is run with every one of the
+// given flags, however they're spelled: for rm, flags r and f match "-rf",
+// "-fr", "-r -f", "-Rf" and "--recursive --force". Flags may be given as
+// short or long names, with or without dashes.
+func HasCombinedFlags(cmd ParsedCommand, flags []string) bool {
+	if len(flags) == 0 {
+		return false
+	}
+	name := GetCommandName(UnwrapCommand(cmd))
+	present := CommandFlags(cmd)
+	for _, flag := range flags {
+		if !present[canonicalFlag(name, strings.TrimLeft(flag, "-"))] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,257 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ParsePowerShellCommand splits a PowerShell command line into cmdlet
+// invocations. It's a lightweight tokenizer rather than a full PowerShell
+// parser: it understands quoting, backtick escapes, comments and the
+// statement separators ; | && ||, which is enough to find each cmdlet and
+// its parameters. Commands inside script blocks ({ ... }) and
+// subexpressions ($(...), (...)) are parsed too and appended as nested
+// commands.
+func ParsePowerShellCommand(command string) (*ShellStatement, error) {
+	stmt := &ShellStatement{
+		Raw:      command,
+		Commands: make([]ParsedCommand, 0),
+	}
+
+	var nested []ParsedCommand
+	var words []string
+	var word strings.Builder
+	inWord := false
+
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endCommand := func(op string) {
+		endWord()
+		if len(words) > 0 {
+			stmt.Commands = append(stmt.Commands, ParsedCommand{
+				Name:     words[0],
+				Args:     words,
+				Raw:      strings.Join(words, " "),
+				Operator: op,
+			})
+			words = nil
+		} else if op != ";" && len(stmt.Commands) > 0 {
+			stmt.Commands[len(stmt.Commands)-1].Operator = op
+		}
+	}
+
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '`':
+			// Escape the next character; a trailing backtick continues the line
+			if i+1 < len(runes) {
+				i++
+				if runes[i] != '\n' {
+					word.WriteRune(runes[i])
+					inWord = true
+				}
+			}
+		case r == '\'':
+			end, ok := scanSingleQuoted(runes, i+1, &word)
+			if !ok {
+				return nil, fmt.Errorf("unterminated single-quoted string")
+			}
+			i, inWord = end, true
+		case r == '"':
+			end, ok := scanDoubleQuoted(runes, i+1, &word)
+			if !ok {
+				return nil, fmt.Errorf("unterminated double-quoted string")
+			}
+			i, inWord = end, true
+		case r == '#' && !inWord:
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			i-- // let the newline end the statement
+		case r == ';' || r == '\n':
+			endCommand(";")
+		case r == '|':
+			if i+1 < len(runes) && runes[i+1] == '|' {
+				i++
+				endCommand("||")
+			} else {
+				stmt.HasPipe = true
+				endCommand("|")
+			}
+		case r == '&':
+			if i+1 < len(runes) && runes[i+1] == '&' {
+				i++
+				endCommand("&&")
+			} else if len(words) > 0 || inWord {
+				stmt.HasBackground = true
+				endCommand(";")
+			}
+			// Otherwise it's the call operator (& 'tool.exe'), which runs the next word
+		case r == '(' || r == '{' || (r == '$' && i+1 < len(runes) && runes[i+1] == '('):
+			open := i
+			if r == '$' {
+				open++
+			}
+			end, ok := scanGroup(runes, open)
+			if !ok {
+				return nil, fmt.Errorf("unterminated %q group", runes[open])
+			}
+			inner, err := ParsePowerShellCommand(string(runes[open+1 : end]))
+			if err != nil {
+				return nil, err
+			}
+			mergePowerShellFlags(stmt, inner)
+			for _, cmd := range inner.Commands {
+				cmd.Nested = true
+				nested = append(nested, cmd)
+			}
+			if runes[open] == '(' {
+				stmt.HasSubshell = true
+			}
+			word.WriteString(string(runes[i : end+1]))
+			i, inWord = end, true
+		case r == '>' || (r == '<' && !inWord):
+			stmt.HasRedirect = true
+			word.WriteRune(r)
+			inWord = true
+		case unicode.IsSpace(r):
+			endWord()
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	endCommand("")
+
+	// The last command doesn't connect to anything
+	if n := len(stmt.Commands); n > 0 {
+		stmt.Commands[n-1].Operator = ""
+	}
+	stmt.Commands = append(stmt.Commands, nested...)
+	stmt.IsEmpty = len(stmt.Commands) == 0
+
+	return stmt, nil
+}
+
+// mergePowerShellFlags carries the construct flags of a nested group over to
+// its enclosing statement
+func mergePowerShellFlags(stmt, inner *ShellStatement) {
+	stmt.HasPipe = stmt.HasPipe || inner.HasPipe
+	stmt.HasBackground = stmt.HasBackground || inner.HasBackground
+	stmt.HasSubshell = stmt.HasSubshell || inner.HasSubshell
+	stmt.HasRedirect = stmt.HasRedirect || inner.HasRedirect
+}
+
+// scanSingleQuoted reads a '...' string starting after the opening quote,
+// where a doubled quote is a literal quote. It returns the index of the
+// closing quote.
+func scanSingleQuoted(runes []rune, start int, word *strings.Builder) (int, bool) {
+	for i := start; i < len(runes); i++ {
+		if runes[i] == '\'' {
+			if i+1 < len(runes) && runes[i+1] == '\'' {
+				word.WriteRune('\'')
+				i++
+				continue
+			}
+			return i, true
+		}
+		word.WriteRune(runes[i])
+	}
+	return 0, false
+}
+
+// scanDoubleQuoted reads a "..." string starting after the opening quote,
+// handling backtick escapes and "" as a literal quote. It returns the index
+// of the closing quote.
+func scanDoubleQuoted(runes []rune, start int, word *strings.Builder) (int, bool) {
+	for i := start; i < len(runes); i++ {
+		switch runes[i] {
+		case '`':
+			if i+1 < len(runes) {
+				i++
+				word.WriteRune(runes[i])
+			}
+			continue
+		case '"':
+			if i+1 < len(runes) && runes[i+1] == '"' {
+				word.WriteRune('"')
+				i++
+				continue
+			}
+			return i, true
+		}
+		word.WriteRune(runes[i])
+	}
+	return 0, false
+}
+
+// scanGroup finds the bracket closing the ( or { at start, skipping quoted
+// strings and escapes. It returns the index of the closing bracket.
+func scanGroup(runes []rune, start int) (int, bool) {
+	open := runes[start]
+	closing := ')'
+	if open == '{' {
+		closing = '}'
+	}
+	var discard strings.Builder
+	depth := 0
+	for i := start; i < len(runes); i++ {
+		switch runes[i] {
+		case '`':
+			i++
+		case '\'':
+			end, ok := scanSingleQuoted(runes, i+1, &discard)
+			if !ok {
+				return 0, false
+			}
+			i = end
+		case '"':
+			end, ok := scanDoubleQuoted(runes, i+1, &discard)
+			if !ok {
+				return 0, false
+			}
+			i = end
+		case open:
+			depth++
+		case closing:
+			depth--
+			if depth == 0 {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// PowerShellSignature returns the cmdlet name of a PowerShell command, e.g.
+// "Remove-Item" for `Remove-Item -Recurse -Force build`
+func PowerShellSignature(cmd ParsedCommand) string {
+	return GetCommandName(cmd)
+}
+
+// PowerShellParameters returns the parameter names passed to a cmdlet
+// without the leading dash, e.g. ["Recurse", "Force"] for
+// `Remove-Item -Recurse -Force build`. Values given as -Name:value are
+// stripped.
+func PowerShellParameters(cmd ParsedCommand) []string {
+	var params []string
+	for _, arg := range cmd.Args[1:] {
+		if len(arg) < 2 || arg[0] != '-' || !unicode.IsLetter(rune(arg[1])) {
+			continue
+		}
+		name := arg[1:]
+		if i := strings.Index(name, ":"); i >= 0 {
+			name = name[:i]
+		}
+		params = append(params, name)
+	}
+	return params
+}
@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePowerShellCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		wantSigs   []string
+		wantParams [][]string
+	}{
+		{
+			name:       "remove item with switches",
+			input:      "Remove-Item -Recurse -Force build",
+			wantSigs:   []string{"Remove-Item"},
+			wantParams: [][]string{{"Recurse", "Force"}},
+		},
+		{
+			name:       "parameter with colon value",
+			input:      "Get-ChildItem -Path:C:\\src -Filter *.cs",
+			wantSigs:   []string{"Get-ChildItem"},
+			wantParams: [][]string{{"Path", "Filter"}},
+		},
+		{
+			name:       "quoted arguments",
+			input:      "Set-Content -Path 'it''s.txt' -Value \"a `\"b`\" c\"",
+			wantSigs:   []string{"Set-Content"},
+			wantParams: [][]string{{"Path", "Value"}},
+		},
+		{
+			name:       "pipeline",
+			input:      "Get-Process | Stop-Process -Force",
+			wantSigs:   []string{"Get-Process", "Stop-Process"},
+			wantParams: [][]string{nil, {"Force"}},
+		},
+		{
+			name:       "statement separators",
+			input:      "Set-Location src; dotnet build && Invoke-WebRequest -Uri https://example.com",
+			wantSigs:   []string{"Set-Location", "dotnet", "Invoke-WebRequest"},
+			wantParams: [][]string{nil, nil, {"Uri"}},
+		},
+		{
+			name:       "script block commands are nested",
+			input:      "Get-ChildItem *.tmp | ForEach-Object { Remove-Item $_ -Force }",
+			wantSigs:   []string{"Get-ChildItem", "ForEach-Object", "Remove-Item"},
+			wantParams: [][]string{nil, nil, {"Force"}},
+		},
+		{
+			name:       "negative number is not a parameter",
+			input:      "Get-Content log.txt -Tail -5",
+			wantSigs:   []string{"Get-Content"},
+			wantParams: [][]string{{"Tail"}},
+		},
+		{
+			name:       "comment",
+			input:      "Get-Date # what time is it",
+			wantSigs:   []string{"Get-Date"},
+			wantParams: [][]string{nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := ParsePowerShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParsePowerShellCommand(%q) error = %v", tt.input, err)
+			}
+			if len(stmt.Commands) != len(tt.wantSigs) {
+				t.Fatalf("got %d commands, want %d: %+v", len(stmt.Commands), len(tt.wantSigs), stmt.Commands)
+			}
+			for i, cmd := range stmt.Commands {
+				if sig := PowerShellSignature(cmd); sig != tt.wantSigs[i] {
+					t.Errorf("command %d signature = %q, want %q", i, sig, tt.wantSigs[i])
+				}
+				if params := PowerShellParameters(cmd); !reflect.DeepEqual(params, tt.wantParams[i]) {
+					t.Errorf("command %d parameters = %v, want %v", i, params, tt.wantParams[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParsePowerShellCommandErrors(t *testing.T) {
+	inputs := []string{
+		`Write-Output 'unterminated`,
+		`Write-Output "unterminated`,
+		`ForEach-Object { Remove-Item $_`,
+	}
+	for _, input := range inputs {
+		if _, err := ParsePowerShellCommand(input); err == nil {
+			t.Errorf("ParsePowerShellCommand(%q): expected error", input)
+		}
+	}
+}
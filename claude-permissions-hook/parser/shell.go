@@ -2,6 +2,8 @@
 package parser
 
 import (
+	"path"
+	"slices"
 	"strings"
 
 	"mvdan.cc/sh/v3/syntax"
@@ -10,31 +12,107 @@ import (
 // ParsedCommand represents a single command extracted from a shell statement
 type ParsedCommand struct {
 	// Name is the command name (e.g., "git", "npm", "dotnet")
-	Name string
+	Name string `json:"name"`
 	// Args is the full list of arguments including the command name
-	Args []string
-	// Raw is the original string representation of this command
-	Raw string
-	// Operator is the operator that connects this command to the next (&&, ||, ;, |, or "")
-	Operator string
+	Args []string `json:"args"`
+	// Raw is the command as text: its args joined by spaces, with args
+	// that need it requoted (see JoinArgs)
+	Raw string `json:"raw"`
+	// Operator is the operator that connects this command to the next (&&,
+	// ||, |, |&, ; for ; or a newline, & for a background job, or "")
+	Operator string `json:"operator,omitempty"`
+	// Nested indicates the command came from a script passed to a shell (bash -c "...")
+	Nested bool `json:"nested,omitempty"`
+	// Negated indicates the command's status is inverted with !, as in
+	// "! grep -q x file"
+	Negated bool `json:"negated,omitempty"`
+	// IsRemote indicates a nested command runs on another host, passed to
+	// ssh as in ssh host "rm -rf /data"
+	IsRemote bool `json:"remote,omitempty"`
+	// DynamicName indicates the command name (after wrappers like sudo) comes
+	// from a variable or command substitution, e.g. $CMD or $(echo rm)
+	DynamicName bool `json:"dynamic_name,omitempty"`
+	// Env lists the variable assignments the command runs with, as
+	// NAME=value, from prefixes (FOO=bar cmd) and the env wrapper
+	Env []string `json:"env,omitempty"`
+	// Dir is the repository a git command is pointed at with -C or
+	// --git-dir, e.g. "/etc" for "git -C /etc push"; empty when not given
+	Dir string `json:"dir,omitempty"`
+	// GitConfig lists the config a git command sets for itself with -c
+	// (key=value, or just key for a boolean) and --config-env (key=$VAR)
+	GitConfig []string `json:"git_config,omitempty"`
+	// Script is the file an interpreter runs, e.g. "./deploy.sh" for
+	// "bash ./deploy.sh"; empty when it runs code from -c, -e or stdin
+	Script string `json:"script,omitempty"`
 }
 
 // ShellStatement represents a parsed shell statement that may contain multiple commands
 type ShellStatement struct {
 	// Commands is the list of individual commands in the statement
-	Commands []ParsedCommand
+	Commands []ParsedCommand `json:"commands"`
 	// Raw is the original shell statement
-	Raw string
+	Raw string `json:"raw"`
 	// HasPipe indicates if any commands are connected via pipe
-	HasPipe bool
+	HasPipe bool `json:"has_pipe"`
 	// HasBackground indicates if any command runs in background (&)
-	HasBackground bool
+	HasBackground bool `json:"has_background"`
 	// HasSubshell indicates if statement contains subshell $(...)
-	HasSubshell bool
+	HasSubshell bool `json:"has_subshell"`
 	// HasRedirect indicates if statement contains redirects (>, >>, <, etc)
-	HasRedirect bool
+	HasRedirect bool `json:"has_redirect"`
+	// Redirects lists the file redirects with a literal target, e.g.
+	// "< /etc/shadow" or "> out.log"
+	Redirects []Redirect `json:"redirects,omitempty"`
 	// HasProcessSubst indicates if statement contains process substitution <(...)
-	HasProcessSubst bool
+	HasProcessSubst bool `json:"has_process_subst"`
+	// IsEmpty indicates the input has no statements (blank or comment-only)
+	IsEmpty bool `json:"is_empty"`
+	// HasDynamicCommandName indicates a command's name can't be determined statically
+	HasDynamicCommandName bool `json:"has_dynamic_command_name"`
+	// HasFindDelete indicates a find command deletes what it finds (-delete)
+	HasFindDelete bool `json:"has_find_delete"`
+	// NestedError is set when a script passed to a shell (bash -c "...") can't be parsed
+	NestedError error `json:"-"`
+}
+
+// Redirect is a file redirect in a statement
+type Redirect struct {
+	// Op is the redirect operator: <, <>, >, >>, >|, &> or &>>
+	Op string `json:"op"`
+	// Path is the file read from or written to
+	Path string `json:"path"`
+}
+
+// Reads reports whether the redirect reads its file
+func (r Redirect) Reads() bool {
+	return r.Op == "<" || r.Op == "<>"
+}
+
+// Writes reports whether the redirect writes its file
+func (r Redirect) Writes() bool {
+	return r.Op != "<"
+}
+
+// fileRedirects are the redirect operators that open a file by name;
+// heredocs and file descriptor duplication (2>&1) have no file
+var fileRedirects = map[syntax.RedirOperator]bool{
+	syntax.RdrIn:    true,
+	syntax.RdrInOut: true,
+	syntax.RdrOut:   true,
+	syntax.AppOut:   true,
+	syntax.ClbOut:   true,
+	syntax.RdrAll:   true,
+	syntax.AppAll:   true,
+}
+
+// fileRedirect returns the file a redirect opens. Targets built from a
+// variable or command substitution can't be known statically and are
+// skipped.
+func fileRedirect(rdr *syntax.Redirect) (Redirect, bool) {
+	if !fileRedirects[rdr.Op] || rdr.Word == nil || isDynamicWord(rdr.Word) {
+		return Redirect{}, false
+	}
+	return Redirect{Op: rdr.Op.String(), Path: wordToString(rdr.Word)}, true
 }
 
 // ParseShellCommand parses a shell command string and extracts all individual commands
@@ -50,15 +128,87 @@ func ParseShellCommand(command string) (*ShellStatement, error) {
 	stmt := &ShellStatement{
 		Raw:      command,
 		Commands: make([]ParsedCommand, 0),
+		IsEmpty:  len(file.Stmts) == 0,
 	}
 
 	// Walk the AST to extract commands
+	var nested []ParsedCommand
+	timed := make(map[*syntax.CallExpr]bool)
+	index := make(map[*syntax.CallExpr]int)    // Position of each call in stmt.Commands
+	var declared []string                      // Variables set by export and friends so far
+	stdin := make(map[*syntax.CallExpr]string) // File each call's stdin is redirected from
 	syntax.Walk(file, func(node syntax.Node) bool {
 		switch n := node.(type) {
+		case *syntax.DeclClause:
+			// "export LD_PRELOAD=x && make" runs make with LD_PRELOAD set,
+			// so the commands that follow carry the declared variables
+			declared = append(declared, declaredEnv(n)...)
+		case *syntax.TimeClause:
+			// The time keyword isn't part of the command it times; keep it
+			// so "time git status" signs like the /usr/bin/time wrapper
+			if n.Stmt != nil {
+				if call := timedCall(n.Stmt.Cmd); call != nil {
+					timed[call] = true
+				}
+			}
 		case *syntax.CallExpr:
 			cmd := extractCommand(n)
 			if cmd.Name != "" {
+				if len(declared) > 0 {
+					cmd.Env = append(slices.Clone(declared), cmd.Env...)
+					cmd.GitConfig = gitConfig(UnwrapCommand(cmd), cmd.Env)
+				}
+				if timed[n] {
+					cmd.Args = append([]string{"time"}, cmd.Args...)
+					cmd.Name = "time"
+					cmd.Raw = JoinArgs(cmd.Args)
+				}
+				if file, ok := stdin[n]; ok && cmd.Script == "" {
+					// "bash < deploy.sh" runs deploy.sh
+					if _, fromStdin := InterpreterScript(UnwrapCommand(cmd)); fromStdin {
+						cmd.Script = file
+					}
+				}
+				stmt.HasDynamicCommandName = stmt.HasDynamicCommandName || cmd.DynamicName
+				index[n] = len(stmt.Commands)
 				stmt.Commands = append(stmt.Commands, cmd)
+				if script, ok := shellScript(unwrappedCall(n, cmd), command); ok {
+					nested = append(nested, parseNested(stmt, script)...)
+				}
+				if script, ok := runnerScript(unwrappedCall(n, cmd), command); ok {
+					nested = append(nested, parseNested(stmt, script)...)
+				}
+				if script, ok := remoteScript(unwrappedCall(n, cmd), command); ok {
+					remote := parseNested(stmt, script)
+					for i := range remote {
+						remote[i].IsRemote = true
+					}
+					nested = append(nested, remote...)
+				}
+				if exec, ok := kubectlExec(n, cmd); ok {
+					sub := extractCommand(exec)
+					sub.Nested = true
+					stmt.HasDynamicCommandName = stmt.HasDynamicCommandName || sub.DynamicName
+					remote := []ParsedCommand{sub}
+					if script, ok := shellScript(exec, command); ok {
+						remote = append(remote, parseNested(stmt, script)...)
+					}
+					for i := range remote {
+						remote[i].IsRemote = true
+					}
+					nested = append(nested, remote...)
+				}
+				execs, deletes := findActions(n, cmd)
+				stmt.HasFindDelete = stmt.HasFindDelete || deletes
+				for _, exec := range execs {
+					sub := extractCommand(exec)
+					sub.Nested = true
+					stmt.HasDynamicCommandName = stmt.HasDynamicCommandName || sub.DynamicName
+					nested = append(nested, sub)
+					if script, ok := shellScript(exec, command); ok {
+						nested = append(nested, parseNested(stmt, script)...)
+					}
+				}
 			}
 		case *syntax.BinaryCmd:
 			// Track operators
@@ -70,24 +220,359 @@ func ParseShellCommand(command string) (*ShellStatement, error) {
 			if n.Background {
 				stmt.HasBackground = true
 			}
+			if call, ok := n.Cmd.(*syntax.CallExpr); ok {
+				for _, rdr := range n.Redirs {
+					if file, ok := fileRedirect(rdr); ok && rdr.Op == syntax.RdrIn && (rdr.N == nil || rdr.N.Value == "0") {
+						stdin[call] = file.Path
+					}
+				}
+			}
 		case *syntax.CmdSubst:
 			stmt.HasSubshell = true
 		case *syntax.Subshell:
 			stmt.HasSubshell = true
 		case *syntax.Redirect:
 			stmt.HasRedirect = true
+			if rdr, ok := fileRedirect(n); ok {
+				stmt.Redirects = append(stmt.Redirects, rdr)
+			}
 		case *syntax.ProcSubst:
 			stmt.HasProcessSubst = true
 		}
 		return true
 	})
 
-	// Second pass to extract operators between commands
-	stmt.Commands = extractWithOperators(file, stmt.Commands)
+	// Second pass to extract operators between commands. Nested commands are
+	// appended afterwards so they don't shift the operator positions.
+	stmt.Commands = extractWithOperators(file, stmt.Commands, index)
+	stmt.Commands = append(stmt.Commands, nested...)
 
 	return stmt, nil
 }
 
+// timedCall returns the command the time keyword is taken to wrap: the
+// command itself, or the first command of a timed pipeline, matching what
+// /usr/bin/time would wrap in the same text. Blocks and subshells have no
+// single command, so nil.
+func timedCall(cmd syntax.Command) *syntax.CallExpr {
+	switch c := cmd.(type) {
+	case *syntax.CallExpr:
+		return c
+	case *syntax.BinaryCmd:
+		if c.Op == syntax.Pipe || c.Op == syntax.PipeAll {
+			return timedCall(c.X.Cmd)
+		}
+	}
+	return nil
+}
+
+// unwrappedCall returns the part of call that runs once wrappers like sudo
+// and timeout are stripped, so sudo sh -c "..." is seen as sh -c "..."
+func unwrappedCall(call *syntax.CallExpr, cmd ParsedCommand) *syntax.CallExpr {
+	inner := UnwrapCommand(cmd)
+	if len(inner.Args) == 0 || len(inner.Args) >= len(call.Args) {
+		return call
+	}
+	return &syntax.CallExpr{Args: call.Args[len(call.Args)-len(inner.Args):]}
+}
+
+// shells run a script passed with -c
+var shells = map[string]bool{
+	"bash": true,
+	"sh":   true,
+	"zsh":  true,
+	"dash": true,
+	"ksh":  true,
+}
+
+// shellScript returns the script of a shell invocation like bash -c "...",
+// taken from the original source so expansions inside it are preserved
+func shellScript(call *syntax.CallExpr, source string) (string, bool) {
+	name := wordToString(call.Args[0])
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if !shells[name] {
+		return "", false
+	}
+
+	hasC := false
+	for i := 1; i < len(call.Args); i++ {
+		arg := wordToString(call.Args[i])
+		if arg == "--" {
+			// Options end; the script is the next argument
+			if hasC && i+1 < len(call.Args) {
+				return wordSource(call.Args[i+1], source), true
+			}
+			return "", false
+		}
+		if shellInterpreter.takesValue(arg) {
+			i++ // Option value, like the name after -o or the file after --rcfile
+			continue
+		}
+		if (strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "+")) && arg != "--" && len(arg) > 1 {
+			if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg, "c") {
+				hasC = true
+			}
+			continue
+		}
+		if !hasC {
+			return "", false
+		}
+		return wordSource(call.Args[i], source), true
+	}
+	return "", false
+}
+
+// findExecActions run the command that follows them, up to ; or {} +
+var findExecActions = map[string]bool{
+	"-exec":    true,
+	"-execdir": true,
+	"-ok":      true,
+	"-okdir":   true,
+}
+
+// findActions returns the commands a find command runs with -exec,
+// -execdir, -ok and -okdir, as calls of their own (rm {} for
+// find . -exec rm {} \;), and whether it uses -delete
+func findActions(call *syntax.CallExpr, cmd ParsedCommand) ([]*syntax.CallExpr, bool) {
+	inner := UnwrapCommand(cmd)
+	if GetCommandName(inner) != "find" {
+		return nil, false
+	}
+
+	var execs []*syntax.CallExpr
+	deletes := false
+	args := unwrappedCall(call, cmd).Args[1:]
+	for i := 0; i < len(args); i++ {
+		action := wordToString(args[i])
+		if action == "-delete" {
+			deletes = true
+			continue
+		}
+		if !findExecActions[action] {
+			continue
+		}
+		start := i + 1
+		end := start
+		for end < len(args) && !isFindExecEnd(args, end) {
+			end++
+		}
+		if end > start {
+			execs = append(execs, &syntax.CallExpr{Args: args[start:end]})
+		}
+		i = end
+	}
+	return execs, deletes
+}
+
+// isFindExecEnd reports whether args[i] ends an -exec command: ; (usually
+// escaped as \; or quoted) or a + directly after {}
+func isFindExecEnd(args []*syntax.Word, i int) bool {
+	switch wordToString(args[i]) {
+	case ";", "\\;":
+		return true
+	case "+":
+		return i > 0 && wordToString(args[i-1]) == "{}"
+	}
+	return false
+}
+
+// Runner describes a command whose argument is a shell command to parse,
+// located either by 1-based position (Arg) or by flag name (Flag)
+type Runner struct {
+	Command string
+	Arg     int
+	Flag    string
+}
+
+// runners holds the configured task runners, keyed by command name
+var runners = map[string][]Runner{}
+
+// SetRunners replaces the configured task runners.
+func SetRunners(list []Runner) {
+	// Runner scripts are parsed into the statement, so cached parses are stale
+	cache.clear()
+	runners = make(map[string][]Runner, len(list))
+	for _, r := range list {
+		runners[r.Command] = append(runners[r.Command], r)
+	}
+}
+
+// runnerScript returns the command passed to a configured runner, e.g. the
+// 'git push' in `make run CMD='git push'`
+func runnerScript(call *syntax.CallExpr, source string) (string, bool) {
+	defs := runners[wordToString(call.Args[0])]
+	for _, r := range defs {
+		if r.Arg > 0 {
+			if r.Arg < len(call.Args) {
+				return wordSource(call.Args[r.Arg], source), true
+			}
+			continue
+		}
+		for i := 1; i < len(call.Args); i++ {
+			arg := wordToString(call.Args[i])
+			if arg == r.Flag && i+1 < len(call.Args) {
+				return wordSource(call.Args[i+1], source), true
+			}
+			if strings.HasPrefix(arg, r.Flag+"=") {
+				return strings.TrimPrefix(arg, r.Flag+"="), true
+			}
+		}
+	}
+	return "", false
+}
+
+// remoteCommands controls whether commands passed to ssh and kubectl exec
+// are parsed
+var remoteCommands = true
+
+// SetRemoteCommands sets whether the remote command of an ssh invocation
+// (ssh host "rm -rf /data") or kubectl exec (kubectl exec web -- rm -rf
+// /data) is parsed as nested commands. On by default.
+func SetRemoteCommands(enabled bool) {
+	// Remote commands are parsed into the statement, so cached parses are stale
+	cache.clear()
+	remoteCommands = enabled
+}
+
+// sshValueFlags are the ssh options that take a value (-p 22, -i key)
+const sshValueFlags = "BbcDEeFIiJLlmOopQRSWw"
+
+// remoteScript returns the command an ssh invocation runs on the remote
+// host: the arguments after the destination, joined with spaces as ssh does
+// ("ls -la" for ssh -p 22 host ls -la). There's none for an interactive
+// login.
+func remoteScript(call *syntax.CallExpr, source string) (string, bool) {
+	if !remoteCommands {
+		return "", false
+	}
+	name := wordToString(call.Args[0])
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if name != "ssh" {
+		return "", false
+	}
+
+	i := 1
+	for ; i < len(call.Args); i++ {
+		arg := wordToString(call.Args[i])
+		if arg == "--" {
+			i++
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || len(arg) == 1 {
+			break
+		}
+		// Flags combine (-vp 22); a value flag takes the rest of the word
+		// or, if that's empty, the next argument
+		for j := 1; j < len(arg); j++ {
+			if strings.IndexByte(sshValueFlags, arg[j]) >= 0 {
+				if j == len(arg)-1 {
+					i++
+				}
+				break
+			}
+		}
+	}
+
+	// Skip the destination
+	i++
+	if i >= len(call.Args) {
+		return "", false
+	}
+	words := make([]string, 0, len(call.Args)-i)
+	for _, word := range call.Args[i:] {
+		words = append(words, wordSource(word, source))
+	}
+	return strings.Join(words, " "), true
+}
+
+// kubectlExec returns the command kubectl exec runs in a container: the
+// arguments after "--". kubectl runs it directly, without a shell.
+func kubectlExec(call *syntax.CallExpr, cmd ParsedCommand) (*syntax.CallExpr, bool) {
+	if !remoteCommands {
+		return nil, false
+	}
+	inner := UnwrapCommand(cmd)
+	if GetCommandName(inner) != "kubectl" || GetSubcommand(inner) != "exec" {
+		return nil, false
+	}
+
+	args := unwrappedCall(call, cmd).Args
+	for i, arg := range args {
+		if wordToString(arg) == "--" && i+1 < len(args) {
+			return &syntax.CallExpr{Args: args[i+1:]}, true
+		}
+	}
+	return nil, false
+}
+
+// wordSource returns the unquoted source text of a word. Unlike wordToString
+// it keeps expansions like $(...) intact so the text can be parsed again.
+func wordSource(word *syntax.Word, source string) string {
+	if len(word.Parts) == 1 {
+		switch p := word.Parts[0].(type) {
+		case *syntax.SglQuoted:
+			return p.Value
+		case *syntax.DblQuoted:
+			start, end := p.Pos().Offset()+1, p.End().Offset()-1
+			if start <= end && int(end) <= len(source) {
+				return unescapeDouble(source[start:end])
+			}
+		}
+	}
+	return wordToString(word)
+}
+
+// unescapeDouble removes the backslash escapes that are special inside
+// double quotes
+func unescapeDouble(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case '$', '`', '"', '\\':
+				i++
+			case '\n':
+				i++
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseNested parses a shell script and returns its commands marked as
+// nested, merging its shell construct flags into stmt
+func parseNested(stmt *ShellStatement, script string) []ParsedCommand {
+	inner, err := ParseShellCommand(script)
+	if err != nil {
+		if stmt.NestedError == nil {
+			stmt.NestedError = err
+		}
+		return nil
+	}
+	if inner.NestedError != nil && stmt.NestedError == nil {
+		stmt.NestedError = inner.NestedError
+	}
+
+	stmt.HasPipe = stmt.HasPipe || inner.HasPipe
+	stmt.HasBackground = stmt.HasBackground || inner.HasBackground
+	stmt.HasSubshell = stmt.HasSubshell || inner.HasSubshell
+	stmt.HasRedirect = stmt.HasRedirect || inner.HasRedirect
+	stmt.Redirects = append(stmt.Redirects, inner.Redirects...)
+	stmt.HasProcessSubst = stmt.HasProcessSubst || inner.HasProcessSubst
+	stmt.HasDynamicCommandName = stmt.HasDynamicCommandName || inner.HasDynamicCommandName
+
+	for i := range inner.Commands {
+		inner.Commands[i].Nested = true
+	}
+	return inner.Commands
+}
+
 // extractCommand extracts command info from a CallExpr node
 func extractCommand(call *syntax.CallExpr) ParsedCommand {
 	cmd := ParsedCommand{
@@ -102,12 +587,347 @@ func extractCommand(call *syntax.CallExpr) ParsedCommand {
 
 	if len(cmd.Args) > 0 {
 		cmd.Name = cmd.Args[0]
-		cmd.Raw = strings.Join(cmd.Args, " ")
+		cmd.Raw = JoinArgs(cmd.Args)
+
+		// Check the word that names the command actually run, past any wrappers
+		inner := UnwrapCommand(cmd)
+		cmd.DynamicName = isDynamicWord(call.Args[len(cmd.Args)-len(inner.Args)])
+
+		for _, assign := range call.Assigns {
+			if assign.Name == nil {
+				continue
+			}
+			value := ""
+			if assign.Value != nil {
+				value = wordToString(assign.Value)
+			}
+			cmd.Env = append(cmd.Env, assign.Name.Value+"="+value)
+		}
+		cmd.Env = append(cmd.Env, wrapperEnv(cmd)...)
+		cmd.Dir = gitDir(inner)
+		cmd.GitConfig = gitConfig(inner, cmd.Env)
+		cmd.Script, _ = InterpreterScript(inner)
 	}
 
 	return cmd
 }
 
+// declaredEnv returns the assignments made by export, declare, readonly and
+// the other declaration builtins. Flags such as -x are skipped, and a name
+// given without a value is kept with an empty one.
+func declaredEnv(decl *syntax.DeclClause) []string {
+	var env []string
+	for _, assign := range decl.Args {
+		if assign.Name == nil {
+			continue
+		}
+		value := ""
+		if assign.Value != nil {
+			value = wordToString(assign.Value)
+		}
+		env = append(env, assign.Name.Value+"="+value)
+	}
+	return env
+}
+
+// wrapperEnv returns the assignments passed through env wrappers, e.g.
+// LD_PRELOAD=x for "sudo env LD_PRELOAD=x make"
+func wrapperEnv(cmd ParsedCommand) []string {
+	var env []string
+	for {
+		inner, _, ok := unwrap(cmd)
+		if !ok {
+			return env
+		}
+		if GetCommandName(cmd) == "env" {
+			for _, arg := range cmd.Args[1 : len(cmd.Args)-len(inner.Args)] {
+				if !strings.HasPrefix(arg, "-") && isEnvAssignment(arg) {
+					env = append(env, arg)
+				}
+			}
+		}
+		cmd = inner
+	}
+}
+
+// gitDir returns the directory a git command operates on, combining -C
+// options like git does (-C a -C b is a/b) and resolving --git-dir against
+// them. It returns "" for other commands or when neither is given.
+func gitDir(cmd ParsedCommand) string {
+	if GetCommandName(cmd) != "git" {
+		return ""
+	}
+
+	dir, repo := "", ""
+	args := cmd.Args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			break // Options end at the subcommand
+		}
+		if v, found := strings.CutPrefix(arg, "--git-dir="); found {
+			repo = v
+			continue
+		}
+		if !flagTakesValue("git", arg) || i+1 >= len(args) {
+			continue
+		}
+		i++
+		switch arg {
+		case "-C":
+			dir = joinDir(dir, args[i])
+		case "--git-dir":
+			repo = args[i]
+		}
+	}
+
+	if repo != "" {
+		return joinDir(dir, repo)
+	}
+	return dir
+}
+
+// gitConfig returns the config a git command sets with its global -c and
+// --config-env options, e.g. ["core.pager=cat"] for "git -c core.pager=cat log",
+// followed by any set through the GIT_CONFIG_* variables in env
+func gitConfig(cmd ParsedCommand, env []string) []string {
+	if GetCommandName(cmd) != "git" {
+		return nil
+	}
+
+	settings := envGitConfig(env)
+	args := cmd.Args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			break // Options end at the subcommand
+		}
+		if v, found := strings.CutPrefix(arg, "--config-env="); found {
+			settings = append(settings, configEnvSetting(v))
+			continue
+		}
+		if !flagTakesValue("git", arg) || i+1 >= len(args) {
+			continue
+		}
+		i++
+		switch arg {
+		case "-c":
+			settings = append(settings, args[i])
+		case "--config-env":
+			settings = append(settings, configEnvSetting(args[i]))
+		}
+	}
+	return settings
+}
+
+// envGitConfig returns the config git reads from its environment: the
+// quoted 'key'='value' pairs of GIT_CONFIG_PARAMETERS, and each
+// GIT_CONFIG_KEY_<n> with its GIT_CONFIG_VALUE_<n>. Keys are taken whatever
+// GIT_CONFIG_COUNT says, since the count may be set elsewhere.
+func envGitConfig(env []string) []string {
+	var settings []string
+	values := make(map[string]string)
+	for _, assignment := range env {
+		name, value, _ := strings.Cut(assignment, "=")
+		if n, found := strings.CutPrefix(name, "GIT_CONFIG_VALUE_"); found {
+			values[n] = value
+		}
+	}
+	for _, assignment := range env {
+		name, value, _ := strings.Cut(assignment, "=")
+		if n, found := strings.CutPrefix(name, "GIT_CONFIG_KEY_"); found {
+			settings = append(settings, value+"="+values[n])
+			continue
+		}
+		if name != "GIT_CONFIG_PARAMETERS" {
+			continue
+		}
+		file, err := syntax.NewParser().Parse(strings.NewReader(value), "")
+		if err != nil {
+			continue
+		}
+		syntax.Walk(file, func(node syntax.Node) bool {
+			if call, ok := node.(*syntax.CallExpr); ok {
+				for _, word := range call.Args {
+					settings = append(settings, wordToString(word))
+				}
+				return false
+			}
+			return true
+		})
+	}
+	return settings
+}
+
+// interpreter describes the options of a command that runs a script file
+// named by its first operand
+type interpreter struct {
+	inline []string // Options that run code given inline or a module instead
+	values []string // Options that take a separate value
+}
+
+// takesValue reports whether an option's value is the argument after it
+func (interp interpreter) takesValue(arg string) bool {
+	return slices.Contains(interp.values, arg)
+}
+
+var shellInterpreter = interpreter{
+	inline: []string{"-c"},
+	values: []string{"-o", "+o", "-O", "+O", "--rcfile", "--init-file"},
+}
+
+var pythonInterpreter = interpreter{
+	inline: []string{"-c", "-m"},
+	values: []string{"-W", "-X"},
+}
+
+var interpreters = map[string]interpreter{
+	"bash":    shellInterpreter,
+	"sh":      shellInterpreter,
+	"zsh":     shellInterpreter,
+	"dash":    shellInterpreter,
+	"ksh":     shellInterpreter,
+	"python":  pythonInterpreter,
+	"python2": pythonInterpreter,
+	"python3": pythonInterpreter,
+	"node":    {inline: []string{"-e", "-p", "--eval", "--print"}, values: []string{"-r", "--require", "--import"}},
+	"ruby":    {inline: []string{"-e"}},
+	"perl":    {inline: []string{"-e", "-E"}},
+	"php":     {inline: []string{"-r"}},
+}
+
+// InterpreterScript returns what an interpreter runs: the script file it's
+// given, e.g. "deploy.sh" for "bash deploy.sh" or "source deploy.sh", or
+// fromStdin when it reads the script from stdin ("bash", "bash -s",
+// "python -"). Both are empty for other commands and for code given inline
+// with an option like -c.
+func InterpreterScript(cmd ParsedCommand) (script string, fromStdin bool) {
+	name := GetCommandName(cmd)
+	if name == "source" || name == "." {
+		args := cmd.Args[1:]
+		if len(args) > 0 && args[0] == "--" {
+			args = args[1:]
+		}
+		if len(args) > 0 {
+			return args[0], false
+		}
+		return "", false
+	}
+	interp, ok := interpreters[name]
+	if !ok {
+		return "", false
+	}
+
+	args := cmd.Args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			// What follows is the script file, if anything
+			if i+1 < len(args) {
+				return args[i+1], false
+			}
+			return "", true
+		case arg == "-":
+			return "", true
+		case len(arg) > 1 && (strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "+")):
+			if slices.Contains(interp.inline, arg) {
+				return "", false
+			}
+			if shells[name] && !strings.HasPrefix(arg, "--") && !interp.takesValue(arg) {
+				// Combined short options, as in bash -xc or bash -es
+				if strings.ContainsRune(arg[1:], 'c') {
+					return "", false
+				}
+				if strings.ContainsRune(arg[1:], 's') {
+					return "", true
+				}
+			}
+			if interp.takesValue(arg) {
+				i++
+			}
+		default:
+			return arg, false
+		}
+	}
+	return "", true
+}
+
+// configEnvSetting renders a --config-env value (key=VAR) as key=$VAR, since
+// the value comes from the variable
+func configEnvSetting(v string) string {
+	key, envVar, found := strings.Cut(v, "=")
+	if !found {
+		return v
+	}
+	return key + "=$" + envVar
+}
+
+// joinDir resolves next against base the way a shell resolves cd
+func joinDir(base, next string) string {
+	if base == "" || path.IsAbs(next) {
+		return path.Clean(next)
+	}
+	return path.Join(base, next)
+}
+
+// EnvName returns the variable name of a NAME=value assignment
+func EnvName(assignment string) string {
+	name, _, _ := strings.Cut(assignment, "=")
+	return name
+}
+
+// isDynamicWord reports whether a word's value depends on a parameter
+// expansion or command substitution
+func isDynamicWord(word *syntax.Word) bool {
+	return hasDynamicPart(word.Parts)
+}
+
+func hasDynamicPart(parts []syntax.WordPart) bool {
+	for _, part := range parts {
+		switch p := part.(type) {
+		case *syntax.ParamExp, *syntax.CmdSubst:
+			return true
+		case *syntax.DblQuoted:
+			if hasDynamicPart(p.Parts) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// JoinArgs joins args into command text, double-quoting args that contain
+// whitespace, quotes, backslashes, shell operators or characters the shell
+// expands so they read back as one literal arg: git commit -m "fix the build". The quoting is canonical rather
+// than the command's own, so "/" and '/' both read as /, and quoting can't
+// be used to slip past a deny pattern.
+func JoinArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteArg(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// quoteArg double-quotes an arg if JoinArgs would otherwise split or
+// misread it, escaping the characters double quotes don't protect
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\"'\\;&|`$<>()*?[]{}~#!") {
+		return arg
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range arg {
+		if c == '"' || c == '\\' || c == '`' || c == '$' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
 // wordToString converts a syntax.Word to a string
 func wordToString(word *syntax.Word) string {
 	var parts []string
@@ -136,13 +956,24 @@ func partToString(part syntax.WordPart) string {
 	case *syntax.CmdSubst:
 		// Return command substitution indicator
 		return "$(...)"
+	case *syntax.ProcSubst:
+		// Return process substitution indicator; the inner commands are
+		// extracted separately by the AST walk
+		if p.Op == syntax.CmdOut {
+			return ">(...)"
+		}
+		return "<(...)"
 	default:
 		return ""
 	}
 }
 
-// extractWithOperators does a second pass to capture operators between commands
-func extractWithOperators(file *syntax.File, commands []ParsedCommand) []ParsedCommand {
+// extractWithOperators does a second pass to capture operators between
+// commands. index maps each call to its position in commands. An operator is
+// recorded on the last command before it: in "a && (b; c) | d", c gets "|".
+// Statements separated by ; or a newline get ";", and a background
+// statement gets "&".
+func extractWithOperators(file *syntax.File, commands []ParsedCommand, index map[*syntax.CallExpr]int) []ParsedCommand {
 	if len(commands) == 0 {
 		return commands
 	}
@@ -150,28 +981,58 @@ func extractWithOperators(file *syntax.File, commands []ParsedCommand) []ParsedC
 	result := make([]ParsedCommand, len(commands))
 	copy(result, commands)
 
-	idx := 0
+	setOperator := func(node syntax.Node, op string) {
+		if call := lastCall(node, index); call != nil && result[index[call]].Operator == "" {
+			result[index[call]].Operator = op
+		}
+	}
+	separate := func(stmts []*syntax.Stmt) {
+		for i, s := range stmts {
+			if s.Background {
+				setOperator(s, "&")
+			} else if i < len(stmts)-1 {
+				setOperator(s, ";")
+			}
+		}
+	}
+
 	syntax.Walk(file, func(node syntax.Node) bool {
 		switch n := node.(type) {
-		case *syntax.BinaryCmd:
-			if idx < len(result) {
-				switch n.Op {
-				case syntax.AndStmt:
-					result[idx].Operator = "&&"
-				case syntax.OrStmt:
-					result[idx].Operator = "||"
-				case syntax.Pipe:
-					result[idx].Operator = "|"
-				case syntax.PipeAll:
-					result[idx].Operator = "|&"
-				}
-				idx++
-			}
 		case *syntax.Stmt:
-			// Handle semicolon-separated statements
-			if idx > 0 && idx < len(result) && result[idx-1].Operator == "" {
-				result[idx-1].Operator = ";"
+			if call := lastCall(n, index); n.Negated && call != nil {
+				result[index[call]].Negated = true
+			}
+		case *syntax.BinaryCmd:
+			switch n.Op {
+			case syntax.AndStmt:
+				setOperator(n.X, "&&")
+			case syntax.OrStmt:
+				setOperator(n.X, "||")
+			case syntax.Pipe:
+				setOperator(n.X, "|")
+			case syntax.PipeAll:
+				setOperator(n.X, "|&")
 			}
+		case *syntax.File:
+			separate(n.Stmts)
+		case *syntax.Block:
+			separate(n.Stmts)
+		case *syntax.Subshell:
+			separate(n.Stmts)
+		case *syntax.CmdSubst:
+			separate(n.Stmts)
+		case *syntax.ProcSubst:
+			separate(n.Stmts)
+		case *syntax.IfClause:
+			separate(n.Cond)
+			separate(n.Then)
+		case *syntax.WhileClause:
+			separate(n.Cond)
+			separate(n.Do)
+		case *syntax.ForClause:
+			separate(n.Do)
+		case *syntax.CaseItem:
+			separate(n.Stmts)
 		}
 		return true
 	})
@@ -179,6 +1040,32 @@ func extractWithOperators(file *syntax.File, commands []ParsedCommand) []ParsedC
 	return result
 }
 
+// lastCall returns the last extracted command run by node itself, skipping
+// commands inside substitutions in its arguments: for "echo $(date)" that's
+// echo, not date. If node ends with something that isn't an extracted
+// command, such as [[ -d x ]], (( n )), let or a bare assignment, there is
+// none: in "ls && [[ -d x ]] || rm y" the || belongs to the test, not ls.
+func lastCall(node syntax.Node, index map[*syntax.CallExpr]int) *syntax.CallExpr {
+	var last *syntax.CallExpr
+	syntax.Walk(node, func(n syntax.Node) bool {
+		switch n := n.(type) {
+		case *syntax.CallExpr:
+			last = nil
+			if _, ok := index[n]; ok {
+				last = n
+			}
+			return false
+		case *syntax.TestClause, *syntax.ArithmCmd, *syntax.LetClause, *syntax.DeclClause:
+			last = nil
+			return false
+		case *syntax.CmdSubst, *syntax.ProcSubst:
+			return false
+		}
+		return true
+	})
+	return last
+}
+
 // GetCommandName returns the base command name (handles paths like /usr/bin/git -> git)
 func GetCommandName(cmd ParsedCommand) string {
 	name := cmd.Name
@@ -192,10 +1079,14 @@ func GetCommandName(cmd ParsedCommand) string {
 
 var valueFlagsByCommand = map[string]map[string]bool{
 	"git": {
-		"-C":          true,
-		"--git-dir":   true,
-		"--work-tree": true,
-		"-c":          true,
+		"-C":             true,
+		"--git-dir":      true,
+		"--work-tree":    true,
+		"-c":             true,
+		"--config-env":   true,
+		"--namespace":    true,
+		"--super-prefix": true,
+		"--attr-source":  true,
 	},
 	"dotnet": {
 		"--project": true,
@@ -226,6 +1117,57 @@ var valueFlagsByCommand = map[string]map[string]bool{
 		"-u": true,
 		"-C": true,
 	},
+	"docker": {
+		"-H":                  true,
+		"--host":              true,
+		"--context":           true,
+		"--config":            true,
+		"-f":                  true,
+		"--file":              true,
+		"-p":                  true,
+		"--project-name":      true,
+		"--project-directory": true,
+		"--env-file":          true,
+		"--profile":           true,
+	},
+	"nice": {
+		"-n":           true,
+		"--adjustment": true,
+	},
+	"npx": {
+		"-p":        true,
+		"--package": true,
+		"-c":        true,
+		"--call":    true,
+	},
+	"kubectl": {
+		"-n":               true,
+		"--namespace":      true,
+		"--context":        true,
+		"--cluster":        true,
+		"--user":           true,
+		"--kubeconfig":     true,
+		"-s":               true,
+		"--server":         true,
+		"--as":             true,
+		"--token":          true,
+		"-l":               true,
+		"--selector":       true,
+		"--field-selector": true,
+		"-o":               true,
+		"--output":         true,
+		"-f":               true,
+		"--filename":       true,
+		"-c":               true,
+		"--container":      true,
+	},
+	"pnpm dlx": {
+		"--package": true,
+	},
+	"yarn dlx": {
+		"-p":        true,
+		"--package": true,
+	},
 }
 
 func flagTakesValue(cmdName, flag string) bool {
@@ -280,85 +1222,252 @@ func isEnvAssignment(arg string) bool {
 // For "git commit -m msg", returns "commit"
 // For "dotnet build", returns "build"
 func GetSubcommand(cmd ParsedCommand) string {
-	if len(cmd.Args) > 1 {
-		cmdName := GetCommandName(cmd)
-		args := cmd.Args[1:]
-		for i := 0; i < len(args); i++ {
-			arg := args[i]
-			if strings.HasPrefix(arg, "-") {
-				if flagTakesValue(cmdName, arg) && i+1 < len(args) {
-					i++
-				}
-				continue
+	if positional := positionalArgs(cmd, 1); len(positional) > 0 {
+		return positional[0]
+	}
+	return ""
+}
+
+// wrapperCommands run another command given as their arguments
+var wrapperCommands = map[string]bool{
+	"timeout": true,
+	"env":     true,
+	"sudo":    true,
+	"nice":    true,
+	"nohup":   true,
+	"time":    true,
+	"npx":     true,
+}
+
+// dlxCommands are package managers whose "dlx" subcommand wraps a command
+var dlxCommands = map[string]bool{
+	"pnpm": true,
+	"yarn": true,
+}
+
+// unwrap returns the command run by a wrapper like timeout, env or sudo,
+// along with the wrapper's label for signatures (e.g. "sudo", "pnpm dlx")
+func unwrap(cmd ParsedCommand) (ParsedCommand, string, bool) {
+	name := GetCommandName(cmd)
+	label := name
+	args := cmd.Args[1:]
+	if dlxCommands[name] && len(args) > 0 && args[0] == "dlx" {
+		label = name + " dlx"
+		args = args[1:]
+	} else if !wrapperCommands[name] {
+		return cmd, "", false
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "-") {
+			if flagTakesValue(label, arg) && i+1 < len(args) {
+				i++
 			}
-			return arg
+			continue
+		}
+		if name == "timeout" && isNumeric(arg) {
+			continue
+		}
+		if name == "env" && isEnvAssignment(arg) {
+			continue
 		}
+		actualArgs := args[i:]
+		return ParsedCommand{
+			Name:     actualArgs[0],
+			Args:     actualArgs,
+			Raw:      JoinArgs(actualArgs),
+			Operator: cmd.Operator,
+			Nested:   cmd.Nested,
+		}, label, true
+	}
+	return cmd, "", false
+}
+
+// UnwrapCommand strips wrapper commands (e.g. "sudo timeout 30 chmod -R x .")
+// and returns the command that actually runs
+func UnwrapCommand(cmd ParsedCommand) ParsedCommand {
+	for {
+		inner, _, ok := unwrap(cmd)
+		if !ok {
+			return cmd
+		}
+		cmd = inner
 	}
-	return ""
 }
 
 // CommandSignature returns a canonical representation of the command for matching
 // e.g., "git add" for "git add -A .", "timeout dotnet run" for "timeout 30 dotnet run"
 func CommandSignature(cmd ParsedCommand) string {
+	cmd = NormalizeCommand(cmd)
 	name := GetCommandName(cmd)
 
-	// Special handling for wrapper commands like timeout, env, sudo
-	wrappers := map[string]bool{
-		"timeout": true,
-		"env":     true,
-		"sudo":    true,
-		"nice":    true,
-		"nohup":   true,
-		"time":    true,
+	// Special handling for wrapper commands like timeout, env, sudo, npx
+	if actualCmd, label, ok := unwrap(cmd); ok {
+		actualCmd = NormalizeCommand(actualCmd)
+		actualName := GetCommandName(actualCmd)
+		if subs := subcommandPath(actualName, actualCmd); len(subs) > 0 {
+			return label + " " + actualName + " " + strings.Join(subs, " ")
+		}
+		return label + " " + actualName
 	}
 
-	if wrappers[name] {
-		args := cmd.Args[1:]
-		actualIdx := -1
-		for i := 0; i < len(args); i++ {
-			arg := args[i]
-			if strings.HasPrefix(arg, "-") {
-				if flagTakesValue(name, arg) && i+1 < len(args) {
-					i++
-				}
-				continue
-			}
-			if name == "timeout" && isNumeric(arg) {
-				continue
-			}
-			if name == "env" && isEnvAssignment(arg) {
-				continue
-			}
-			actualIdx = i
-			break
+	// For normal commands, include subcommands if present
+	if subs := subcommandPath(name, cmd); len(subs) > 0 {
+		return name + " " + strings.Join(subs, " ")
+	}
+
+	return name
+}
+
+// defaultAliases map standalone commands to the equivalent subcommand form
+// so one rule covers both (e.g. docker-compose and docker compose)
+var defaultAliases = map[string][]string{
+	"docker-compose": {"docker", "compose"},
+}
+
+// commandAliases holds the default aliases plus any configured ones
+var commandAliases = defaultAliases
+
+// SetAliases adds configured aliases (e.g. "python3" -> "python",
+// "dc" -> "docker compose") to the defaults. A configured alias replaces a
+// default with the same name.
+func SetAliases(aliases map[string]string) {
+	merged := make(map[string][]string, len(defaultAliases)+len(aliases))
+	for alias, canonical := range defaultAliases {
+		merged[alias] = canonical
+	}
+	for alias, canonical := range aliases {
+		if words := strings.Fields(canonical); len(words) > 0 {
+			merged[alias] = words
 		}
-		if actualIdx >= 0 && actualIdx < len(args) {
-			actualArgs := args[actualIdx:]
-			actualCmd := ParsedCommand{
-				Name: actualArgs[0],
-				Args: actualArgs,
-				Raw:  strings.Join(actualArgs, " "),
-			}
-			actualName := GetCommandName(actualCmd)
-			if isSubcommandCommand(actualName) {
-				subCmd := GetSubcommand(actualCmd)
-				if subCmd != "" && !strings.HasPrefix(subCmd, "-") && !strings.HasPrefix(subCmd, "/") {
-					return name + " " + actualName + " " + subCmd
-				}
-			}
-			return name + " " + actualName
+	}
+	commandAliases = merged
+}
+
+// NormalizeCommand rewrites aliased commands to their canonical form, e.g.
+// docker-compose up to docker compose up
+func NormalizeCommand(cmd ParsedCommand) ParsedCommand {
+	canonical, ok := commandAliases[GetCommandName(cmd)]
+	if !ok {
+		return cmd
+	}
+	args := append(append([]string{}, canonical...), cmd.Args[1:]...)
+	cmd.Name = canonical[0]
+	cmd.Args = args
+	return cmd
+}
+
+// NormalizeSignature rewrites aliased commands in a rule's signature, e.g.
+// "docker-compose up" to "docker compose up"
+func NormalizeSignature(sig string) string {
+	words := strings.Fields(sig)
+	var normalized []string
+	for _, w := range words {
+		if canonical, ok := commandAliases[w]; ok {
+			normalized = append(normalized, canonical...)
+			continue
 		}
+		normalized = append(normalized, w)
 	}
+	return strings.Join(normalized, " ")
+}
 
-	// For normal commands, include subcommand if present
-	if isSubcommandCommand(name) {
-		subCmd := GetSubcommand(cmd)
-		if subCmd != "" && !strings.HasPrefix(subCmd, "-") && !strings.HasPrefix(subCmd, "/") {
-			return name + " " + subCmd
+// nestedSubcommands are subcommands that take a subcommand of their own, so
+// signatures go one level deeper (e.g. "gh pr create", "git remote add")
+var nestedSubcommands = map[string]bool{
+	"git remote":       true,
+	"git stash":        true,
+	"git worktree":     true,
+	"git submodule":    true,
+	"gh pr":            true,
+	"gh issue":         true,
+	"gh repo":          true,
+	"gh release":       true,
+	"gh run":           true,
+	"gh workflow":      true,
+	"gh auth":          true,
+	"kubectl get":      true,
+	"kubectl describe": true,
+	"kubectl delete":   true,
+	"kubectl rollout":  true,
+	"kubectl config":   true,
+	"docker compose":   true,
+	"docker container": true,
+	"docker image":     true,
+	"docker network":   true,
+	"docker volume":    true,
+}
+
+// signatureDepths overrides how many subcommand levels a tool's signature
+// includes, e.g. {"az": 3} for "az vm disk attach"
+var signatureDepths = map[string]int{}
+
+// SetSignatureDepths replaces the per-tool signature depth overrides.
+func SetSignatureDepths(depths map[string]int) {
+	signatureDepths = make(map[string]int, len(depths))
+	for tool, depth := range depths {
+		signatureDepths[tool] = depth
+	}
+}
+
+// subcommandPath returns the subcommands included in a command's signature:
+// one level by default, two for nestedSubcommands, or the configured depth
+// (which also applies to tools outside the subcommand list)
+func subcommandPath(name string, cmd ParsedCommand) []string {
+	depth, configured := signatureDepths[name]
+	if !configured {
+		if !isSubcommandCommand(name) {
+			return nil
 		}
+		depth = 2
 	}
 
-	return name
+	var subs []string
+	for _, arg := range positionalArgs(cmd, depth) {
+		// kubectl names a resource as TYPE/NAME (pod/web-1); the type is
+		// the subcommand level
+		if name == "kubectl" && len(subs) > 0 {
+			if kind, _, ok := strings.Cut(arg, "/"); ok && kind != "" {
+				subs = append(subs, kind)
+				break
+			}
+		}
+		if strings.HasPrefix(arg, "/") || (len(subs) > 0 && strings.Contains(arg, "/")) {
+			break
+		}
+		subs = append(subs, arg)
+	}
+	if !configured && len(subs) == 2 && !nestedSubcommands[name+" "+subs[0]] {
+		subs = subs[:1]
+	}
+	return subs
+}
+
+// positionalArgs returns up to n non-flag arguments of a command, skipping
+// flags and the values of flags known to take one. Arguments after a "--"
+// terminator are operands (files, script args), never subcommands.
+func positionalArgs(cmd ParsedCommand, n int) []string {
+	var positional []string
+	if len(cmd.Args) < 2 {
+		return nil
+	}
+	cmdName := GetCommandName(cmd)
+	args := cmd.Args[1:]
+	for i := 0; i < len(args) && len(positional) < n; i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "-") {
+			if flagTakesValue(cmdName, arg) && i+1 < len(args) {
+				i++
+			}
+			continue
+		}
+		positional = append(positional, arg)
+	}
+	return positional
 }
 
 func isNumeric(s string) bool {
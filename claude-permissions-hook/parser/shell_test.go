@@ -1,7 +1,12 @@
 package parser
 
 import (
+	"slices"
+	"strings"
 	"testing"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/syntax"
 )
 
 func TestParseSimpleCommand(t *testing.T) {
@@ -109,6 +114,55 @@ func TestParseCompoundCommand(t *testing.T) {
 			wantSigs:  []string{"test", "echo"},
 			wantOps:   []string{"||", ""},
 		},
+		{
+			name:      "newline separated",
+			input:     "git add -A\ngit commit -m x\ngit push",
+			wantCount: 3,
+			wantSigs:  []string{"git add", "git commit", "git push"},
+			wantOps:   []string{";", ";", ""},
+		},
+		{
+			name:      "newlines mixed with operators",
+			input:     "git add -A\n\n# commit and push\ngit commit -m x && git push\nls | wc -l",
+			wantCount: 5,
+			wantSigs:  []string{"git add", "git commit", "git push", "ls", "wc"},
+			wantOps:   []string{";", "&&", ";", "|", ""},
+		},
+		{
+			name:      "semicolons and background",
+			input:     "make build; make test & echo started",
+			wantCount: 3,
+			wantSigs:  []string{"make", "make", "echo"},
+			wantOps:   []string{";", "&", ""},
+		},
+		{
+			name:      "operator after subshell",
+			input:     "ls && (cd src; make) | tee log",
+			wantCount: 4,
+			wantSigs:  []string{"ls", "cd", "make", "tee"},
+			wantOps:   []string{"&&", ";", "|", ""},
+		},
+		{
+			name:      "substitution in arguments",
+			input:     "echo $(date; whoami) && ls",
+			wantCount: 4,
+			wantSigs:  []string{"echo", "date", "whoami", "ls"},
+			wantOps:   []string{"&&", ";", "", ""},
+		},
+		{
+			name:      "operator after test clause",
+			input:     "ls && [[ -d build ]] || rm -rf build",
+			wantCount: 2,
+			wantSigs:  []string{"ls", "rm"},
+			wantOps:   []string{"&&", ""},
+		},
+		{
+			name:      "operator after arithmetic and assignment",
+			input:     "ls && (( n > 0 )) || X=1 && rm -rf build",
+			wantCount: 2,
+			wantSigs:  []string{"ls", "rm"},
+			wantOps:   []string{"&&", ""},
+		},
 	}
 
 	for _, tt := range tests {
@@ -127,6 +181,119 @@ func TestParseCompoundCommand(t *testing.T) {
 				if i < len(tt.wantSigs) && sig != tt.wantSigs[i] {
 					t.Errorf("command[%d] signature = %q, want %q", i, sig, tt.wantSigs[i])
 				}
+				if i < len(tt.wantOps) && cmd.Operator != tt.wantOps[i] {
+					t.Errorf("command[%d] operator = %q, want %q", i, cmd.Operator, tt.wantOps[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRawQuoting(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantRaw string
+	}{
+		{`git commit -m "fix the build"`, `git commit -m "fix the build"`},
+		{`git commit -m 'fix the build'`, `git commit -m "fix the build"`},
+		{`git commit -m fix`, `git commit -m fix`},
+		{`rm -rf "/"`, `rm -rf /`},
+		{`echo 'say "hi"' 'a\b'`, `echo "say \"hi\"" "a\\b"`},
+		{`echo "a;b" "x|y" ""`, `echo "a;b" "x|y" ""`},
+		{`grep -r "$HOME dir" .`, `grep -r "\${HOME} dir" .`},
+		{`echo '>x' '$(id)' 'a*' '(x)'`, `echo ">x" "\$(id)" "a*" "(x)"`},
+		{`echo '~' '#x' '!x' '[a]' '{a,b}' 'a?' '<y'`, `echo "~" "#x" "!x" "[a]" "{a,b}" "a?" "<y"`},
+		{`sudo git commit -m "two words"`, `sudo git commit -m "two words"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			cmd := stmt.Commands[0]
+			if cmd.Raw != tt.wantRaw {
+				t.Errorf("Raw = %s, want %s", cmd.Raw, tt.wantRaw)
+			}
+
+			// Raw reads back as the same literal args. Expansions are
+			// only compared as text, since Raw shows them escaped.
+			want, literal := literalArgs(t, tt.input)
+			got, _ := literalArgs(t, cmd.Raw)
+			if literal && !slices.Equal(got, want) {
+				t.Errorf("Raw args = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// literalArgs returns the first command's args in src with quotes and
+// escapes removed, and whether none of them expands anything
+func literalArgs(t *testing.T, src string) ([]string, bool) {
+	t.Helper()
+	file, err := syntax.NewParser().Parse(strings.NewReader(src), "")
+	if err != nil {
+		t.Fatalf("parsing %s: %v", src, err)
+	}
+	var args []string
+	literal := true
+	syntax.Walk(file, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.ParamExp, *syntax.CmdSubst, *syntax.ArithmExp, *syntax.ProcSubst:
+			literal = false
+		case *syntax.CallExpr:
+			if args != nil {
+				break
+			}
+			for _, word := range n.Args {
+				value, err := expand.Literal(nil, word)
+				if err != nil {
+					t.Fatalf("expanding %s: %v", src, err)
+				}
+				args = append(args, value)
+			}
+		}
+		return true
+	})
+	return args, literal
+}
+
+func TestParseControlStructures(t *testing.T) {
+	tests := []struct {
+		input    string
+		wantSigs []string
+	}{
+		{"if [ -f x ]; then rm x; fi", []string{"[", "rm"}},
+		{"if [[ -f x ]]; then rm x; elif test -d y; then ls; else pwd; fi", []string{"rm", "test", "ls", "pwd"}},
+		{"for f in *.go; do gofmt -l $f; done", []string{"gofmt"}},
+		{"for f in $(ls); do rm $f; done", []string{"ls", "rm"}},
+		{"for ((i=0; i<3; i++)); do echo $i; done", []string{"echo"}},
+		{"while read l; do echo $l; done < f", []string{"read", "echo"}},
+		{"until false; do sleep 1; done", []string{"false", "sleep"}},
+		{"case $x in a) rm a;; *) ls;; esac", []string{"rm", "ls"}},
+		{"(( i++ ))", nil},
+		{"let i=1", nil},
+		{"(( $(rm x) ))", []string{"rm"}},
+		{"[[ -n $(id -u) ]] && rm y", []string{"id", "rm"}},
+	}
+
+	keywords := []string{"if", "then", "elif", "else", "fi", "for", "in", "do", "done", "while", "until", "case", "esac", "[[", "]]", "((", "))", "let"}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			var sigs []string
+			for _, cmd := range stmt.Commands {
+				if slices.Contains(keywords, cmd.Name) {
+					t.Errorf("keyword %q extracted as a command", cmd.Name)
+				}
+				sigs = append(sigs, CommandSignature(cmd))
+			}
+			if !slices.Equal(sigs, tt.wantSigs) {
+				t.Errorf("signatures = %q, want %q", sigs, tt.wantSigs)
 			}
 		})
 	}
@@ -205,6 +372,66 @@ func TestParseWrapperCommands(t *testing.T) {
 			input:   "env npm test",
 			wantSig: "env npm test",
 		},
+		{
+			name:    "npx tool",
+			input:   "npx prettier --write .",
+			wantSig: "npx prettier",
+		},
+		{
+			name:    "npx with yes flag",
+			input:   "npx -y prettier --check src",
+			wantSig: "npx prettier",
+		},
+		{
+			name:    "npx with package flag",
+			input:   "npx --package typescript tsc --noEmit",
+			wantSig: "npx tsc",
+		},
+		{
+			name:    "npx with short package flag",
+			input:   "npx -p @angular/cli ng build",
+			wantSig: "npx ng",
+		},
+		{
+			name:    "pnpm dlx",
+			input:   "pnpm dlx create-vite my-app",
+			wantSig: "pnpm dlx create-vite",
+		},
+		{
+			name:    "yarn dlx with package",
+			input:   "yarn dlx -p typescript tsc",
+			wantSig: "yarn dlx tsc",
+		},
+		{
+			name:    "nohup in background",
+			input:   "nohup npm start &",
+			wantSig: "nohup npm start",
+		},
+		{
+			name:    "time keyword",
+			input:   "time git status",
+			wantSig: "time git status",
+		},
+		{
+			name:    "time keyword with posix flag",
+			input:   "time -p cargo build",
+			wantSig: "time cargo build",
+		},
+		{
+			name:    "time binary",
+			input:   "/usr/bin/time -v git status",
+			wantSig: "time git status",
+		},
+		{
+			name:    "nice with adjustment",
+			input:   "nice -n 10 cargo build",
+			wantSig: "nice cargo build",
+		},
+		{
+			name:    "pnpm without dlx is not a wrapper",
+			input:   "pnpm run build",
+			wantSig: "pnpm run",
+		},
 	}
 
 	for _, tt := range tests {
@@ -226,6 +453,43 @@ func TestParseWrapperCommands(t *testing.T) {
 	}
 }
 
+func TestTimeKeywordAndBinary(t *testing.T) {
+	tests := []struct {
+		input    string
+		wantSigs []string
+	}{
+		// The keyword and the binary sign alike
+		{"time git status", []string{"time git status"}},
+		{"/usr/bin/time git status", []string{"time git status"}},
+		{"time -p git status", []string{"time git status"}},
+		{"/usr/bin/time -p git status", []string{"time git status"}},
+		// The keyword times a whole pipeline; its first command carries
+		// time, as with the binary
+		{"time git status | grep x", []string{"time git status", "grep"}},
+		{"/usr/bin/time git status | grep x", []string{"time git status", "grep"}},
+		{"time git status && ls", []string{"time git status", "ls"}},
+		// Blocks have no single command to wrap
+		{"time { git status; }", []string{"git status"}},
+		{"time", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			var sigs []string
+			for _, cmd := range stmt.Commands {
+				sigs = append(sigs, CommandSignature(cmd))
+			}
+			if !slices.Equal(sigs, tt.wantSigs) {
+				t.Errorf("signatures = %q, want %q", sigs, tt.wantSigs)
+			}
+		})
+	}
+}
+
 func TestDetectDangerousConstructs(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -325,3 +589,885 @@ func TestPathBasename(t *testing.T) {
 		})
 	}
 }
+
+func TestParseProcessSubstitution(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantSigs []string
+		wantArgs []string
+	}{
+		{
+			name:     "input substitution",
+			input:    "diff <(cat a) <(cat b)",
+			wantSigs: []string{"diff", "cat", "cat"},
+			wantArgs: []string{"diff", "<(...)", "<(...)"},
+		},
+		{
+			name:     "output substitution",
+			input:    "tee >(rm -rf /tmp/x) < input",
+			wantSigs: []string{"tee", "rm"},
+			wantArgs: []string{"tee", ">(...)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+
+			if !stmt.HasProcessSubst {
+				t.Error("HasProcessSubst = false, want true")
+			}
+			if len(stmt.Commands) != len(tt.wantSigs) {
+				t.Fatalf("command count = %d, want %d", len(stmt.Commands), len(tt.wantSigs))
+			}
+			for i, cmd := range stmt.Commands {
+				if sig := CommandSignature(cmd); sig != tt.wantSigs[i] {
+					t.Errorf("command[%d] signature = %q, want %q", i, sig, tt.wantSigs[i])
+				}
+			}
+			if got := strings.Join(stmt.Commands[0].Args, " "); got != strings.Join(tt.wantArgs, " ") {
+				t.Errorf("outer args = %q, want %q", got, strings.Join(tt.wantArgs, " "))
+			}
+		})
+	}
+}
+
+func TestParseEmptyCommand(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantEmpty bool
+	}{
+		{"", true},
+		{"   ", true},
+		{"# noop", true},
+		{"\n# one\n# two\n", true},
+		{"FOO=bar", false},
+		{"ls # list", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			if stmt.IsEmpty != tt.wantEmpty {
+				t.Errorf("IsEmpty = %v, want %v", stmt.IsEmpty, tt.wantEmpty)
+			}
+		})
+	}
+}
+
+func TestParseShellDashC(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		wantSigs   []string
+		wantNested []bool
+	}{
+		{
+			name:       "bash -c double quoted",
+			input:      `bash -c "rm -rf /"`,
+			wantSigs:   []string{"bash", "rm"},
+			wantNested: []bool{false, true},
+		},
+		{
+			name:       "sh -c single quoted compound",
+			input:      `sh -c 'git add -A && git commit -m "x"'`,
+			wantSigs:   []string{"sh", "git add", "git commit"},
+			wantNested: []bool{false, true, true},
+		},
+		{
+			name:       "combined flags and path",
+			input:      `/bin/bash -ec "ls"`,
+			wantSigs:   []string{"bash", "ls"},
+			wantNested: []bool{false, true},
+		},
+		{
+			name:       "nested twice",
+			input:      `bash -c "sh -c 'whoami'"`,
+			wantSigs:   []string{"bash", "sh", "whoami"},
+			wantNested: []bool{false, true, true},
+		},
+		{
+			name:       "sudo wrapper",
+			input:      `sudo sh -c "rm -rf /"`,
+			wantSigs:   []string{"sudo sh", "rm"},
+			wantNested: []bool{false, true},
+		},
+		{
+			name:       "stacked wrappers",
+			input:      `sudo timeout 30 bash -c 'git push'`,
+			wantSigs:   []string{"sudo timeout", "git push"},
+			wantNested: []bool{false, true},
+		},
+		{
+			name:       "shopt option before -c",
+			input:      `bash -O extglob -c 'rm x'`,
+			wantSigs:   []string{"bash", "rm"},
+			wantNested: []bool{false, true},
+		},
+		{
+			name:       "unset shopt option before -c",
+			input:      `bash +O extglob -c 'rm x'`,
+			wantSigs:   []string{"bash", "rm"},
+			wantNested: []bool{false, true},
+		},
+		{
+			name:       "rcfile before -c",
+			input:      `bash --rcfile /dev/null -c 'rm x'`,
+			wantSigs:   []string{"bash", "rm"},
+			wantNested: []bool{false, true},
+		},
+		{
+			name:       "init-file before -c",
+			input:      `bash --init-file /dev/null -c 'rm x'`,
+			wantSigs:   []string{"bash", "rm"},
+			wantNested: []bool{false, true},
+		},
+		{
+			name:       "double dash before the script",
+			input:      `sh -c -- 'rm x'`,
+			wantSigs:   []string{"sh", "rm"},
+			wantNested: []bool{false, true},
+		},
+		{
+			name:       "script file is not recursed",
+			input:      "bash script.sh",
+			wantSigs:   []string{"bash"},
+			wantNested: []bool{false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			if len(stmt.Commands) != len(tt.wantSigs) {
+				t.Fatalf("command count = %d, want %d", len(stmt.Commands), len(tt.wantSigs))
+			}
+			for i, cmd := range stmt.Commands {
+				if sig := CommandSignature(cmd); sig != tt.wantSigs[i] {
+					t.Errorf("command[%d] signature = %q, want %q", i, sig, tt.wantSigs[i])
+				}
+				if cmd.Nested != tt.wantNested[i] {
+					t.Errorf("command[%d] Nested = %v, want %v", i, cmd.Nested, tt.wantNested[i])
+				}
+			}
+		})
+	}
+
+	stmt, err := ParseShellCommand(`bash -c "echo 'unterminated"`)
+	if err != nil {
+		t.Fatalf("ParseShellCommand() error = %v", err)
+	}
+	if stmt.NestedError == nil {
+		t.Error("expected NestedError for unparseable -c script")
+	}
+}
+
+func TestParseRunnerScript(t *testing.T) {
+	SetRunners([]Runner{
+		{Command: "just", Arg: 2},
+		{Command: "make", Flag: "CMD"},
+	})
+	defer SetRunners(nil)
+
+	tests := []struct {
+		name     string
+		input    string
+		wantSigs []string
+	}{
+		{"positional arg", `just recipe 'git push --force'`, []string{"just", "git push"}},
+		{"variable assignment", `make run CMD="rm -rf build"`, []string{"make", "rm"}},
+		{"missing arg", `just recipe`, []string{"just"}},
+		{"wrapped", `sudo just recipe 'git push'`, []string{"sudo just", "git push"}},
+		{"unconfigured runner", `npm run 'git push'`, []string{"npm run"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand(%q) error = %v", tt.input, err)
+			}
+			var sigs []string
+			for _, cmd := range stmt.Commands {
+				sigs = append(sigs, CommandSignature(cmd))
+			}
+			if strings.Join(sigs, ",") != strings.Join(tt.wantSigs, ",") {
+				t.Errorf("ParseShellCommand(%q) signatures = %v, want %v", tt.input, sigs, tt.wantSigs)
+			}
+		})
+	}
+}
+
+func TestNestedSubcommandSignatures(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantSig string
+	}{
+		{"gh pr create --fill", "gh pr create"},
+		{"gh pr merge 123 --squash", "gh pr merge"},
+		{"gh --repo owner/repo pr view 1", "gh pr view"},
+		{"gh api repos/owner/repo", "gh api"},
+		{"git remote add origin git@example.com:x.git", "git remote add"},
+		{"git remote -v", "git remote"},
+		{"git commit -m msg", "git commit"},
+		{"kubectl get pods -n default", "kubectl get pods"},
+		{"kubectl get pod/web-1", "kubectl get pod"},
+		{"kubectl get -n kube-system pods", "kubectl get pods"},
+		{"kubectl -n prod --context staging delete pod web-1", "kubectl delete pod"},
+		{"kubectl delete -f deploy.yaml", "kubectl delete"},
+		{"kubectl exec -it web-1 -- sh", "kubectl exec"},
+		{"kubectl apply -f deploy.yaml", "kubectl apply"},
+		{"docker compose up -d", "docker compose up"},
+		{"timeout 60 gh pr checks", "timeout gh pr checks"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand(%q) error = %v", tt.input, err)
+			}
+			if sig := CommandSignature(stmt.Commands[0]); sig != tt.wantSig {
+				t.Errorf("CommandSignature(%q) = %q, want %q", tt.input, sig, tt.wantSig)
+			}
+		})
+	}
+}
+
+func TestSignatureDepthOverride(t *testing.T) {
+	SetSignatureDepths(map[string]int{"az": 3, "gh": 1, "mytool": 2})
+	defer SetSignatureDepths(nil)
+
+	tests := []struct {
+		input   string
+		wantSig string
+	}{
+		{"az vm disk attach --name d1", "az vm disk attach"},
+		{"az login", "az login"},
+		{"gh pr create", "gh pr"},
+		{"mytool db migrate --dry-run", "mytool db migrate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand(%q) error = %v", tt.input, err)
+			}
+			if sig := CommandSignature(stmt.Commands[0]); sig != tt.wantSig {
+				t.Errorf("CommandSignature(%q) = %q, want %q", tt.input, sig, tt.wantSig)
+			}
+		})
+	}
+}
+
+func TestDockerComposeNormalization(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantSig string
+	}{
+		{"docker compose up -d", "docker compose up"},
+		{"docker-compose up -d", "docker compose up"},
+		{"/usr/local/bin/docker-compose logs -f web", "docker compose logs"},
+		{"docker compose -f docker-compose.prod.yml up", "docker compose up"},
+		{"docker-compose -f docker-compose.prod.yml -p app down", "docker compose down"},
+		{"sudo docker-compose restart", "sudo docker compose restart"},
+		{"docker-compose", "docker compose"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand(%q) error = %v", tt.input, err)
+			}
+			if sig := CommandSignature(stmt.Commands[0]); sig != tt.wantSig {
+				t.Errorf("CommandSignature(%q) = %q, want %q", tt.input, sig, tt.wantSig)
+			}
+		})
+	}
+}
+
+func TestCommandAliases(t *testing.T) {
+	SetAliases(map[string]string{
+		"python3": "python",
+		"py":      "python",
+		"g":       "git",
+		"dc":      "docker compose",
+	})
+	defer SetAliases(nil)
+
+	tests := []struct {
+		input   string
+		wantSig string
+	}{
+		{"python3 script.py", "python"},
+		{"py -m pytest", "python"},
+		{"/usr/bin/python3 manage.py migrate", "python"},
+		{"g commit -m msg", "git commit"},
+		{"dc up -d", "docker compose up"},
+		{"timeout 30 g push", "timeout git push"},
+		{"docker-compose ps", "docker compose ps"},
+		{"python script.py", "python"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand(%q) error = %v", tt.input, err)
+			}
+			if sig := CommandSignature(stmt.Commands[0]); sig != tt.wantSig {
+				t.Errorf("CommandSignature(%q) = %q, want %q", tt.input, sig, tt.wantSig)
+			}
+		})
+	}
+}
+
+func TestParseDynamicCommandName(t *testing.T) {
+	tests := []struct {
+		input       string
+		wantDynamic bool
+	}{
+		{"$FOO bar", true},
+		{"${CMD} --force", true},
+		{"$(echo rm) -rf /", true},
+		{"`echo rm` -rf /", true},
+		{`"$TOOL" run`, true},
+		{"sudo $CMD", true},
+		{"ls && $CMD", true},
+		{`bash -c '$CMD arg'`, true},
+		{"echo $FOO", false},
+		{"git commit -m \"$(date)\"", false},
+		{"'$FOO' bar", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand(%q) error = %v", tt.input, err)
+			}
+			if stmt.HasDynamicCommandName != tt.wantDynamic {
+				t.Errorf("HasDynamicCommandName = %v, want %v", stmt.HasDynamicCommandName, tt.wantDynamic)
+			}
+		})
+	}
+}
+
+func TestParseEnvAssignments(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"git status", nil},
+		{"LD_PRELOAD=/tmp/x.so make", []string{"LD_PRELOAD=/tmp/x.so"}},
+		{"A=1 B= make", []string{"A=1", "B="}},
+		{"env -i FOO=bar npm test", []string{"FOO=bar"}},
+		{"X=1 sudo env Y=2 make", []string{"X=1", "Y=2"}},
+		{"echo FOO=bar", nil},
+
+		// Declared variables reach the commands after them
+		{"export LD_PRELOAD=/tmp/x.so && make", []string{"LD_PRELOAD=/tmp/x.so"}},
+		{"declare -x A=1 B=2; make", []string{"A=1", "B=2"}},
+		{"readonly A=1; export B; C=3 make", []string{"A=1", "B=", "C=3"}},
+		{"make; export A=1", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			got := stmt.Commands[len(stmt.Commands)-1].Env
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("Env = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGitDir(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantDir string
+		wantSig string
+	}{
+		{"git push", "", "git push"},
+		{"git -C /etc push", "/etc", "git push"},
+		{"git -C /srv -C repo status", "/srv/repo", "git status"},
+		{"git -C /srv -C /other status", "/other", "git status"},
+		{"git --git-dir=/srv/repo/.git log", "/srv/repo/.git", "git log"},
+		{"git -C /srv --git-dir .git fetch", "/srv/.git", "git fetch"},
+		{"sudo git -C /etc/ push", "/etc", "sudo git push"},
+		{"git commit -C HEAD", "", "git commit"},
+		{"ls -C /etc", "", "ls"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			cmd := stmt.Commands[0]
+			if cmd.Dir != tt.wantDir {
+				t.Errorf("Dir = %q, want %q", cmd.Dir, tt.wantDir)
+			}
+			if sig := CommandSignature(cmd); sig != tt.wantSig {
+				t.Errorf("signature = %q, want %q", sig, tt.wantSig)
+			}
+		})
+	}
+}
+
+func TestParseGitConfig(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"git log", nil},
+		{"git -c core.pager=cat log", []string{"core.pager=cat"}},
+		{`git -c core.sshCommand="ssh -i key" -c user.name=x fetch`, []string{"core.sshCommand=ssh -i key", "user.name=x"}},
+		{"git -c core.fsmonitor status", []string{"core.fsmonitor"}},
+		{"git --config-env=core.editor=EDITOR commit", []string{"core.editor=$EDITOR"}},
+		{"git --config-env core.editor=EDITOR commit", []string{"core.editor=$EDITOR"}},
+		{"git -C /srv -c alias.x=!sh x", []string{"alias.x=!sh"}},
+		{"sudo git -c core.pager=less log", []string{"core.pager=less"}},
+		{"git log -c core.pager=cat", nil}, // After the subcommand, -c is a log option
+		{"grep -c core.pager=cat file", nil},
+
+		// Config passed through the environment
+		{`GIT_CONFIG_PARAMETERS="'core.pager'='sh -c id' 'user.name'='x'" git log`, []string{"core.pager=sh -c id", "user.name=x"}},
+		{"GIT_CONFIG_COUNT=1 GIT_CONFIG_KEY_0=core.pager GIT_CONFIG_VALUE_0=sh git log", []string{"core.pager=sh"}},
+		{"export GIT_CONFIG_KEY_0=include.path; git log", []string{"include.path="}},
+		{"env GIT_CONFIG_PARAMETERS='alias.x=!sh' git x", []string{"alias.x=!sh"}},
+		{"GIT_CONFIG_KEY_0=core.pager make", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			if got := stmt.Commands[len(stmt.Commands)-1].GitConfig; !slices.Equal(got, tt.want) {
+				t.Errorf("GitConfig = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNegated(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []bool
+	}{
+		{"! true || curl x", []bool{true, false}},
+		{"! true | cat || curl x", []bool{false, true, false}}, // ! negates the pipeline's status
+		{"false || curl x", []bool{false, false}},
+		{"! { ls; true; } && x", []bool{false, true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			var got []bool
+			for _, cmd := range stmt.Commands {
+				got = append(got, cmd.Negated)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Negated = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"bash deploy.sh", "deploy.sh"},
+		{"/bin/bash ./deploy.sh --prod", "./deploy.sh"},
+		{"sh -x scripts/build.sh", "scripts/build.sh"},
+		{"bash -o pipefail run.sh", "run.sh"},
+		{"sudo bash /opt/install.sh", "/opt/install.sh"},
+		{"python manage.py runserver", "manage.py"},
+		{"python3 -W ignore -u tool.py", "tool.py"},
+		{"/usr/bin/env node build.js", "build.js"},
+		{"node -r dotenv/config build.js", "build.js"},
+		{"ruby -- script.rb", "script.rb"},
+		{`bash -c "echo hi"`, ""},
+		{`bash -xc "echo hi"`, ""},
+		{"python -m pytest tests", ""},
+		{"python -c 'print(1)'", ""},
+		{"node -e 'console.log(1)'", ""},
+		{"bash -s -- arg", ""},
+		{"bash -", ""},
+		{"bash", ""},
+		{"cat deploy.sh", ""},
+		{"bash --rcfile x deploy.sh", "deploy.sh"},
+		{"bash -r deploy.sh", "deploy.sh"},
+		{"source deploy.sh", "deploy.sh"},
+		{". ./deploy.sh prod", "./deploy.sh"},
+		{"bash < deploy.sh", "deploy.sh"},
+		{"sudo bash -s < deploy.sh", "deploy.sh"},
+		{"time bash < deploy.sh", "deploy.sh"},
+		{"bash run.sh < input.txt", "run.sh"},
+		{"bash 3< deploy.sh", ""},
+		{"cat < deploy.sh", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			if got := stmt.Commands[0].Script; got != tt.want {
+				t.Errorf("Script = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArgumentTerminator(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantSig string
+		wantSub string
+	}{
+		{"npm run build -- --watch", "npm run", "run"},
+		{"git checkout -- file", "git checkout", "checkout"},
+		{"git stash -- src/main.go", "git stash", "stash"},
+		{"git stash push -- src/main.go", "git stash push", "stash"},
+		{"git log -- somefile", "git log", "log"},
+		{"git -- status", "git", ""},
+		{"docker compose -- up", "docker compose", "compose"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			cmd := stmt.Commands[0]
+			if sig := CommandSignature(cmd); sig != tt.wantSig {
+				t.Errorf("signature = %q, want %q", sig, tt.wantSig)
+			}
+			if sub := GetSubcommand(cmd); sub != tt.wantSub {
+				t.Errorf("subcommand = %q, want %q", sub, tt.wantSub)
+			}
+		})
+	}
+}
+
+func TestParseShellCommandCached(t *testing.T) {
+	SetCacheSize(2)
+	defer SetCacheSize(0)
+
+	first, err := ParseShellCommandCached("git add -A && git commit -m x")
+	if err != nil {
+		t.Fatalf("ParseShellCommandCached() error = %v", err)
+	}
+	// Modifying a returned statement doesn't corrupt the cache
+	first.Commands[0].Args[0] = "rm"
+	first.Commands = first.Commands[:1]
+
+	second, err := ParseShellCommandCached("git add -A && git commit -m x")
+	if err != nil {
+		t.Fatalf("ParseShellCommandCached() error = %v", err)
+	}
+	want, _ := ParseShellCommand("git add -A && git commit -m x")
+	if len(second.Commands) != len(want.Commands) {
+		t.Fatalf("cached statement has %d commands, want %d", len(second.Commands), len(want.Commands))
+	}
+	for i := range want.Commands {
+		if second.Commands[i].Raw != want.Commands[i].Raw || second.Commands[i].Args[0] != want.Commands[i].Args[0] {
+			t.Errorf("command %d = %+v, want %+v", i, second.Commands[i], want.Commands[i])
+		}
+	}
+
+	// Nor does modifying its git config or redirects
+	third, _ := ParseShellCommandCached("git -c core.pager=cat log > out.txt")
+	third.Commands[0].GitConfig[0] = "user.name=x"
+	third.Redirects[0].Path = "/dev/null"
+	fourth, _ := ParseShellCommandCached("git -c core.pager=cat log > out.txt")
+	if fourth.Commands[0].GitConfig[0] != "core.pager=cat" || fourth.Redirects[0].Path != "out.txt" {
+		t.Errorf("cached statement changed: GitConfig = %q, Redirects = %+v", fourth.Commands[0].GitConfig, fourth.Redirects)
+	}
+
+	// Errors aren't cached and the least recently used entry is evicted
+	if _, err := ParseShellCommandCached("echo 'unterminated"); err == nil {
+		t.Error("ParseShellCommandCached() of invalid input succeeded")
+	}
+	ParseShellCommandCached("ls")
+	ParseShellCommandCached("pwd")
+	if _, ok := cache.get("git add -A && git commit -m x"); ok {
+		t.Error("oldest entry was not evicted")
+	}
+	if _, ok := cache.get("pwd"); !ok {
+		t.Error("newest entry is missing")
+	}
+
+	// Changing runners invalidates cached parses
+	SetRunners([]Runner{{Command: "just", Arg: 2}})
+	defer SetRunners(nil)
+	if _, ok := cache.get("pwd"); ok {
+		t.Error("cache survived SetRunners")
+	}
+}
+
+func BenchmarkParseShellCommand(b *testing.B) {
+	command := "timeout 30 dotnet test --no-build && git add -A && git commit -m 'update' | tee log.txt"
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ParseShellCommand(command)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		SetCacheSize(128)
+		defer SetCacheSize(0)
+		for i := 0; i < b.N; i++ {
+			ParseShellCommandCached(command)
+		}
+	})
+}
+
+func TestParseFindExec(t *testing.T) {
+	tests := []struct {
+		input      string
+		wantNested []string
+		wantDelete bool
+	}{
+		{`find . -name "*.tmp" -exec rm {} \;`, []string{"rm"}, false},
+		{`find . -name "*.tmp" -exec rm -f {} ';'`, []string{"rm"}, false},
+		{`find . -type f -execdir chmod 644 {} +`, []string{"chmod"}, false},
+		{`find . -ok mv {} /tmp \; -exec ls {} \;`, []string{"mv", "ls"}, false},
+		{`find . -exec sh -c 'git push' \;`, []string{"sh", "git"}, false},
+		{`find /tmp -name "*.log" -delete`, nil, true},
+		{`sudo find /tmp -mtime +7 -exec rm {} \; -delete`, []string{"rm"}, true},
+		{`find . -name "*.go"`, nil, false},
+		{`time find`, nil, false},
+		{`time find . -exec rm -rf / \;`, []string{"rm"}, false},
+		{`time find . -exec rm {} \; | wc -l`, []string{"rm"}, false},
+		{`time sudo find /tmp -delete`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			var nested []string
+			for _, cmd := range stmt.Commands {
+				if cmd.Nested {
+					nested = append(nested, cmd.Name)
+				}
+			}
+			if !slices.Equal(nested, tt.wantNested) {
+				t.Errorf("nested commands = %q, want %q", nested, tt.wantNested)
+			}
+			if stmt.HasFindDelete != tt.wantDelete {
+				t.Errorf("HasFindDelete = %v, want %v", stmt.HasFindDelete, tt.wantDelete)
+			}
+		})
+	}
+}
+
+func TestParseSSHRemoteCommand(t *testing.T) {
+	tests := []struct {
+		input      string
+		wantRemote []string // Signatures of the remote commands
+	}{
+		{`ssh host "ls"`, []string{"ls"}},
+		{`ssh -p 22 host "rm -rf /"`, []string{"rm"}},
+		{`ssh -vp22 -i ~/.ssh/key user@host ls -la`, []string{"ls"}},
+		{`ssh -o StrictHostKeyChecking=no host 'cd /srv && git pull'`, []string{"cd", "git pull"}},
+		{`ssh -- host "uptime"`, []string{"uptime"}},
+		{`/usr/bin/ssh host "bash -c 'rm -rf /data'"`, []string{"bash", "rm"}},
+		{`sudo ssh h rm -rf /`, []string{"rm"}},
+		{`sudo timeout 5 ssh h rm -rf /`, []string{"rm"}},
+		{`time ssh -p 22 h "git push"`, []string{"git push"}},
+		{`ssh host`, nil},
+		{`ssh -p 22 host`, nil},
+		{`scp file host:/tmp`, nil},
+		{`kubectl exec web-1 -- ls -la`, []string{"ls"}},
+		{`kubectl -n prod exec -it web-1 -c app -- sh -c "rm -rf /data"`, []string{"sh", "rm"}},
+		{`kubectl exec web-1`, nil},
+		{`time kubectl exec web-1 -- rm -rf /data`, []string{"rm"}},
+		{`time kubectl exec web-1`, nil},
+		{`kubectl get pods -- ls`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			var remote []string
+			for _, cmd := range stmt.Commands {
+				if cmd.IsRemote {
+					if !cmd.Nested {
+						t.Errorf("remote command %q isn't marked nested", cmd.Raw)
+					}
+					remote = append(remote, CommandSignature(cmd))
+				}
+			}
+			if !slices.Equal(remote, tt.wantRemote) {
+				t.Errorf("remote commands = %q, want %q", remote, tt.wantRemote)
+			}
+		})
+	}
+
+	SetRemoteCommands(false)
+	defer SetRemoteCommands(true)
+	stmt, err := ParseShellCommand(`ssh host "rm -rf /"`)
+	if err != nil {
+		t.Fatalf("ParseShellCommand() error = %v", err)
+	}
+	if len(stmt.Commands) != 1 {
+		t.Errorf("with remote commands off: got %d commands, want only ssh", len(stmt.Commands))
+	}
+}
+
+func TestParseRedirects(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []Redirect
+	}{
+		{"cat < /etc/shadow", []Redirect{{"<", "/etc/shadow"}}},
+		{"echo x > out.txt", []Redirect{{">", "out.txt"}}},
+		{"echo x >> 'log file'", []Redirect{{">>", "log file"}}},
+		{"make &> build.log 2>&1", []Redirect{{"&>", "build.log"}}},
+		{"exec 3<> /dev/tcp/host/80", []Redirect{{"<>", "/dev/tcp/host/80"}}},
+		{"bash -c 'wc -l < .env'", []Redirect{{"<", ".env"}}},
+		// Heredocs and dynamic targets open no known file
+		{"cat <<EOF\nhi\nEOF", nil},
+		{"cat <<< hi", nil},
+		{"echo x > \"$OUT\"", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			if !slices.Equal(stmt.Redirects, tt.want) {
+				t.Errorf("Redirects = %v, want %v", stmt.Redirects, tt.want)
+			}
+		})
+	}
+
+	reads := Redirect{Op: "<"}
+	writes := Redirect{Op: ">>"}
+	if !reads.Reads() || reads.Writes() || writes.Reads() || !writes.Writes() {
+		t.Errorf("< reads = %v, writes = %v; >> reads = %v, writes = %v",
+			reads.Reads(), reads.Writes(), writes.Reads(), writes.Writes())
+	}
+}
+
+func TestHasCombinedFlags(t *testing.T) {
+	tests := []struct {
+		input string
+		flags []string
+		want  bool
+	}{
+		{"rm -rf build", []string{"r", "f"}, true},
+		{"rm -fr build", []string{"r", "f"}, true},
+		{"rm -r -f build", []string{"r", "f"}, true},
+		{"rm -f -r build", []string{"r", "f"}, true},
+		{"rm -Rf build", []string{"r", "f"}, true},
+		{"rm -rfv build", []string{"r", "f"}, true},
+		{"rm --recursive --force build", []string{"r", "f"}, true},
+		{"rm -r --force build", []string{"r", "f"}, true},
+		{"rm --force -R build", []string{"r", "f"}, true},
+		{"rm --recur --forc /", []string{"r", "f"}, true},
+		{"rm --rec --f /", []string{"r", "f"}, true},
+		{"rm --recursive=x --verb build", []string{"r", "f"}, false},
+		{"cp --arch src dst", []string{"a"}, true},
+		{"sudo rm -rf /", []string{"r", "f"}, true},
+		{"/bin/rm -rf build", []string{"r", "f"}, true},
+		{"rm -rf build", []string{"recursive", "--force"}, true},
+		{"rm -r build", []string{"r", "f"}, false},
+		{"rm -f build", []string{"r", "f"}, false},
+		{"rm build", []string{"r", "f"}, false},
+		{"rm -- -rf", []string{"r", "f"}, false},
+		{"rm -rf build", nil, false},
+		{"git push --force", []string{"f"}, true},
+		{"git push -f", []string{"force"}, true},
+		{"git push --force-with-lease", []string{"f"}, false},
+		{"git -C -rf status", []string{"r"}, false},
+		{"chmod -R 755 .", []string{"recursive"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			if got := HasCombinedFlags(stmt.Commands[0], tt.flags); got != tt.want {
+				t.Errorf("HasCombinedFlags(%q, %q) = %v, want %v", tt.input, tt.flags, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitValueFlagsSignature(t *testing.T) {
+	// Values of git's global options can look like subcommands; they must
+	// be skipped rather than read as the subcommand
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`git commit -m "push to prod"`, "git commit"},
+		{`git -c user.name=push commit -m "push to prod"`, "git commit"},
+		{`git -c push commit`, "git commit"},
+		{`git -c core.editor="git push" commit`, "git commit"},
+		{`git -cuser.name=push commit`, "git commit"},
+		{`git -c a=push -c b=reset log`, "git log"},
+		{`git -p -c a=push log`, "git log"},
+		{`git -c alias.ship=push ship`, "git ship"},
+		{`git --config-env=x=push status`, "git status"},
+		{`git --config-env x=push status`, "git status"},
+		{`git --namespace push log`, "git log"},
+		{`git --namespace=push log`, "git log"},
+		{`git --super-prefix push status`, "git status"},
+		{`git --attr-source push status`, "git status"},
+		{`git -C push status`, "git status"},
+		{`git --git-dir push log`, "git log"},
+		{`git --work-tree push log`, "git log"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stmt, err := ParseShellCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseShellCommand() error = %v", err)
+			}
+			if got := CommandSignature(stmt.Commands[0]); got != tt.want {
+				t.Errorf("CommandSignature(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
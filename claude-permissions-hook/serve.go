@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/config"
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/hook"
+	"github.com/asbjornb/claude-hooks/claude-permissions-hook/matcher"
+)
+
+// serveCmd evaluates hook inputs read one per line from stdin, writing one
+// decision per line to stdout, so the config is loaded and compiled once
+func serveCmd(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to TOML configuration file")
+	configDir := fs.String("config-dir", "", "Directory of TOML files to load in lexical order and merge")
+	configInline := fs.String("config-inline", "", "TOML configuration text")
+	failOpen := fs.Bool("fail-open", false, "On malformed input lines, fall back to the normal permission prompt (overrides fail_mode)")
+	failClosed := fs.Bool("fail-closed", false, "On malformed input lines, deny the tool use (overrides fail_mode)")
+	enableTags := fs.String("enable-tags", "", "Comma-separated tags; only tagged rules with one of these tags apply")
+	disableTags := fs.String("disable-tags", "", "Comma-separated tags; rules with any of these tags are skipped")
+	watch := fs.Bool("watch", false, "Reload the config when it changes")
+	watchInterval := fs.Duration("watch-interval", time.Second, "With --watch, how often to check the config for changes")
+	fs.Parse(args)
+
+	path, err := resolveConfigPath(*configPath, *configDir, *configInline)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *watch && *configInline != "" {
+		fmt.Fprintln(os.Stderr, "Error: --watch needs --config or --config-dir")
+		os.Exit(1)
+	}
+	if *failOpen && *failClosed {
+		fmt.Fprintln(os.Stderr, "Error: --fail-open and --fail-closed are mutually exclusive")
+		os.Exit(1)
+	}
+	if *watchInterval <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: --watch-interval must be positive")
+		os.Exit(1)
+	}
+
+	// Fingerprint before loading, so a change made while loading is
+	// picked up by the first poll
+	watcher := newConfigWatcher(path, *configDir)
+	cfg, err := loadConfig(path, *configDir, *configInline)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	warnConfig(cfg)
+	failMode := ""
+	if *failOpen {
+		failMode = config.FailOpen
+	} else if *failClosed {
+		failMode = config.FailClosed
+	}
+
+	s := newServer(cfg, failMode, splitList(*enableTags), splitList(*disableTags))
+	if *watch {
+		go watcher.run(*watchInterval, s.reloads)
+	}
+
+	if err := s.serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// server holds the config and matcher that serve evaluates against
+type server struct {
+	cfg         *config.Config
+	m           *matcher.Matcher
+	failMode    string // --fail-open/--fail-closed override, or "" for the config's
+	enableTags  []string
+	disableTags []string
+
+	// reloads carries configs loaded by a configWatcher. They're applied
+	// between input lines rather than by the watcher, since building a
+	// Matcher also updates the parser's settings and must not race with an
+	// evaluation.
+	reloads chan *config.Config
+}
+
+func newServer(cfg *config.Config, failMode string, enableTags, disableTags []string) *server {
+	s := &server{
+		failMode:    failMode,
+		enableTags:  enableTags,
+		disableTags: disableTags,
+		reloads:     make(chan *config.Config, 1),
+	}
+	s.apply(cfg)
+	return s
+}
+
+// apply switches to a loaded and compiled config
+func (s *server) apply(cfg *config.Config) {
+	hook.SetMaxReasonLength(cfg.Settings.ReasonLimit())
+	configureAudit(cfg.Audit)
+	m := matcher.New(cfg)
+	if len(s.enableTags) > 0 || len(s.disableTags) > 0 {
+		m.SetTagFilter(s.enableTags, s.disableTags)
+	}
+	s.cfg, s.m = cfg, m
+}
+
+// applyReload applies a pending reload, if there is one
+func (s *server) applyReload() {
+	select {
+	case cfg := <-s.reloads:
+		s.apply(cfg)
+		fmt.Fprintln(os.Stderr, "Reloaded config")
+	default:
+	}
+}
+
+// effectiveFailMode returns the --fail-open/--fail-closed override, or the
+// config's fail mode without one
+func (s *server) effectiveFailMode() string {
+	if s.failMode != "" {
+		return s.failMode
+	}
+	return s.cfg.FailMode
+}
+
+// serve answers each non-blank line of in with a line of decision JSON on
+// out until in is exhausted. A line that isn't valid hook input gets the
+// fail mode's decision and doesn't stop the loop.
+func (s *server) serve(in io.Reader, out io.Writer) error {
+	// Decisions are flushed per line so callers can wait on each answer
+	bw := bufio.NewWriter(out)
+	w := hook.NewWriter(bw)
+	r := bufio.NewReader(in)
+	for {
+		// ReadBytes has no line length limit, unlike bufio.Scanner, and
+		// Write inputs can carry whole files
+		line, readErr := r.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("failed to read input: %w", readErr)
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			s.applyReload()
+			s.handle(w, line)
+			if err := bw.Flush(); err != nil {
+				return fmt.Errorf("failed to write output: %w", err)
+			}
+		}
+
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
+// handle evaluates one line of hook input and writes its decision. A panic
+// while evaluating gets the fail mode's decision rather than ending the
+// server for every later line.
+func (s *server) handle(w *hook.Writer, line []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "Error evaluating input: %v\n", r)
+			w.WriteOutput(failureOutput(s.effectiveFailMode(), "internal error evaluating hook input"))
+		}
+	}()
+
+	input, err := hook.ParseInput(line)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+		w.WriteOutput(failureOutput(s.effectiveFailMode(), "failed to read hook input"))
+		return
+	}
+	result, ok := s.m.Match(input)
+	if !ok {
+		w.WritePassthrough(noRulesReason(input.ToolName))
+		return
+	}
+	respond(w, s.cfg, input, result, false, "")
+}
+
+// configWatcher detects changes to a config file or directory by polling a
+// hash of its contents, which catches rewrites that keep the size and
+// modification time
+type configWatcher struct {
+	path, dir string
+	sum       [sha256.Size]byte
+}
+
+func newConfigWatcher(path, dir string) *configWatcher {
+	w := &configWatcher{path: path, dir: dir}
+	w.sum, _ = w.checksum()
+	return w
+}
+
+// checksum hashes the config file, or the names and contents of the
+// directory's .toml files
+func (w *configWatcher) checksum() ([sha256.Size]byte, error) {
+	h := sha256.New()
+	paths := []string{w.path}
+	if w.dir != "" {
+		var err error
+		if paths, err = filepath.Glob(filepath.Join(w.dir, "*.toml")); err != nil {
+			return [sha256.Size]byte{}, err
+		}
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return [sha256.Size]byte{}, err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", filepath.Base(path), len(data))
+		h.Write(data)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// poll reloads the config if it changed since the last poll. It returns nil
+// when nothing changed, and an error when the new config doesn't load; an
+// unchanged broken config isn't reported again.
+func (w *configWatcher) poll() (*config.Config, error) {
+	sum, err := w.checksum()
+	if err != nil {
+		// Likely mid-write by an editor; try again next poll
+		return nil, nil
+	}
+	if sum == w.sum {
+		return nil, nil
+	}
+	w.sum = sum
+	return loadConfig(w.path, w.dir, "")
+}
+
+// run polls for changes every interval, sending each valid new config to
+// reloads. An invalid config is logged and the previous one stays in use.
+func (w *configWatcher) run(interval time.Duration, reloads chan *config.Config) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cfg, err := w.poll()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reloading config, keeping the previous one: %v\n", err)
+			continue
+		}
+		if cfg == nil {
+			continue
+		}
+		// Replace a reload that hasn't been applied yet
+		select {
+		case <-reloads:
+		default:
+		}
+		reloads <- cfg
+	}
+}
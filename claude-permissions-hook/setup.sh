@@ -51,7 +51,7 @@ echo -e "${YELLOW}Installing hook...${NC}"
 mkdir -p "$(dirname "$SETTINGS_FILE")"
 
 HOOK_COMMAND="${BINARY_PATH} run --config ${CONFIG_FILE}"
-MATCHER="Bash|Read|Write|Edit|Skill"
+MATCHER="Bash|Read|Write|Edit|Grep|Glob|Skill"
 
 # Create the new hook entry
 NEW_HOOK=$(cat <<EOF
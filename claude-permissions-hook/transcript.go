@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// transcriptLine is the part of a Claude Code transcript line that analyze
+// reads. Transcripts are JSONL, one event per line; assistant messages carry
+// a content array whose tool_use blocks hold the tool name and input. Other
+// event types and fields are ignored, since the format isn't a stable API.
+type transcriptLine struct {
+	Type    string `json:"type"`
+	Message struct {
+		Content json.RawMessage `json:"content"`
+	} `json:"message"`
+}
+
+// transcriptBlock is one content block of an assistant message
+type transcriptBlock struct {
+	Type  string                 `json:"type"`
+	Name  string                 `json:"name"`
+	Input map[string]interface{} `json:"input"`
+}
+
+// transcriptFields maps the tools analyze groups to the input field holding
+// their command or path
+var transcriptFields = map[string]string{
+	"Bash":  "command",
+	"Read":  "file_path",
+	"Write": "file_path",
+	"Edit":  "file_path",
+}
+
+// readTranscript extracts the Bash commands and file tool paths used in a
+// Claude Code transcript, keeping only the last n (all of them if n is 0).
+// Lines that aren't JSON are counted as skipped; lines of any other shape,
+// such as user messages or summaries, are passed over.
+func readTranscript(r io.Reader, n int) (uses []ToolUse, skipped int, err error) {
+	br := bufio.NewReader(r)
+	for {
+		// Lines can hold whole file contents, so there's no length limit
+		line, readErr := br.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return nil, 0, readErr
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var entry transcriptLine
+			if err := json.Unmarshal(line, &entry); err != nil {
+				skipped++
+			} else if entry.Type == "assistant" {
+				uses = append(uses, transcriptToolUses(entry.Message.Content)...)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	if n > 0 && len(uses) > n {
+		uses = uses[len(uses)-n:]
+	}
+	return uses, skipped, nil
+}
+
+// transcriptToolUses returns the tool uses in a message's content. Content
+// that is plain text, or blocks without the expected input field, yield
+// nothing.
+func transcriptToolUses(content json.RawMessage) []ToolUse {
+	var blocks []transcriptBlock
+	if json.Unmarshal(content, &blocks) != nil {
+		return nil
+	}
+	var uses []ToolUse
+	for _, block := range blocks {
+		field, ok := transcriptFields[block.Name]
+		if block.Type != "tool_use" || !ok {
+			continue
+		}
+		if value, ok := block.Input[field].(string); ok && value != "" {
+			uses = append(uses, ToolUse{Tool: block.Name, Value: value})
+		}
+	}
+	return uses
+}